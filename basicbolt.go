@@ -0,0 +1,128 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"errors"
+	stormmsg "github.com/jsgilmore/gostorm/messages"
+)
+
+// BasicBolt is a bolt of which every tuple is acked or failed when
+// Execute returns, like BaseBasicBolt in Java. The tuples emitted with
+// the collector passed to Execute are anchored to the tuple being
+// executed. The tuple is acked if Execute returns nil and is failed
+// otherwise. A BasicBolt is run by adapting it to a Bolt with
+// NewBasicBolt. The optional interfaces of bolts, such as Ticker, may
+// be implemented by a BasicBolt.
+type BasicBolt interface {
+	FieldsFactory
+	Prepare(context *stormmsg.Context, collector BasicOutputCollector)
+	Execute(meta stormmsg.BoltMsgMeta, collector BasicOutputCollector, fields ...interface{}) error
+	Cleanup()
+}
+
+// BasicOutputCollector is the output collector of a BasicBolt, which
+// anchors emitted tuples to the tuple being executed. Tuples emitted
+// with the collector passed to Prepare are not anchored.
+type BasicOutputCollector interface {
+	LevelLogger
+	MetricsCollector
+	Log(msg string)
+	ReportError(err error)
+	Emit(stream string, fields ...interface{}) (taskIds []int32)
+	EmitWithTaskIds(stream string, fields ...interface{}) (taskIds []int32)
+	EmitDirect(stream string, directTask int64, fields ...interface{})
+}
+
+// ReportedFailure is returned by the Execute function of a BasicBolt to
+// report an error to Storm, where it is shown in the Storm UI, in
+// addition to failing the tuple
+type ReportedFailure struct {
+	Err error
+}
+
+func (this *ReportedFailure) Error() string {
+	return this.Err.Error()
+}
+
+func (this *ReportedFailure) Unwrap() error {
+	return this.Err
+}
+
+// NewBasicBolt returns a Bolt that acks or fails every tuple after
+// passing it to the Execute function of the basic bolt
+func NewBasicBolt(bolt BasicBolt) Bolt {
+	return &basicBolt{
+		bolt: bolt,
+	}
+}
+
+type basicBolt struct {
+	bolt      BasicBolt
+	collector OutputCollector
+}
+
+func (this *basicBolt) Prepare(context *stormmsg.Context, collector OutputCollector) {
+	this.collector = collector
+	this.bolt.Prepare(context, &basicCollector{OutputCollector: collector})
+}
+
+func (this *basicBolt) Execute(meta stormmsg.BoltMsgMeta, fields ...interface{}) {
+	collector := &basicCollector{
+		OutputCollector: this.collector,
+		anchors:         []string{meta.Id},
+	}
+	err := this.bolt.Execute(meta, collector, fields...)
+	if err == nil {
+		this.collector.SendAck(meta.Id)
+		return
+	}
+	var reported *ReportedFailure
+	if errors.As(err, &reported) {
+		this.collector.ReportError(reported.Err)
+	}
+	this.collector.SendFail(meta.Id)
+}
+
+func (this *basicBolt) Cleanup() {
+	this.bolt.Cleanup()
+}
+
+func (this *basicBolt) Fields() []interface{} {
+	return this.bolt.Fields()
+}
+
+// Unwrap returns the basic bolt, so that its optional interfaces are found
+func (this *basicBolt) Unwrap() interface{} {
+	return this.bolt
+}
+
+// basicCollector anchors the tuples it emits to the tuple being executed
+type basicCollector struct {
+	OutputCollector
+	anchors []string
+}
+
+func (this *basicCollector) Emit(stream string, fields ...interface{}) (taskIds []int32) {
+	return this.OutputCollector.Emit(this.anchors, stream, fields...)
+}
+
+func (this *basicCollector) EmitWithTaskIds(stream string, fields ...interface{}) (taskIds []int32) {
+	return this.OutputCollector.EmitWithTaskIds(this.anchors, stream, fields...)
+}
+
+func (this *basicCollector) EmitDirect(stream string, directTask int64, fields ...interface{}) {
+	this.OutputCollector.EmitDirect(this.anchors, stream, directTask, fields...)
+}
@@ -0,0 +1,213 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+	"reflect"
+	"sync"
+)
+
+// serialBoltConn is a bolt connection that can be used by several
+// goroutines at once. A single writer goroutine performs all of the
+// sends on the wrapped connection, in the order in which they were
+// requested, while reads are left to the goroutine reading tuples.
+// Every send blocks until it has been written, so that the contents
+// of emitted tuples can be reused as soon as Emit returns.
+type serialBoltConn struct {
+	core.BoltConn
+	requests chan *writeRequest
+}
+
+type writeRequest struct {
+	write func()
+	// done receives the value of a panic during the write, or nil
+	done chan interface{}
+}
+
+func newSerialBoltConn(conn core.BoltConn) *serialBoltConn {
+	serial := &serialBoltConn{
+		BoltConn: conn,
+		requests: make(chan *writeRequest),
+	}
+	go serial.writeLoop()
+	return serial
+}
+
+func (this *serialBoltConn) writeLoop() {
+	for request := range this.requests {
+		request.done <- this.call(request.write)
+	}
+}
+
+func (this *serialBoltConn) call(write func()) (recovered interface{}) {
+	defer func() {
+		recovered = recover()
+	}()
+	write()
+	return nil
+}
+
+// do performs a write on the writer goroutine. A panic during the write,
+// e.g. due to an I/O error, is raised again in the calling goroutine.
+func (this *serialBoltConn) do(write func()) {
+	request := &writeRequest{
+		write: write,
+		done:  make(chan interface{}, 1),
+	}
+	this.requests <- request
+	if recovered := <-request.done; recovered != nil {
+		panic(recovered)
+	}
+}
+
+// close stops the writer goroutine once all requested writes are done
+func (this *serialBoltConn) close() {
+	close(this.requests)
+}
+
+func (this *serialBoltConn) Log(msg string) {
+	this.do(func() { this.BoltConn.Log(msg) })
+}
+
+func (this *serialBoltConn) Debug(msg string) {
+	this.do(func() { this.BoltConn.Debug(msg) })
+}
+
+func (this *serialBoltConn) Info(msg string) {
+	this.do(func() { this.BoltConn.Info(msg) })
+}
+
+func (this *serialBoltConn) Warn(msg string) {
+	this.do(func() { this.BoltConn.Warn(msg) })
+}
+
+func (this *serialBoltConn) Error(msg string) {
+	this.do(func() { this.BoltConn.Error(msg) })
+}
+
+func (this *serialBoltConn) ReportError(err error) {
+	this.do(func() { this.BoltConn.ReportError(err) })
+}
+
+func (this *serialBoltConn) Metric(name string, value interface{}) {
+	this.do(func() { this.BoltConn.Metric(name, value) })
+}
+
+// RegisterMetric is serialised with the sends, since registered metrics
+// are read when a sync is sent
+func (this *serialBoltConn) RegisterMetric(name string, metric core.Metric) {
+	this.do(func() { this.BoltConn.RegisterMetric(name, metric) })
+}
+
+func (this *serialBoltConn) SendAck(id string) {
+	this.do(func() { this.BoltConn.SendAck(id) })
+}
+
+func (this *serialBoltConn) SendFail(id string) {
+	this.do(func() { this.BoltConn.SendFail(id) })
+}
+
+func (this *serialBoltConn) SendSync() {
+	this.do(func() { this.BoltConn.SendSync() })
+}
+
+func (this *serialBoltConn) Emit(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	this.do(func() { taskIds = this.BoltConn.Emit(anchors, stream, contents...) })
+	return taskIds
+}
+
+// SetNeedTaskIds panics when task ids are needed, for the same reason as EmitWithTaskIds
+func (this *serialBoltConn) SetNeedTaskIds(needTaskIds bool) {
+	if needTaskIds {
+		panic("ShellBolt: Task ids cannot be needed with concurrent execution")
+	}
+	this.BoltConn.SetNeedTaskIds(needTaskIds)
+}
+
+// EmitWithTaskIds cannot be used concurrently, since the task ids would
+// have to be read while tuples are being read
+func (this *serialBoltConn) EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	panic("ShellBolt: EmitWithTaskIds cannot be used with concurrent execution")
+}
+
+func (this *serialBoltConn) EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) {
+	this.do(func() { this.BoltConn.EmitDirect(anchors, stream, directTask, contents...) })
+}
+
+// tuple is a decoded tuple waiting to be executed by a worker
+type tuple struct {
+	meta   messages.BoltMsgMeta
+	fields []interface{}
+}
+
+// checkNewFields panics if the fields of a tuple are values that were
+// also returned for the previous tuple, before anything is decoded into
+// them. Tuples are decoded while earlier tuples are being executed, so
+// decoding into the same values would overwrite the fields of a tuple
+// that a worker is still executing.
+func checkNewFields(bolt Bolt, previous, fields []interface{}) {
+	for _, field := range fields {
+		value := reflect.ValueOf(field)
+		if value.Kind() != reflect.Ptr {
+			continue
+		}
+		for _, old := range previous {
+			if oldValue := reflect.ValueOf(old); oldValue.Kind() == reflect.Ptr && oldValue.Pointer() == value.Pointer() {
+				panic(fmt.Sprintf("ShellBolt: Fields of %T returned the same %T for two tuples, but concurrent execution requires new values for every tuple", bolt, field))
+			}
+		}
+	}
+}
+
+// goConcurrent reads tuples and executes them on a pool of workers.
+// At most maxInFlight tuples are read before being executed: tuples
+// that arrive while every worker is busy are queued until a worker is
+// free. Heartbeats are answered by the reading goroutine, which blocks
+// while maxInFlight tuples are queued or being executed.
+func (this *shellBoltImpl) goConcurrent() error {
+	// A slot is taken before a tuple is read and released once it has been executed
+	slots := make(chan struct{}, this.maxInFlight)
+	tuples := make(chan *tuple, this.maxInFlight-this.workers)
+	var workers sync.WaitGroup
+	for i := 0; i < this.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			if this.recoverPanics {
+				defer reportPanic(this.boltConn)
+			}
+			for tuple := range tuples {
+				this.execute(&tuple.meta, tuple.fields)
+				<-slots
+			}
+		}()
+	}
+
+	var err error
+	for {
+		slots <- struct{}{}
+		if err = this.readTuple(); err != nil {
+			break
+		}
+		tuples <- &tuple{meta: *this.meta, fields: this.fields}
+	}
+	close(tuples)
+	workers.Wait()
+	this.Exit()
+	return closedInput(err)
+}
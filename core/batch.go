@@ -0,0 +1,144 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package core
+
+import (
+	"github.com/jsgilmore/gostorm/messages"
+	"sync"
+	"time"
+)
+
+// batchFlusher is implemented by outputs that batch messages, which
+// only write their batch when FlushBatch is called or when the batch
+// is full or old enough
+type batchFlusher interface {
+	FlushBatch() (err error)
+}
+
+// NewBatchOutput returns an output that reduces the number of writes to
+// Storm by batching messages. Messages are flushed together once
+// maxBatch messages are waiting, once the oldest waiting message has
+// waited for maxLatency, or when a connection using the output is
+// about to block on reading from Storm. A zero maxLatency disables the
+// latency timer. The returned output can be used concurrently.
+func NewBatchOutput(out Output, maxBatch int, maxLatency time.Duration) Output {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	return &batchOutput{
+		out:        out,
+		maxBatch:   maxBatch,
+		maxLatency: maxLatency,
+	}
+}
+
+type batchOutput struct {
+	sync.Mutex
+	out        Output
+	maxBatch   int
+	maxLatency time.Duration
+	pending    int
+	timer      *time.Timer
+	// err is an error that occurred while flushing on the latency
+	// timer, which is returned by the next call
+	err error
+}
+
+func (this *batchOutput) SendMsg(msg interface{}) (err error) {
+	this.Lock()
+	defer this.Unlock()
+	if err = this.takeErr(); err != nil {
+		return err
+	}
+	return this.buffered(this.out.SendMsg(msg))
+}
+
+func (this *batchOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	this.Lock()
+	defer this.Unlock()
+	if err = this.takeErr(); err != nil {
+		return err
+	}
+	return this.buffered(this.out.EmitGeneric(command, id, stream, msg, anchors, directTask, needTaskIds, contents...))
+}
+
+func (this *batchOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	this.Lock()
+	defer this.Unlock()
+	if err = this.takeErr(); err != nil {
+		return err
+	}
+	return this.buffered(this.out.EmitShellMsg(meta, contents...))
+}
+
+// Flush adds the messages sent since the previous flush to the batch,
+// which is only written if it is full
+func (this *batchOutput) Flush() (err error) {
+	this.Lock()
+	defer this.Unlock()
+	if err = this.takeErr(); err != nil {
+		return err
+	}
+	if this.pending >= this.maxBatch {
+		return this.flush()
+	}
+	if this.pending > 0 && this.timer == nil && this.maxLatency > 0 {
+		this.timer = time.AfterFunc(this.maxLatency, this.flushLate)
+	}
+	return nil
+}
+
+// buffered counts a message that was written to the batch
+func (this *batchOutput) buffered(err error) error {
+	if err == nil {
+		this.pending++
+	}
+	return err
+}
+
+// FlushBatch writes all waiting messages
+func (this *batchOutput) FlushBatch() (err error) {
+	this.Lock()
+	defer this.Unlock()
+	if err = this.takeErr(); err != nil {
+		return err
+	}
+	return this.flush()
+}
+
+func (this *batchOutput) flushLate() {
+	this.Lock()
+	defer this.Unlock()
+	if err := this.flush(); err != nil && this.err == nil {
+		this.err = err
+	}
+}
+
+func (this *batchOutput) flush() (err error) {
+	if this.timer != nil {
+		this.timer.Stop()
+		this.timer = nil
+	}
+	if this.pending == 0 {
+		return nil
+	}
+	this.pending = 0
+	return this.out.Flush()
+}
+
+func (this *batchOutput) takeErr() (err error) {
+	err, this.err = this.err, nil
+	return err
+}
@@ -15,6 +15,7 @@
 package core
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -24,33 +25,109 @@ import (
 	"github.com/jsgilmore/gostorm/messages"
 )
 
-// BoltConn is the interface that implements the possible bolt actions
+// BoltConn is the interface that implements the possible bolt actions.
+// A BoltConn panics when a message cannot be sent; CheckedBoltConn is
+// the variant that returns errors instead.
 type BoltConn interface {
 	Connect()
 	Context() *messages.Context
+	NeedTaskIds() bool
+	SetNeedTaskIds(needTaskIds bool)
 	Log(msg string)
+	Debug(msg string)
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+	ReportError(err error)
+	Metric(name string, value interface{})
+	RegisterMetric(name string, metric Metric)
 	ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) (err error)
+	ReadBoltMsgFunc(meta *messages.BoltMsgMeta, fields FieldsFunc) (err error)
 	SendAck(id string)
 	SendFail(id string)
 	SendSync()
 	Emit(anchors []string, stream string, content ...interface{}) (taskIds []int32)
+	EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32)
 	EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{})
 }
 
-// SpoutConn is the interface that implements the possible spout actions
+// SpoutConn is the interface that implements the possible spout actions.
+// A SpoutConn panics when a message cannot be sent; CheckedSpoutConn is
+// the variant that returns errors instead.
 type SpoutConn interface {
 	Connect()
 	Context() *messages.Context
+	NeedTaskIds() bool
+	SetNeedTaskIds(needTaskIds bool)
 	Log(msg string)
+	Debug(msg string)
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+	ReportError(err error)
+	Metric(name string, value interface{})
+	RegisterMetric(name string, metric Metric)
 	ReadSpoutMsg() (command, id string, err error)
 	SendSync()
 	Emit(id string, stream string, contents ...interface{}) (taskIds []int32)
+	EmitWithTaskIds(id string, stream string, contents ...interface{}) (taskIds []int32)
 	EmitDirect(id string, stream string, directTask int64, contents ...interface{})
 }
 
+// CheckedBoltConn is the variant of BoltConn that returns encoding and
+// I/O errors instead of panicking
+type CheckedBoltConn interface {
+	Connect() (err error)
+	Context() *messages.Context
+	NeedTaskIds() bool
+	SetNeedTaskIds(needTaskIds bool)
+	Log(msg string) (err error)
+	LogLevel(level LogLevel, msg string) (err error)
+	ReportError(reported error) (err error)
+	Metric(name string, value interface{}) (err error)
+	RegisterMetric(name string, metric Metric)
+	ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) (err error)
+	ReadBoltMsgFunc(meta *messages.BoltMsgMeta, fields FieldsFunc) (err error)
+	SendAck(id string) (err error)
+	SendFail(id string) (err error)
+	SendSync() (err error)
+	Emit(anchors []string, stream string, contents ...interface{}) (taskIds []int32, err error)
+	EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32, err error)
+	EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) (err error)
+}
+
+// CheckedSpoutConn is the variant of SpoutConn that returns encoding
+// and I/O errors instead of panicking
+type CheckedSpoutConn interface {
+	Connect() (err error)
+	Context() *messages.Context
+	NeedTaskIds() bool
+	SetNeedTaskIds(needTaskIds bool)
+	Log(msg string) (err error)
+	LogLevel(level LogLevel, msg string) (err error)
+	ReportError(reported error) (err error)
+	Metric(name string, value interface{}) (err error)
+	RegisterMetric(name string, metric Metric)
+	ReadSpoutMsg() (command, id string, err error)
+	SendSync() (err error)
+	Emit(id string, stream string, contents ...interface{}) (taskIds []int32, err error)
+	EmitWithTaskIds(id string, stream string, contents ...interface{}) (taskIds []int32, err error)
+	EmitDirect(id string, stream string, directTask int64, contents ...interface{}) (err error)
+}
+
+// ErrSpoutNotReady is returned when a spout emits before it has been
+// asked to by Storm (after a sync has been sent)
+var ErrSpoutNotReady = errors.New("Spout not ready to send")
+
 // newStormConn creates a new generic Storm connection
 // This connection must be embedded in either a spout or bolt
+// newStormConn synchronises the output, so that messages can be logged
+// from other goroutines while a component is running, e.g. to warn that
+// a function is blocking heartbeats
 func newStormConn(in Input, out Output, needTaskIds bool) *stormConnImpl {
+	if _, ok := out.(*syncOutput); !ok {
+		out = NewSyncOutput(out)
+	}
 	stormConn := &stormConnImpl{
 		Input:       in,
 		Output:      out,
@@ -65,6 +142,7 @@ type stormConnImpl struct {
 	Output
 	context     *messages.Context
 	needTaskIds bool
+	metrics     MetricRegistry
 }
 
 func (this *stormConnImpl) readContext() (context *messages.Context, err error) {
@@ -76,36 +154,38 @@ func (this *stormConnImpl) readContext() (context *messages.Context, err error)
 	return context, nil
 }
 
-func (this *stormConnImpl) reportPid() {
+func (this *stormConnImpl) reportPid() (err error) {
 	// Send the pid to Storm
 	msg := &messages.Pid{
 		Pid: int32(os.Getpid()),
 	}
-	this.SendMsg(msg)
-	this.Flush()
+	err = this.SendMsg(msg)
+	if err != nil {
+		return err
+	}
+	err = this.Flush()
+	if err != nil {
+		return err
+	}
 
 	// Write an empty file with the pid, which storm can use to kill our process
 	pidFile, err := os.Create(filepath.Join(this.Context().PidDir, strconv.Itoa(os.Getpid())))
 	if err != nil {
-		panic(err)
-	}
-	err = pidFile.Close()
-	if err != nil {
-		panic(err)
+		return err
 	}
+	return pidFile.Close()
 }
 
 // Initialise set the storm input reader to the specified file
 // descriptor, reads the topology configuration for Storm and reports
 // the pid to Storm
-func (this *stormConnImpl) Connect() {
+func (this *stormConnImpl) Connect() (err error) {
 	// Receive the topology layout and config
-	var err error
 	this.context, err = this.readContext()
 	if err != nil {
-		panic(fmt.Sprintf("Storm failed to initialise: %v", err))
+		return fmt.Errorf("Storm failed to initialise: %v", err)
 	}
-	this.reportPid()
+	return this.reportPid()
 }
 
 func (this *stormConnImpl) Context() *messages.Context {
@@ -113,21 +193,166 @@ func (this *stormConnImpl) Context() *messages.Context {
 }
 
 // Log sends a log message that will be logged by Storm
-func (this *stormConnImpl) Log(text string) {
-	this.EmitGeneric("log", "", "", text, nil, 0, false)
+func (this *stormConnImpl) Log(text string) (err error) {
+	err = this.EmitGeneric("log", "", "", text, nil, 0, false)
+	if err != nil {
+		return err
+	}
 	// Logs are flushed immediatly to aid in debugging
-	this.Flush()
+	return this.Flush()
 }
 
-// NewBoltConn returns a Storm bolt connection that a Go bolt can use to communicate with Storm
-func NewBoltConn(in Input, out Output, needTaskIds bool) BoltConn {
-	boltConn := &boltConnImpl{
+// LogLevel sends a log message that will be logged by Storm at the given level
+func (this *stormConnImpl) LogLevel(level LogLevel, text string) (err error) {
+	protoLevel := int32(level)
+	meta := &messages.ShellMsgMeta{
+		Command: "log",
+		Msg:     &text,
+		Level:   &protoLevel,
+	}
+	err = this.EmitShellMsg(meta)
+	if err != nil {
+		return err
+	}
+	return this.Flush()
+}
+
+// ReportError sends an error to Storm, which is shown as an error of
+// the component in the Storm UI. Nil errors are not reported.
+func (this *stormConnImpl) ReportError(reported error) (err error) {
+	if reported == nil {
+		return nil
+	}
+	err = this.EmitGeneric("error", "", "", reported.Error(), nil, 0, false)
+	if err != nil {
+		return err
+	}
+	// Errors are flushed immediately, since they often precede a crash
+	return this.Flush()
+}
+
+// Metric sends a value to the metric with the given name, which has to
+// be registered in the topology. The value is sent as json and is
+// only flushed with the next message that is flushed.
+func (this *stormConnImpl) Metric(name string, value interface{}) (err error) {
+	params, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	meta := &messages.ShellMsgMeta{
+		Command: "metrics",
+		Name:    &name,
+		Params:  params,
+	}
+	return this.EmitShellMsg(meta)
+}
+
+// RegisterMetric registers a metric of which the value is sent every
+// time a sync is sent to Storm
+func (this *stormConnImpl) RegisterMetric(name string, metric Metric) {
+	this.metrics.Register(name, metric)
+}
+
+// sendSync sends the values of the registered metrics followed by a sync
+func (this *stormConnImpl) sendSync() (err error) {
+	err = this.metrics.Flush(this.Metric)
+	if err != nil {
+		return err
+	}
+	return this.sendFlushed("sync", "")
+}
+
+// NeedTaskIds reports whether Storm sends the ids of the tasks that
+// received every emitted tuple, which are read before Emit returns
+func (this *stormConnImpl) NeedTaskIds() bool {
+	return this.needTaskIds
+}
+
+// SetNeedTaskIds sets whether Storm sends the ids of the tasks that
+// received every emitted tuple. Emit only returns task ids if they are
+// needed, but then has to wait for Storm to send them.
+func (this *stormConnImpl) SetNeedTaskIds(needTaskIds bool) {
+	this.needTaskIds = needTaskIds
+}
+
+// flushBeforeRead writes the messages batched by a batch output if
+// reading the next message from Storm might block, since Storm might
+// be waiting for them
+func (this *stormConnImpl) flushBeforeRead() (err error) {
+	batch, ok := this.Output.(batchFlusher)
+	if !ok {
+		return nil
+	}
+	if buffered, ok := this.Input.(BufferedInput); ok && buffered.Buffered() > 0 {
+		return nil
+	}
+	return batch.FlushBatch()
+}
+
+// ReadMsg reads a message from Storm
+func (this *stormConnImpl) ReadMsg(msg interface{}) (err error) {
+	if err = this.flushBeforeRead(); err != nil {
+		return err
+	}
+	return this.Input.ReadMsg(msg)
+}
+
+// ReadTaskIds reads the task ids of an emitted tuple, which are only
+// sent once Storm has received the tuple
+func (this *stormConnImpl) ReadTaskIds() (taskIds []int32, err error) {
+	if batch, ok := this.Output.(batchFlusher); ok {
+		if err = batch.FlushBatch(); err != nil {
+			return nil, err
+		}
+	}
+	return this.Input.ReadTaskIds()
+}
+
+// ReadBoltMsg reads a tuple into the given content structs, of which
+// tuple structs are expanded into their fields
+func (this *stormConnImpl) ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
+	if err = this.flushBeforeRead(); err != nil {
+		return err
+	}
+	return this.Input.ReadBoltMsg(meta, TupleFields(contentStructs)...)
+}
+
+// ReadBoltMsgFunc reads a tuple into the content structs returned for
+// its metadata, of which tuple structs are expanded into their fields.
+// If the input is not a FieldsFuncInput, the content structs are
+// requested for empty metadata before the tuple is read.
+func (this *stormConnImpl) ReadBoltMsgFunc(meta *messages.BoltMsgMeta, fields FieldsFunc) (err error) {
+	if err = this.flushBeforeRead(); err != nil {
+		return err
+	}
+	funcInput, ok := this.Input.(FieldsFuncInput)
+	if !ok {
+		return this.Input.ReadBoltMsg(meta, TupleFields(fields(&messages.BoltMsgMeta{}))...)
+	}
+	return funcInput.ReadBoltMsgFunc(meta, func(meta *messages.BoltMsgMeta) []interface{} {
+		return TupleFields(fields(meta))
+	})
+}
+
+// sendFlushed sends a message without contents and flushes it immediately
+func (this *stormConnImpl) sendFlushed(command, id string) (err error) {
+	err = this.EmitGeneric(command, id, "", "", nil, 0, false)
+	if err != nil {
+		return err
+	}
+	return this.Flush()
+}
+
+// NewCheckedBoltConn returns a Storm bolt connection that a Go bolt can
+// use to communicate with Storm, which returns errors instead of panicking
+func NewCheckedBoltConn(in Input, out Output, needTaskIds bool) CheckedBoltConn {
+	boltConn := &checkedBoltConnImpl{
 		stormConnImpl: newStormConn(in, out, needTaskIds),
 	}
 	return boltConn
 }
 
-type boltConnImpl struct {
+type checkedBoltConnImpl struct {
 	*stormConnImpl
 }
 
@@ -144,35 +369,50 @@ func newTupleMetadata(id, comp, stream string, task int64) *messages.BoltMsgMeta
 // SendAck acks the received message id
 // SendAck has to be called after an emission anchored to the acked id,
 // otherwise Storm will report an error.
-func (this *boltConnImpl) SendAck(id string) {
-	this.EmitGeneric("ack", id, "", "", nil, 0, false)
-	this.Flush()
+func (this *checkedBoltConnImpl) SendAck(id string) (err error) {
+	return this.sendFlushed("ack", id)
 }
 
 // SendFail reports that the message with the given Id failed
 // No emission should be anchored to a failed message Id
-func (this *boltConnImpl) SendFail(id string) {
-	this.EmitGeneric("fail", id, "", "", nil, 0, false)
-	this.Flush()
+func (this *checkedBoltConnImpl) SendFail(id string) (err error) {
+	return this.sendFlushed("fail", id)
 }
 
 // SendSync sends a sync typically in response to a heartbeat
-func (this *boltConnImpl) SendSync() {
-	this.EmitGeneric("sync", "", "", "", nil, 0, false)
-	this.Flush()
+func (this *checkedBoltConnImpl) SendSync() (err error) {
+	return this.sendSync()
 }
 
 // Emit emits a tuple with the given array of interface{}s as values,
 // anchored to the given array of taskIds, sent out on the given stream.
 // A stream value of "" or "default" can be used to denote the default stream
 // The function returns a list of taskIds to which the message was sent.
-func (this *boltConnImpl) Emit(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
-	this.EmitDirect(anchors, stream, 0, contents...)
-	this.Flush()
-	if this.needTaskIds {
+func (this *checkedBoltConnImpl) Emit(anchors []string, stream string, contents ...interface{}) (taskIds []int32, err error) {
+	return this.emit(anchors, stream, this.needTaskIds, contents)
+}
+
+// EmitWithTaskIds emits a tuple like Emit, but always returns the ids
+// of the tasks that received the tuple, regardless of whether the
+// connection needs task ids. Only the emissions that need routing
+// information then have to wait for Storm.
+func (this *checkedBoltConnImpl) EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32, err error) {
+	return this.emit(anchors, stream, true, contents)
+}
+
+func (this *checkedBoltConnImpl) emit(anchors []string, stream string, needTaskIds bool, contents []interface{}) (taskIds []int32, err error) {
+	err = this.EmitGeneric("emit", "", stream, "", anchors, 0, needTaskIds, TupleValues(contents)...)
+	if err != nil {
+		return nil, err
+	}
+	err = this.Flush()
+	if err != nil {
+		return nil, err
+	}
+	if needTaskIds {
 		return this.ReadTaskIds()
 	} else {
-		return nil
+		return nil, nil
 	}
 }
 
@@ -183,19 +423,20 @@ func (this *boltConnImpl) Emit(anchors []string, stream string, contents ...inte
 // for this call to work.
 // A stream value of "" or "default" can be used to denote the default stream
 // The function returns a list of taskIds to which the message was sent.
-func (this *boltConnImpl) EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) {
-	this.EmitGeneric("emit", "", stream, "", anchors, directTask, this.needTaskIds, contents...)
+func (this *checkedBoltConnImpl) EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) (err error) {
+	return this.EmitGeneric("emit", "", stream, "", anchors, directTask, this.needTaskIds, TupleValues(contents)...)
 }
 
-// NewSpoutConn returns a Storm spout connection that a Go spout can use to communicate with Storm
-func NewSpoutConn(in Input, out Output, needTaskIds bool) SpoutConn {
-	spoutConn := &spoutConnImpl{
+// NewCheckedSpoutConn returns a Storm spout connection that a Go spout
+// can use to communicate with Storm, which returns errors instead of panicking
+func NewCheckedSpoutConn(in Input, out Output, needTaskIds bool) CheckedSpoutConn {
+	spoutConn := &checkedSpoutConnImpl{
 		stormConnImpl: newStormConn(in, out, needTaskIds),
 	}
 	return spoutConn
 }
 
-type spoutConnImpl struct {
+type checkedSpoutConnImpl struct {
 	readyToSend bool
 	*stormConnImpl
 }
@@ -203,7 +444,7 @@ type spoutConnImpl struct {
 // ReadMsg reads a message from Storm.
 // The message read can be either a next, ack or fail message.
 // A check is performed to verify that Storm has been initialised.
-func (this *spoutConnImpl) ReadSpoutMsg() (command, id string, err error) {
+func (this *checkedSpoutConnImpl) ReadSpoutMsg() (command, id string, err error) {
 	if this.context == nil {
 		return "", "", errors.New("Attempting to read from uninitialised Storm connection")
 	}
@@ -221,24 +462,43 @@ func (this *spoutConnImpl) ReadSpoutMsg() (command, id string, err error) {
 // After a sync message is sent, it is not possible for a spout to
 // emit a message before a ReadMsg has been performed. This is to
 // enforce the synchronous behaviour of a spout as required by Storm.
-func (this *spoutConnImpl) SendSync() {
-	this.EmitGeneric("sync", "", "", "", nil, 0, false)
+func (this *checkedSpoutConnImpl) SendSync() (err error) {
 	this.readyToSend = false
-	this.Flush()
+	return this.sendSync()
 }
 
 // Emit emits a tuple with the given array of interface{}s as values,
 // with the given taskId, sent out on the given stream.
 // A stream value of "" or "default" can be used to denote the default stream
 // The function returns a list of taskIds to which the message was sent.
-func (this *spoutConnImpl) Emit(id string, stream string, contents ...interface{}) (taskIds []int32) {
-	this.EmitDirect(id, stream, 0, contents...)
+func (this *checkedSpoutConnImpl) Emit(id string, stream string, contents ...interface{}) (taskIds []int32, err error) {
+	return this.emit(id, stream, this.needTaskIds, contents)
+}
+
+// EmitWithTaskIds emits a tuple like Emit, but always returns the ids
+// of the tasks that received the tuple, regardless of whether the
+// connection needs task ids.
+func (this *checkedSpoutConnImpl) EmitWithTaskIds(id string, stream string, contents ...interface{}) (taskIds []int32, err error) {
+	return this.emit(id, stream, true, contents)
+}
+
+func (this *checkedSpoutConnImpl) emit(id string, stream string, needTaskIds bool, contents []interface{}) (taskIds []int32, err error) {
+	if !this.readyToSend {
+		return nil, ErrSpoutNotReady
+	}
+	err = this.EmitGeneric("emit", id, stream, "", nil, 0, needTaskIds, TupleValues(contents)...)
+	if err != nil {
+		return nil, err
+	}
 	// Flush this message now so that we can receive the taskIds before returning.
-	this.Flush()
-	if this.needTaskIds {
+	err = this.Flush()
+	if err != nil {
+		return nil, err
+	}
+	if needTaskIds {
 		return this.ReadTaskIds()
 	} else {
-		return nil
+		return nil, nil
 	}
 }
 
@@ -248,9 +508,237 @@ func (this *spoutConnImpl) Emit(id string, stream string, contents ...interface{
 // for this call to work.
 // A stream value of "" or "default" can be used to denote the default stream
 // The function returns a list of taskIds to which the message was sent.
-func (this *spoutConnImpl) EmitDirect(id string, stream string, directTask int64, contents ...interface{}) {
+func (this *checkedSpoutConnImpl) EmitDirect(id string, stream string, directTask int64, contents ...interface{}) (err error) {
 	if !this.readyToSend {
-		panic("Spout not ready to send")
+		return ErrSpoutNotReady
+	}
+	return this.EmitGeneric("emit", id, stream, "", nil, directTask, this.needTaskIds, TupleValues(contents)...)
+}
+
+// NewBoltConn returns a Storm bolt connection that a Go bolt can use to communicate with Storm
+func NewBoltConn(in Input, out Output, needTaskIds bool) BoltConn {
+	return &boltConnImpl{
+		conn: NewCheckedBoltConn(in, out, needTaskIds),
+	}
+}
+
+// boltConnImpl panics on the errors returned by a checked bolt connection
+type boltConnImpl struct {
+	conn CheckedBoltConn
+}
+
+func (this *boltConnImpl) Connect() {
+	if err := this.conn.Connect(); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) Context() *messages.Context {
+	return this.conn.Context()
+}
+
+func (this *boltConnImpl) NeedTaskIds() bool {
+	return this.conn.NeedTaskIds()
+}
+
+func (this *boltConnImpl) SetNeedTaskIds(needTaskIds bool) {
+	this.conn.SetNeedTaskIds(needTaskIds)
+}
+
+func (this *boltConnImpl) Log(msg string) {
+	if err := this.conn.Log(msg); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) logLevel(level LogLevel, msg string) {
+	if err := this.conn.LogLevel(level, msg); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) Debug(msg string) {
+	this.logLevel(LogDebug, msg)
+}
+
+func (this *boltConnImpl) Info(msg string) {
+	this.logLevel(LogInfo, msg)
+}
+
+func (this *boltConnImpl) Warn(msg string) {
+	this.logLevel(LogWarn, msg)
+}
+
+func (this *boltConnImpl) Error(msg string) {
+	this.logLevel(LogError, msg)
+}
+
+func (this *boltConnImpl) ReportError(reported error) {
+	if err := this.conn.ReportError(reported); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) Metric(name string, value interface{}) {
+	if err := this.conn.Metric(name, value); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) RegisterMetric(name string, metric Metric) {
+	this.conn.RegisterMetric(name, metric)
+}
+
+func (this *boltConnImpl) ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
+	return this.conn.ReadBoltMsg(meta, contentStructs...)
+}
+
+func (this *boltConnImpl) ReadBoltMsgFunc(meta *messages.BoltMsgMeta, fields FieldsFunc) (err error) {
+	return this.conn.ReadBoltMsgFunc(meta, fields)
+}
+
+func (this *boltConnImpl) SendAck(id string) {
+	if err := this.conn.SendAck(id); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) SendFail(id string) {
+	if err := this.conn.SendFail(id); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) SendSync() {
+	if err := this.conn.SendSync(); err != nil {
+		panic(err)
+	}
+}
+
+func (this *boltConnImpl) Emit(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	taskIds, err := this.conn.Emit(anchors, stream, contents...)
+	if err != nil {
+		panic(err)
+	}
+	return taskIds
+}
+
+func (this *boltConnImpl) EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	taskIds, err := this.conn.EmitWithTaskIds(anchors, stream, contents...)
+	if err != nil {
+		panic(err)
+	}
+	return taskIds
+}
+
+func (this *boltConnImpl) EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) {
+	if err := this.conn.EmitDirect(anchors, stream, directTask, contents...); err != nil {
+		panic(err)
+	}
+}
+
+// NewSpoutConn returns a Storm spout connection that a Go spout can use to communicate with Storm
+func NewSpoutConn(in Input, out Output, needTaskIds bool) SpoutConn {
+	return &spoutConnImpl{
+		conn: NewCheckedSpoutConn(in, out, needTaskIds),
+	}
+}
+
+// spoutConnImpl panics on the errors returned by a checked spout connection
+type spoutConnImpl struct {
+	conn CheckedSpoutConn
+}
+
+func (this *spoutConnImpl) Connect() {
+	if err := this.conn.Connect(); err != nil {
+		panic(err)
+	}
+}
+
+func (this *spoutConnImpl) Context() *messages.Context {
+	return this.conn.Context()
+}
+
+func (this *spoutConnImpl) NeedTaskIds() bool {
+	return this.conn.NeedTaskIds()
+}
+
+func (this *spoutConnImpl) SetNeedTaskIds(needTaskIds bool) {
+	this.conn.SetNeedTaskIds(needTaskIds)
+}
+
+func (this *spoutConnImpl) Log(msg string) {
+	if err := this.conn.Log(msg); err != nil {
+		panic(err)
+	}
+}
+
+func (this *spoutConnImpl) logLevel(level LogLevel, msg string) {
+	if err := this.conn.LogLevel(level, msg); err != nil {
+		panic(err)
+	}
+}
+
+func (this *spoutConnImpl) Debug(msg string) {
+	this.logLevel(LogDebug, msg)
+}
+
+func (this *spoutConnImpl) Info(msg string) {
+	this.logLevel(LogInfo, msg)
+}
+
+func (this *spoutConnImpl) Warn(msg string) {
+	this.logLevel(LogWarn, msg)
+}
+
+func (this *spoutConnImpl) Error(msg string) {
+	this.logLevel(LogError, msg)
+}
+
+func (this *spoutConnImpl) ReportError(reported error) {
+	if err := this.conn.ReportError(reported); err != nil {
+		panic(err)
+	}
+}
+
+func (this *spoutConnImpl) Metric(name string, value interface{}) {
+	if err := this.conn.Metric(name, value); err != nil {
+		panic(err)
+	}
+}
+
+func (this *spoutConnImpl) RegisterMetric(name string, metric Metric) {
+	this.conn.RegisterMetric(name, metric)
+}
+
+func (this *spoutConnImpl) ReadSpoutMsg() (command, id string, err error) {
+	return this.conn.ReadSpoutMsg()
+}
+
+func (this *spoutConnImpl) SendSync() {
+	if err := this.conn.SendSync(); err != nil {
+		panic(err)
+	}
+}
+
+func (this *spoutConnImpl) Emit(id string, stream string, contents ...interface{}) (taskIds []int32) {
+	taskIds, err := this.conn.Emit(id, stream, contents...)
+	if err != nil {
+		panic(err)
+	}
+	return taskIds
+}
+
+func (this *spoutConnImpl) EmitWithTaskIds(id string, stream string, contents ...interface{}) (taskIds []int32) {
+	taskIds, err := this.conn.EmitWithTaskIds(id, stream, contents...)
+	if err != nil {
+		panic(err)
+	}
+	return taskIds
+}
+
+func (this *spoutConnImpl) EmitDirect(id string, stream string, directTask int64, contents ...interface{}) {
+	if err := this.conn.EmitDirect(id, stream, directTask, contents...); err != nil {
+		panic(err)
 	}
-	this.EmitGeneric("emit", id, stream, "", nil, directTask, this.needTaskIds, contents...)
 }
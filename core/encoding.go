@@ -20,18 +20,58 @@ import (
 	"io"
 )
 
+// FieldsFunc returns the structs into which the fields of a tuple with
+// the given metadata should be decoded. The fields of a tuple are not
+// decoded if no structs are returned.
+type FieldsFunc func(meta *messages.BoltMsgMeta) []interface{}
+
+// StaticFields returns a FieldsFunc that returns the same structs for every tuple
+func StaticFields(contentStructs []interface{}) FieldsFunc {
+	return func(meta *messages.BoltMsgMeta) []interface{} {
+		return contentStructs
+	}
+}
+
+// Input decodes messages received from Storm.
+// Malformed messages are reported using an *Error.
 type Input interface {
 	ReadMsg(msg interface{}) (err error)
-	ReadTaskIds() (taskIds []int32)
+	ReadTaskIds() (taskIds []int32, err error)
 	ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) (err error)
 }
 
+// FieldsFuncInput is implemented by inputs that read the metadata of a
+// tuple before its fields are decoded, which allows the structs to
+// decode into to depend on the metadata, e.g. the stream of the tuple.
+// The fields of tuples read from other inputs are decoded into the
+// structs returned for empty metadata.
+type FieldsFuncInput interface {
+	ReadBoltMsgFunc(meta *messages.BoltMsgMeta, fields FieldsFunc) (err error)
+}
+
+// BufferedInput is implemented by inputs that read ahead. Buffered
+// returns the amount of input that can be read without blocking, which
+// is zero if reading the next message might block.
+type BufferedInput interface {
+	Buffered() int
+}
+
+// Output encodes messages sent to Storm.
+// Contents that cannot be encoded are reported using an *Error.
+// EmitGeneric is a shorthand for EmitShellMsg, which additionally
+// allows fields such as the log level to be sent.
 type Output interface {
-	SendMsg(msg interface{})
-	EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{})
-	Flush()
+	SendMsg(msg interface{}) (err error)
+	EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error)
+	EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error)
+	Flush() (err error)
 }
 
+// RawField is a tuple field that has already been encoded by the
+// encoding in use. Outputs emit raw fields as is, which allows fields
+// that could not be decoded to be forwarded unchanged.
+type RawField []byte
+
 type InputFactory interface {
 	NewInput(reader io.Reader) Input
 }
@@ -63,17 +103,29 @@ func RegisterOutput(name string, outFactory OutputFactory) {
 	}
 }
 
+// LookupInput returns an input of the registered encoding with the given
+// name, or of the registered envelope and payload codec given by a name
+// of the form "envelope+payload"
 func LookupInput(encoding string, reader io.Reader) Input {
 	input, ok := inputs[encoding]
 	if !ok {
+		if composed, ok := lookupComposedInput(encoding, reader); ok {
+			return composed
+		}
 		panic(fmt.Sprintf("gostorm encoding: Specified input not registered: %s", encoding))
 	}
 	return input.NewInput(reader)
 }
 
+// LookupOutput returns an output of the registered encoding with the
+// given name, or of the registered envelope and payload codec given by a
+// name of the form "envelope+payload"
 func LookupOutput(encoding string, writer io.Writer) Output {
 	output, ok := outputs[encoding]
 	if !ok {
+		if composed, ok := lookupComposedOutput(encoding, writer); ok {
+			return composed
+		}
 		panic(fmt.Sprintf("gostorm encoding: Specified output not registered: %s", encoding))
 	}
 	return output.NewOutput(writer)
@@ -83,7 +135,7 @@ func LookupBoltConn(encoding string, reader io.Reader, writer io.Writer) BoltCon
 	input := LookupInput(encoding, reader)
 	output := LookupOutput(encoding, writer)
 	// The default is to not require taskIds
-	// This value can be changed using the conn interface
+	// This value can be changed using SetNeedTaskIds
 	return NewBoltConn(input, output, false)
 }
 
@@ -91,6 +143,18 @@ func LookupSpoutConn(encoding string, reader io.Reader, writer io.Writer) SpoutC
 	input := LookupInput(encoding, reader)
 	output := LookupOutput(encoding, writer)
 	// The default is to not require taskIds
-	// This value can be changed using the conn interface
+	// This value can be changed using SetNeedTaskIds
 	return NewSpoutConn(input, output, false)
 }
+
+func LookupCheckedBoltConn(encoding string, reader io.Reader, writer io.Writer) CheckedBoltConn {
+	input := LookupInput(encoding, reader)
+	output := LookupOutput(encoding, writer)
+	return NewCheckedBoltConn(input, output, false)
+}
+
+func LookupCheckedSpoutConn(encoding string, reader io.Reader, writer io.Writer) CheckedSpoutConn {
+	input := LookupInput(encoding, reader)
+	output := LookupOutput(encoding, writer)
+	return NewCheckedSpoutConn(input, output, false)
+}
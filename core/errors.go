@@ -0,0 +1,87 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error describes a failure of an encoding to encode or decode a message.
+// Errors caused by the contents of a single tuple carry the id of the
+// tuple (if it is known) and the index of the offending field, so that
+// the tuple can be failed without affecting other tuples.
+type Error struct {
+	// Encoding is the name of the encoding that failed, e.g. "jsonEncoded"
+	Encoding string
+	// Msg is the type of message that was being encoded or decoded, e.g. "BoltMsg"
+	Msg string
+	// Id is the id of the tuple that was being decoded, if it is known
+	Id string
+	// Field is the index of the tuple field that failed, or -1 if the
+	// failure is not specific to a field. A tuple with more fields than
+	// expected fails at the first unexpected field.
+	Field int
+	// Err is the underlying error
+	Err error
+	// Raw contains the encoded fields of the tuple that was being
+	// decoded, if they could be read
+	Raw [][]byte
+}
+
+// NewError returns an encoding error that is not specific to a tuple field
+func NewError(encoding, msg string, err error) *Error {
+	return &Error{
+		Encoding: encoding,
+		Msg:      msg,
+		Field:    -1,
+		Err:      err,
+	}
+}
+
+// NewFieldError returns an encoding error for the tuple field with the given index
+func NewFieldError(encoding, msg string, field int, err error) *Error {
+	return &Error{
+		Encoding: encoding,
+		Msg:      msg,
+		Field:    field,
+		Err:      err,
+	}
+}
+
+func (this *Error) Error() string {
+	text := fmt.Sprintf("gostorm %s encoding: %s", this.Encoding, this.Msg)
+	if len(this.Id) > 0 {
+		text += fmt.Sprintf(" (id %s)", this.Id)
+	}
+	if this.Field >= 0 {
+		text += fmt.Sprintf(" field %d", this.Field)
+	}
+	return fmt.Sprintf("%s: %v", text, this.Err)
+}
+
+func (this *Error) Unwrap() error {
+	return this.Err
+}
+
+// MsgName returns the name of the type of a message, without its
+// package or pointer prefix, for use in errors
+func MsgName(msg interface{}) string {
+	name := fmt.Sprintf("%T", msg)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return strings.TrimLeft(name, "*")
+}
@@ -0,0 +1,141 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// Metric is a value that is aggregated locally and sent to a metric
+// registered with the same name in the topology, using the multilang
+// metrics command, each time a bolt or spout sends a sync to Storm.
+type Metric interface {
+	// ValueAndReset returns the aggregated value and resets the
+	// metric. A nil value is not sent. Since a bolt that executes
+	// tuples concurrently may update a metric while it is being
+	// sent, metrics have to be safe for concurrent use.
+	ValueAndReset() interface{}
+}
+
+// CountMetric counts events. Like the Storm CountMetric, the count is
+// reset every time it is sent, but a count of zero is not sent.
+type CountMetric struct {
+	lock  sync.Mutex
+	count int64
+}
+
+func (this *CountMetric) Incr() {
+	this.IncrBy(1)
+}
+
+func (this *CountMetric) IncrBy(n int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.count += n
+}
+
+func (this *CountMetric) ValueAndReset() interface{} {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.count == 0 {
+		return nil
+	}
+	count := this.count
+	this.count = 0
+	return count
+}
+
+// GaugeMetric sends the last value it was set to. Every value is only sent once.
+type GaugeMetric struct {
+	lock  sync.Mutex
+	value interface{}
+}
+
+func (this *GaugeMetric) Set(value interface{}) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.value = value
+}
+
+func (this *GaugeMetric) ValueAndReset() interface{} {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	value := this.value
+	this.value = nil
+	return value
+}
+
+// MeanMetric sends the mean of the values it was updated with, similar
+// to the Storm ReducedMetric with a MeanReducer.
+type MeanMetric struct {
+	lock  sync.Mutex
+	sum   float64
+	count int64
+}
+
+func (this *MeanMetric) Update(value float64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.sum += value
+	this.count++
+}
+
+func (this *MeanMetric) ValueAndReset() interface{} {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.count == 0 {
+		return nil
+	}
+	mean := this.sum / float64(this.count)
+	this.sum = 0
+	this.count = 0
+	return mean
+}
+
+// MetricRegistry holds the metrics of a bolt or spout by name.
+// The zero value is an empty registry.
+type MetricRegistry struct {
+	metrics map[string]Metric
+}
+
+// Register adds a metric to the registry, replacing any metric with the same name
+func (this *MetricRegistry) Register(name string, metric Metric) {
+	if this.metrics == nil {
+		this.metrics = make(map[string]Metric)
+	}
+	this.metrics[name] = metric
+}
+
+// Flush calls send with the value of every metric that has a value,
+// in the order of the metric names, and resets the metrics.
+func (this *MetricRegistry) Flush(send func(name string, value interface{}) error) (err error) {
+	names := make([]string, 0, len(this.metrics))
+	for name := range this.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := this.metrics[name].ValueAndReset()
+		if value == nil {
+			continue
+		}
+		err = send(name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/jsgilmore/gostorm/messages"
+	"io"
+	"strings"
+)
+
+// PayloadCodec encodes and decodes the fields of tuples, independently
+// of the envelope in which the tuples are sent to and from Storm.
+// Payload codecs are combined with envelopes using encoding names of
+// the form "envelope+payload", e.g. "json+gob".
+type PayloadCodec interface {
+	Marshal(field interface{}) ([]byte, error)
+	Unmarshal(data []byte, field interface{}) error
+}
+
+// EnvelopeInput decodes the messages received from Storm, of which the
+// tuple fields have been encoded by a payload codec
+type EnvelopeInput interface {
+	ReadMsg(msg interface{}) (err error)
+	ReadTaskIds() (taskIds []int32, err error)
+	// ReadRawBoltMsg reads a tuple into meta and returns its encoded fields
+	ReadRawBoltMsg(meta *messages.BoltMsgMeta) (raw [][]byte, err error)
+}
+
+// EnvelopeOutput encodes the messages sent to Storm, of which the tuple
+// fields have been encoded by a payload codec
+type EnvelopeOutput interface {
+	SendMsg(msg interface{}) (err error)
+	// EmitRaw sends a message with the given encoded fields
+	EmitRaw(meta *messages.ShellMsgMeta, raw [][]byte) (err error)
+	Flush() (err error)
+}
+
+type EnvelopeInputFactory interface {
+	NewEnvelopeInput(reader io.Reader) EnvelopeInput
+}
+
+type EnvelopeOutputFactory interface {
+	NewEnvelopeOutput(writer io.Writer) EnvelopeOutput
+}
+
+var (
+	envelopeInputs  map[string]EnvelopeInputFactory  = make(map[string]EnvelopeInputFactory)
+	envelopeOutputs map[string]EnvelopeOutputFactory = make(map[string]EnvelopeOutputFactory)
+	payloadCodecs   map[string]PayloadCodec          = make(map[string]PayloadCodec)
+)
+
+func RegisterEnvelopeInput(name string, inFactory EnvelopeInputFactory) {
+	inputFactory, ok := envelopeInputs[name]
+	if !ok {
+		envelopeInputs[name] = inFactory
+	} else {
+		panic(fmt.Sprintf("Envelope input name already registered as type: %T", inputFactory))
+	}
+}
+
+func RegisterEnvelopeOutput(name string, outFactory EnvelopeOutputFactory) {
+	outputFactory, ok := envelopeOutputs[name]
+	if !ok {
+		envelopeOutputs[name] = outFactory
+	} else {
+		panic(fmt.Sprintf("Envelope output name already registered as type: %T", outputFactory))
+	}
+}
+
+func RegisterPayloadCodec(name string, codec PayloadCodec) {
+	payloadCodec, ok := payloadCodecs[name]
+	if !ok {
+		payloadCodecs[name] = codec
+	} else {
+		panic(fmt.Sprintf("Payload codec name already registered as type: %T", payloadCodec))
+	}
+}
+
+// LookupPayloadCodec returns the registered payload codec with the given name
+func LookupPayloadCodec(name string) PayloadCodec {
+	codec, ok := payloadCodecs[name]
+	if !ok {
+		panic(fmt.Sprintf("gostorm encoding: Specified payload codec not registered: %s", name))
+	}
+	return codec
+}
+
+// lookupPayloadCodec returns the envelope and payload codec of an
+// encoding name of the form "envelope+payload"
+func lookupPayloadCodec(encoding string) (envelope string, codec PayloadCodec, ok bool) {
+	envelope, payload, ok := strings.Cut(encoding, "+")
+	if !ok {
+		return "", nil, false
+	}
+	codec, ok = payloadCodecs[payload]
+	return envelope, codec, ok
+}
+
+func lookupComposedInput(encoding string, reader io.Reader) (Input, bool) {
+	envelope, codec, ok := lookupPayloadCodec(encoding)
+	if !ok {
+		return nil, false
+	}
+	factory, ok := envelopeInputs[envelope]
+	if !ok {
+		return nil, false
+	}
+	return NewPayloadInput(encoding, factory.NewEnvelopeInput(reader), codec), true
+}
+
+func lookupComposedOutput(encoding string, writer io.Writer) (Output, bool) {
+	envelope, codec, ok := lookupPayloadCodec(encoding)
+	if !ok {
+		return nil, false
+	}
+	factory, ok := envelopeOutputs[envelope]
+	if !ok {
+		return nil, false
+	}
+	return NewPayloadOutput(encoding, factory.NewEnvelopeOutput(writer), codec), true
+}
+
+// NewPayloadInput returns an input that decodes the tuple fields read
+// by an envelope using a payload codec. Errors report the given
+// encoding name.
+func NewPayloadInput(encoding string, envelope EnvelopeInput, codec PayloadCodec) Input {
+	return &payloadInput{
+		EnvelopeInput: envelope,
+		encoding:      encoding,
+		codec:         codec,
+	}
+}
+
+type payloadInput struct {
+	EnvelopeInput
+	encoding string
+	codec    PayloadCodec
+}
+
+// Buffered returns the input that the envelope has read ahead, if it
+// reads ahead
+func (this *payloadInput) Buffered() int {
+	if buffered, ok := this.EnvelopeInput.(BufferedInput); ok {
+		return buffered.Buffered()
+	}
+	return 0
+}
+
+func (this *payloadInput) ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
+	return this.ReadBoltMsgFunc(meta, StaticFields(contentStructs))
+}
+
+func (this *payloadInput) ReadBoltMsgFunc(meta *messages.BoltMsgMeta, fields FieldsFunc) (err error) {
+	// Ensure that the metadata of a previous tuple is never reported
+	meta.Reset()
+	raw, err := this.ReadRawBoltMsg(meta)
+	if err != nil {
+		if encodingErr, ok := err.(*Error); ok {
+			encodingErr.Id = meta.Id
+		}
+		return err
+	}
+
+	contentStructs := fields(meta)
+	if len(contentStructs) == 0 {
+		return nil
+	}
+	// Tuples such as heartbeats may contain fewer fields than expected
+	if len(raw) > len(contentStructs) {
+		decodeErr := NewFieldError(this.encoding, "BoltMsg", len(contentStructs), fmt.Errorf("received %d fields, expected at most %d", len(raw), len(contentStructs)))
+		decodeErr.Id = meta.Id
+		decodeErr.Raw = raw
+		return decodeErr
+	}
+	for i, data := range raw {
+		err = this.codec.Unmarshal(data, contentStructs[i])
+		if err != nil {
+			decodeErr := NewFieldError(this.encoding, "BoltMsg", i, err)
+			decodeErr.Id = meta.Id
+			decodeErr.Raw = raw
+			return decodeErr
+		}
+	}
+	return nil
+}
+
+// NewPayloadOutput returns an output that encodes tuple fields using a
+// payload codec before they are sent by an envelope. Raw fields are
+// sent as is. Errors report the given encoding name.
+func NewPayloadOutput(encoding string, envelope EnvelopeOutput, codec PayloadCodec) Output {
+	return &payloadOutput{
+		EnvelopeOutput: envelope,
+		encoding:       encoding,
+		codec:          codec,
+	}
+}
+
+type payloadOutput struct {
+	EnvelopeOutput
+	encoding string
+	codec    PayloadCodec
+}
+
+func (this *payloadOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	meta := &messages.ShellMsgMeta{
+		Command:     command,
+		Anchors:     anchors,
+		Id:          &id,
+		Stream:      &stream,
+		Task:        &directTask,
+		NeedTaskIds: &needTaskIds,
+		Msg:         &msg,
+	}
+	return this.EmitShellMsg(meta, contents...)
+}
+
+func (this *payloadOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	raw := make([][]byte, len(contents))
+	for i, content := range contents {
+		if rawField, ok := content.(RawField); ok {
+			raw[i] = rawField
+			continue
+		}
+		raw[i], err = this.codec.Marshal(content)
+		if err != nil {
+			return NewFieldError(this.encoding, "ShellMsg", i, err)
+		}
+	}
+	return this.EmitRaw(meta, raw)
+}
+
+// jsonCodec encodes fields using encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(field interface{}) ([]byte, error) {
+	return json.Marshal(field)
+}
+
+func (jsonCodec) Unmarshal(data []byte, field interface{}) error {
+	return json.Unmarshal(data, field)
+}
+
+// gobCodec encodes every field as a separate gob stream, which includes
+// the type of the field
+type gobCodec struct{}
+
+func (gobCodec) Marshal(field interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(field)
+	return buffer.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, field interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(field)
+}
+
+// bytesCodec sends byte slices as is
+type bytesCodec struct{}
+
+func (bytesCodec) Marshal(field interface{}) ([]byte, error) {
+	switch field := field.(type) {
+	case []byte:
+		return field, nil
+	case *[]byte:
+		return *field, nil
+	}
+	return nil, fmt.Errorf("%T is not a byte slice", field)
+}
+
+func (bytesCodec) Unmarshal(data []byte, field interface{}) error {
+	switch field := field.(type) {
+	case *[]byte:
+		*field = append([]byte(nil), data...)
+	case *interface{}:
+		*field = append([]byte(nil), data...)
+	default:
+		return fmt.Errorf("%T is not a pointer to a byte slice", field)
+	}
+	return nil
+}
+
+// stringCodec sends strings as is
+type stringCodec struct{}
+
+func (stringCodec) Marshal(field interface{}) ([]byte, error) {
+	switch field := field.(type) {
+	case string:
+		return []byte(field), nil
+	case *string:
+		return []byte(*field), nil
+	}
+	return nil, fmt.Errorf("%T is not a string", field)
+}
+
+func (stringCodec) Unmarshal(data []byte, field interface{}) error {
+	switch field := field.(type) {
+	case *string:
+		*field = string(data)
+	case *interface{}:
+		*field = string(data)
+	default:
+		return fmt.Errorf("%T is not a pointer to a string", field)
+	}
+	return nil
+}
+
+func init() {
+	RegisterPayloadCodec("json", jsonCodec{})
+	RegisterPayloadCodec("gob", gobCodec{})
+	RegisterPayloadCodec("bytes", bytesCodec{})
+	RegisterPayloadCodec("string", stringCodec{})
+}
@@ -0,0 +1,72 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package core
+
+import (
+	"github.com/jsgilmore/gostorm/messages"
+	"sync"
+)
+
+// NewSyncOutput returns an output that can be used concurrently, e.g.
+// to log from another goroutine while a component is running. Every
+// message is written atomically, but messages sent by different
+// goroutines may be interleaved.
+func NewSyncOutput(out Output) Output {
+	return &syncOutput{
+		out: out,
+	}
+}
+
+type syncOutput struct {
+	sync.Mutex
+	out Output
+}
+
+func (this *syncOutput) SendMsg(msg interface{}) (err error) {
+	this.Lock()
+	defer this.Unlock()
+	return this.out.SendMsg(msg)
+}
+
+func (this *syncOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	this.Lock()
+	defer this.Unlock()
+	return this.out.EmitGeneric(command, id, stream, msg, anchors, directTask, needTaskIds, contents...)
+}
+
+func (this *syncOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	this.Lock()
+	defer this.Unlock()
+	return this.out.EmitShellMsg(meta, contents...)
+}
+
+func (this *syncOutput) Flush() (err error) {
+	this.Lock()
+	defer this.Unlock()
+	return this.out.Flush()
+}
+
+// FlushBatch writes the messages batched by the output that is
+// synchronised, so that a connection using the synchronised output does
+// not block on reading from Storm while Storm waits for them
+func (this *syncOutput) FlushBatch() (err error) {
+	batch, ok := this.out.(batchFlusher)
+	if !ok {
+		return nil
+	}
+	this.Lock()
+	defer this.Unlock()
+	return batch.FlushBatch()
+}
@@ -0,0 +1,186 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// Tuple structs are structs of which the fields are mapped to the
+// positions of the fields of a tuple using storm struct tags, which
+// contain the position of the field, e.g. `storm:"0"`. The positions of
+// a struct have to start at 0 and may not have gaps. Fields without a
+// storm tag or tagged with `storm:"-"` are not part of the tuple.
+//
+// Tuple structs are expanded into their fields when read and emitted by
+// bolt and spout connections, so that a tuple with several fields can
+// be read into and emitted from a single struct with any encoding.
+
+// tupleStruct maps the positions of tuple fields to the indices of struct fields
+type tupleStruct struct {
+	fields []int
+}
+
+var tupleStructs sync.Map
+
+// RegisterTupleStruct checks the storm tags of the tuple struct type of
+// the given prototype, which may also be a pointer to the struct, and
+// returns an error if they are invalid. Registering a tuple struct
+// before a topology runs, e.g. using DeclareStream, reports invalid tags
+// up front; the tags of tuple structs that were not registered are only
+// checked when the struct is first read or emitted, which panics if they
+// are invalid. Prototypes that are not tuple structs are ignored.
+func RegisterTupleStruct(prototype interface{}) error {
+	structType := reflect.TypeOf(prototype)
+	if structType == nil {
+		return nil
+	}
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() != reflect.Struct {
+		return nil
+	}
+	if _, ok := tupleStructs.Load(structType); ok {
+		return nil
+	}
+	mapping, err := newTupleStruct(structType)
+	if err != nil {
+		return err
+	}
+	tupleStructs.Store(structType, mapping)
+	return nil
+}
+
+// lookupTupleStruct returns the mapping of a struct type, or nil if the
+// type is not a tuple struct. It panics if the storm tags of the struct
+// are invalid.
+func lookupTupleStruct(structType reflect.Type) *tupleStruct {
+	if structType.Kind() != reflect.Struct {
+		return nil
+	}
+	if mapping, ok := tupleStructs.Load(structType); ok {
+		return mapping.(*tupleStruct)
+	}
+	mapping, err := newTupleStruct(structType)
+	if err != nil {
+		panic(err.Error())
+	}
+	tupleStructs.Store(structType, mapping)
+	return mapping
+}
+
+func newTupleStruct(structType reflect.Type) (*tupleStruct, error) {
+	positions := make(map[int]int)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag, ok := field.Tag.Lookup("storm")
+		if !ok || tag == "-" {
+			continue
+		}
+		if len(field.PkgPath) > 0 {
+			return nil, fmt.Errorf("Tuple struct %v: Unexported field %s has a storm tag", structType, field.Name)
+		}
+		position, err := strconv.Atoi(tag)
+		if err != nil {
+			return nil, fmt.Errorf("Tuple struct %v: Storm tag %q of field %s is not a position", structType, tag, field.Name)
+		}
+		if _, ok := positions[position]; ok || position < 0 {
+			return nil, fmt.Errorf("Tuple struct %v: Invalid or duplicate position %d of field %s", structType, position, field.Name)
+		}
+		positions[position] = i
+	}
+	if len(positions) == 0 {
+		return nil, nil
+	}
+
+	mapping := &tupleStruct{fields: make([]int, len(positions))}
+	for position := range mapping.fields {
+		index, ok := positions[position]
+		if !ok {
+			return nil, fmt.Errorf("Tuple struct %v: No field at position %d", structType, position)
+		}
+		mapping.fields[position] = index
+	}
+	return mapping, nil
+}
+
+// TupleFields expands the pointers to tuple structs among the given
+// content structs into pointers to the fields of those structs, so
+// that tuple fields can be decoded into them. Other content structs
+// are returned as is.
+func TupleFields(contentStructs []interface{}) []interface{} {
+	var expanded []interface{}
+	for i, contentStruct := range contentStructs {
+		value := reflect.ValueOf(contentStruct)
+		var mapping *tupleStruct
+		if value.Kind() == reflect.Ptr && !value.IsNil() {
+			mapping = lookupTupleStruct(value.Type().Elem())
+		}
+		if mapping == nil {
+			if expanded != nil {
+				expanded = append(expanded, contentStruct)
+			}
+			continue
+		}
+		if expanded == nil {
+			expanded = append(make([]interface{}, 0, len(contentStructs)+len(mapping.fields)), contentStructs[:i]...)
+		}
+		for _, index := range mapping.fields {
+			expanded = append(expanded, value.Elem().Field(index).Addr().Interface())
+		}
+	}
+	if expanded == nil {
+		return contentStructs
+	}
+	return expanded
+}
+
+// TupleValues expands the tuple structs, or pointers to tuple structs,
+// among the given contents into the values of their fields, so that
+// they are emitted as separate tuple fields. Other contents are
+// returned as is.
+func TupleValues(contents []interface{}) []interface{} {
+	var expanded []interface{}
+	for i, content := range contents {
+		value := reflect.ValueOf(content)
+		if value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+		var mapping *tupleStruct
+		if value.Kind() == reflect.Struct {
+			mapping = lookupTupleStruct(value.Type())
+		}
+		if mapping == nil {
+			if expanded != nil {
+				expanded = append(expanded, content)
+			}
+			continue
+		}
+		if expanded == nil {
+			expanded = append(make([]interface{}, 0, len(contents)+len(mapping.fields)), contents[:i]...)
+		}
+		for _, index := range mapping.fields {
+			expanded = append(expanded, value.Field(index).Interface())
+		}
+	}
+	if expanded == nil {
+		return contents
+	}
+	return expanded
+}
@@ -3,5 +3,6 @@ package all
 import (
 	_ "github.com/jsgilmore/gostorm/encodings/hybrid"
 	_ "github.com/jsgilmore/gostorm/encodings/json"
+	_ "github.com/jsgilmore/gostorm/encodings/msgpack"
 	_ "github.com/jsgilmore/gostorm/encodings/protobuf"
 )
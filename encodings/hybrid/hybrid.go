@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"container/list"
 	"encoding/json"
+	"errors"
 	"fmt"
 	proto "github.com/jsgilmore/gostorm/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	"github.com/jsgilmore/gostorm/core"
@@ -59,9 +60,9 @@ func (this *hybridInput) readData() (data []byte, err error) {
 	//Read the end delimiter
 	_, err = this.reader.ReadBytes('\n')
 	if err == io.EOF {
-		panic("EOF received at end statement. Is there newline after every end statement (including the last one)?")
+		return nil, core.NewError("hybrid", "end", errors.New("EOF received at end statement. Is there newline after every end statement (including the last one)?"))
 	} else if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Remove the newline character
@@ -69,6 +70,12 @@ func (this *hybridInput) readData() (data []byte, err error) {
 	return data, nil
 }
 
+// Buffered returns the number of tuples and bytes that have been read
+// ahead, which can be read without blocking
+func (this *hybridInput) Buffered() int {
+	return this.tupleBuffer.Len() + this.reader.Buffered()
+}
+
 // readBytes reads data from stdin into the struct provided.
 func (this *hybridInput) ReadMsg(msg interface{}) (err error) {
 	var data []byte
@@ -87,16 +94,19 @@ func (this *hybridInput) ReadMsg(msg interface{}) (err error) {
 	err = json.Unmarshal(data, msg)
 	if err != nil {
 		log.Printf("core hybrid encoding: Unmarshalling: %s", data)
-		return err
+		return core.NewError("hybrid", core.MsgName(msg), err)
 	}
 	return nil
 }
 
-func (this *hybridInput) ReadTaskIds() (taskIds []int32) {
+func (this *hybridInput) ReadTaskIds() (taskIds []int32, err error) {
 	// Read a single json record from the input file
 	data, err := this.readData()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, core.NewError("hybrid", "TaskIds", errors.New("empty message"))
 	}
 
 	// If we didn't receive a json array, treat it as a tuple instead
@@ -107,43 +117,72 @@ func (this *hybridInput) ReadTaskIds() (taskIds []int32) {
 
 	err = json.Unmarshal(data, &taskIds)
 	if err != nil {
-		panic(err)
+		return nil, core.NewError("hybrid", "TaskIds", err)
 	}
-	return taskIds
+	return taskIds, nil
 }
 
-func (this *hybridInput) constructInput(contents ...interface{}) []interface{} {
-	contentList := make([]interface{}, len(contents))
-	for i := 0; i < len(contents); i++ {
-		contentList[i] = &[]byte{}
-	}
-	return contentList
+// hybridBoltMsg is a tuple of which the fields are encoded protocol
+// buffers, which are only decoded once the tuple has been read
+type hybridBoltMsg struct {
+	*messages.BoltMsgMeta
+	Contents [][]byte `json:"tuple"`
 }
 
-func (this *hybridInput) decodeInput(contentList []interface{}, contentStructs ...interface{}) {
-	for i, content := range contentList {
-		err := proto.Unmarshal(*content.(*[]byte), contentStructs[i].(proto.Message))
+func (this *hybridInput) decodeInput(raw [][]byte, contentStructs ...interface{}) error {
+	if len(contentStructs) == 0 {
+		return nil
+	}
+	// Tuples such as heartbeats may contain fewer fields than expected
+	if len(raw) > len(contentStructs) {
+		decodeErr := core.NewFieldError("hybrid", "BoltMsg", len(contentStructs), fmt.Errorf("received %d fields, expected at most %d", len(raw), len(contentStructs)))
+		decodeErr.Raw = raw
+		return decodeErr
+	}
+	for i, data := range raw {
+		var err error
+		protoStruct, ok := contentStructs[i].(proto.Message)
+		if !ok {
+			err = fmt.Errorf("%T is not a protocol buffer message", contentStructs[i])
+		} else {
+			err = proto.Unmarshal(data, protoStruct)
+		}
 		if err != nil {
-			panic(err)
+			decodeErr := core.NewFieldError("hybrid", "BoltMsg", i, err)
+			decodeErr.Raw = raw
+			return decodeErr
 		}
 	}
+	return nil
 }
 
 // ReadTuple reads a tuple from Storm of which the contents are known
 // and decodes the contents into the provided list of structs
 func (this *hybridInput) ReadBoltMsg(metadata *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
-	boltMsg := &messages.BoltMsg{
-		BoltMsgJson: &messages.BoltMsgJson{
-			BoltMsgMeta: metadata,
-			Contents:    this.constructInput(contentStructs...),
-		},
+	return this.ReadBoltMsgFunc(metadata, core.StaticFields(contentStructs))
+}
+
+// ReadBoltMsgFunc reads a tuple from Storm and decodes the contents
+// into the structs returned for the metadata of the tuple
+func (this *hybridInput) ReadBoltMsgFunc(metadata *messages.BoltMsgMeta, fields core.FieldsFunc) (err error) {
+	// Ensure that the metadata of a previous tuple is never reported
+	metadata.Reset()
+	boltMsg := &hybridBoltMsg{
+		BoltMsgMeta: metadata,
 	}
 	err = this.ReadMsg(boltMsg)
 	if err != nil {
+		if encodingErr, ok := err.(*core.Error); ok {
+			encodingErr.Id = metadata.Id
+		}
 		return err
 	}
 
-	this.decodeInput(boltMsg.BoltMsgJson.Contents, contentStructs...)
+	err = this.decodeInput(boltMsg.Contents, fields(metadata)...)
+	if err != nil {
+		err.(*core.Error).Id = metadata.Id
+		return err
+	}
 	return nil
 }
 
@@ -168,48 +207,70 @@ type hybridOutput struct {
 }
 
 // sendMsg sends the contents of a known Storm message to Storm
-func (this *hybridOutput) SendMsg(msg interface{}) {
+func (this *hybridOutput) SendMsg(msg interface{}) (err error) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		panic(err)
+		return core.NewError("hybrid", core.MsgName(msg), err)
+	}
+	_, err = this.writer.Write(data)
+	if err != nil {
+		return err
 	}
-	fmt.Fprintln(this.writer, string(data))
 	// Storm requires that every message be suffixed with an "end" string
-	fmt.Fprintln(this.writer, "end")
+	_, err = this.writer.WriteString("\nend\n")
+	return err
 }
 
-func (this *hybridOutput) constructOutput(contents ...interface{}) []interface{} {
+func (this *hybridOutput) constructOutput(contents ...interface{}) ([]interface{}, error) {
 	contentList := make([]interface{}, len(contents))
 	for i, content := range contents {
-		encoded, err := proto.Marshal(content.(proto.Message))
+		if raw, ok := content.(core.RawField); ok {
+			encoded := []byte(raw)
+			contentList[i] = &encoded
+			continue
+		}
+		protoContent, ok := content.(proto.Message)
+		if !ok {
+			return nil, core.NewFieldError("hybrid", "ShellMsg", i, fmt.Errorf("%T is not a protocol buffer message", content))
+		}
+		encoded, err := proto.Marshal(protoContent)
 		if err != nil {
-			panic(err)
+			return nil, core.NewFieldError("hybrid", "ShellMsg", i, err)
 		}
 		contentList[i] = &encoded
 	}
-	return contentList
+	return contentList, nil
+}
+
+func (this *hybridOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	meta := &messages.ShellMsgMeta{
+		Command:     command,
+		Anchors:     anchors,
+		Id:          &id,
+		Stream:      &stream,
+		Task:        &directTask,
+		NeedTaskIds: &needTaskIds,
+		Msg:         &msg,
+	}
+	return this.EmitShellMsg(meta, contents...)
 }
 
-func (this *hybridOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) {
+func (this *hybridOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	contentList, err := this.constructOutput(contents...)
+	if err != nil {
+		return err
+	}
 	shellMsg := &messages.ShellMsg{
 		ShellMsgJson: &messages.ShellMsgJson{
-			ShellMsgMeta: &messages.ShellMsgMeta{
-				Command:     command,
-				Anchors:     anchors,
-				Id:          &id,
-				Stream:      &stream,
-				Task:        &directTask,
-				NeedTaskIds: &needTaskIds,
-				Msg:         &msg,
-			},
-			Contents: this.constructOutput(contents...),
+			ShellMsgMeta: meta,
+			Contents:     contentList,
 		},
 	}
-	this.SendMsg(shellMsg)
+	return this.SendMsg(shellMsg)
 }
 
-func (this *hybridOutput) Flush() {
-	this.writer.Flush()
+func (this *hybridOutput) Flush() (err error) {
+	return this.writer.Flush()
 }
 
 func init() {
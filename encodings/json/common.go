@@ -19,19 +19,24 @@ import (
 	"bytes"
 	"container/list"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
 	"io"
 	"log"
 )
 
-func newJsonInput(reader io.Reader) *jsonInput {
+func newJsonInput(encoding string, reader io.Reader) *jsonInput {
 	return &jsonInput{
+		encoding:    encoding,
 		reader:      bufio.NewReader(reader),
 		tupleBuffer: list.New(),
 	}
 }
 
 type jsonInput struct {
+	encoding    string
 	reader      *bufio.Reader
 	tupleBuffer *list.List
 }
@@ -46,9 +51,9 @@ func (this *jsonInput) readData() (data []byte, err error) {
 	//Read the end delimiter
 	_, err = this.reader.ReadBytes('\n')
 	if err == io.EOF {
-		panic("EOF received at end statement. Is there newline after every end statement (including the last one)?")
+		return nil, core.NewError(this.encoding, "end", errors.New("EOF received at end statement. Is there newline after every end statement (including the last one)?"))
 	} else if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Remove the newline character
@@ -56,34 +61,62 @@ func (this *jsonInput) readData() (data []byte, err error) {
 	return data, nil
 }
 
-// readBytes reads data from stdin into the struct provided.
-func (this *jsonInput) ReadMsg(msg interface{}) (err error) {
-	var data []byte
+// Buffered returns the number of tuples and bytes that have been read
+// ahead, which can be read without blocking
+func (this *jsonInput) Buffered() int {
+	return this.tupleBuffer.Len() + this.reader.Buffered()
+}
+
+// next returns the next message, either from the tuple buffer or from Storm
+func (this *jsonInput) next() (data []byte, err error) {
 	// Read data from the tuple buffer
 	if this.tupleBuffer.Len() > 0 {
 		e := this.tupleBuffer.Front()
-		data = this.tupleBuffer.Remove(e).([]byte)
-		// if the tuple buffer is empty, read data from storm
-	} else {
-		data, err = this.readData()
-		if err != nil {
-			return err
-		}
+		return this.tupleBuffer.Remove(e).([]byte), nil
+	}
+	// if the tuple buffer is empty, read data from storm
+	return this.readData()
+}
+
+// readBytes reads data from stdin into the struct provided.
+func (this *jsonInput) ReadMsg(msg interface{}) (err error) {
+	data, err := this.next()
+	if err != nil {
+		return err
 	}
 
 	err = json.Unmarshal(data, msg)
 	if err != nil {
 		log.Printf("core json: Unmarshalling: %s", data)
-		return err
+		return core.NewError(this.encoding, core.MsgName(msg), err)
 	}
 	return nil
 }
 
-func (this *jsonInput) ReadTaskIds() (taskIds []int32) {
+// readBoltMsg reads a tuple from Storm into its metadata and returns the
+// raw json of its fields
+func (this *jsonInput) readBoltMsg(metadata *messages.BoltMsgMeta) (raw [][]byte, err error) {
+	data, err := this.next()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err = parseBoltMsg(data, metadata)
+	if err != nil {
+		log.Printf("core json: Unmarshalling: %s", data)
+		return nil, core.NewError(this.encoding, "BoltMsg", err)
+	}
+	return raw, nil
+}
+
+func (this *jsonInput) ReadTaskIds() (taskIds []int32, err error) {
 	// Read a single json record from the input file
 	data, err := this.readData()
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, core.NewError(this.encoding, "TaskIds", errors.New("empty message"))
 	}
 
 	// If we didn't receive a json array, treat it as a tuple instead
@@ -94,32 +127,72 @@ func (this *jsonInput) ReadTaskIds() (taskIds []int32) {
 
 	err = json.Unmarshal(data, &taskIds)
 	if err != nil {
-		panic(err)
+		return nil, core.NewError(this.encoding, "TaskIds", err)
 	}
-	return taskIds
+	return taskIds, nil
 }
 
-func newJsonOutput(writer io.Writer) *jsonOutput {
+// decodeRaw unmarshals the raw json of every received field into the
+// corresponding content struct. Tuples such as heartbeats may contain
+// fewer fields than expected. Decoding is skipped if no content
+// structs are provided.
+func decodeRaw(encoding string, raw [][]byte, contentStructs ...interface{}) error {
+	if len(contentStructs) == 0 {
+		return nil
+	}
+	if len(raw) > len(contentStructs) {
+		decodeErr := core.NewFieldError(encoding, "BoltMsg", len(contentStructs), fmt.Errorf("received %d fields, expected at most %d", len(raw), len(contentStructs)))
+		decodeErr.Raw = raw
+		return decodeErr
+	}
+	for i, data := range raw {
+		err := json.Unmarshal(data, contentStructs[i])
+		if err != nil {
+			decodeErr := core.NewFieldError(encoding, "BoltMsg", i, err)
+			decodeErr.Raw = raw
+			return decodeErr
+		}
+	}
+	return nil
+}
+
+func newShellMsgMeta(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool) *messages.ShellMsgMeta {
+	return &messages.ShellMsgMeta{
+		Command:     command,
+		Anchors:     anchors,
+		Id:          &id,
+		Stream:      &stream,
+		Task:        &directTask,
+		NeedTaskIds: &needTaskIds,
+		Msg:         &msg,
+	}
+}
+
+func newJsonOutput(encoding string, writer io.Writer) *jsonOutput {
 	return &jsonOutput{
-		writer: bufio.NewWriter(writer),
+		encoding: encoding,
+		writer:   bufio.NewWriter(writer),
 	}
 }
 
 type jsonOutput struct {
-	writer *bufio.Writer
+	encoding string
+	writer   *bufio.Writer
 }
 
-// sendMsg sends the contents of a known Storm message to Storm
-func (this *jsonOutput) SendMsg(msg interface{}) {
-	data, err := json.Marshal(msg)
+// sendMsg sends the contents of a known Storm message to Storm. The
+// message is encoded directly into the buffer of the writer.
+func (this *jsonOutput) SendMsg(msg interface{}) (err error) {
+	buf, err := this.appendMsg(this.writer.AvailableBuffer(), msg)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Fprintln(this.writer, string(data))
 	// Storm requires that every message be suffixed with an "end" string
-	fmt.Fprintln(this.writer, "end")
+	buf = append(buf, "\nend\n"...)
+	_, err = this.writer.Write(buf)
+	return err
 }
 
-func (this *jsonOutput) Flush() {
-	this.writer.Flush()
+func (this *jsonOutput) Flush() (err error) {
+	return this.writer.Flush()
 }
@@ -0,0 +1,707 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The json encodings write the multilang envelope of the messages they
+// send by hand and parse the envelope of received tuples without using
+// reflection. Only the tuple fields themselves are passed to
+// encoding/json, and only if they are not already encoded.
+
+// appendMsg appends the json encoding of a message to buf. Messages
+// that are not part of the multilang protocol are marshalled by
+// encoding/json.
+func (this *jsonOutput) appendMsg(buf []byte, msg interface{}) ([]byte, error) {
+	switch msg := msg.(type) {
+	case *messages.ShellMsg:
+		if msg.ShellMsgJson != nil {
+			return this.appendShellMsg(buf, msg.ShellMsgJson)
+		}
+	case *messages.BoltMsg:
+		if msg.BoltMsgJson != nil {
+			return this.appendBoltMsg(buf, msg.BoltMsgJson)
+		}
+	case *messages.SpoutMsg:
+		buf = append(buf, `{"command":`...)
+		buf = appendString(buf, msg.Command)
+		if len(msg.Id) > 0 {
+			buf = append(buf, `,"id":`...)
+			buf = appendString(buf, msg.Id)
+		}
+		return append(buf, '}'), nil
+	case *messages.Pid:
+		buf = append(buf, `{"pid":`...)
+		buf = strconv.AppendInt(buf, int64(msg.Pid), 10)
+		return append(buf, '}'), nil
+	case []int32:
+		buf = append(buf, '[')
+		for i, taskId := range msg {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = strconv.AppendInt(buf, int64(taskId), 10)
+		}
+		return append(buf, ']'), nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return buf, core.NewError(this.encoding, core.MsgName(msg), err)
+	}
+	return append(buf, data...), nil
+}
+
+// appendShellMsg appends a command sent to Storm. Keys are written in
+// sorted order and only if they are set, as encoding/json would write
+// them from a map.
+func (this *jsonOutput) appendShellMsg(buf []byte, msg *messages.ShellMsgJson) (_ []byte, err error) {
+	meta := msg.ShellMsgMeta
+	buf = append(buf, '{')
+	if len(meta.Anchors) > 0 {
+		buf = append(buf, `"anchors":[`...)
+		for i, anchor := range meta.Anchors {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendString(buf, anchor)
+		}
+		buf = append(buf, "],"...)
+	}
+	buf = append(buf, `"command":`...)
+	buf = appendString(buf, meta.Command)
+	if id := meta.GetId(); len(id) > 0 {
+		buf = append(buf, `,"id":`...)
+		buf = appendString(buf, id)
+	}
+	if meta.Level != nil {
+		buf = append(buf, `,"level":`...)
+		buf = strconv.AppendInt(buf, int64(*meta.Level), 10)
+	}
+	if logMsg := meta.GetMsg(); len(logMsg) > 0 {
+		buf = append(buf, `,"msg":`...)
+		buf = appendString(buf, logMsg)
+	}
+	if name := meta.GetName(); len(name) > 0 {
+		buf = append(buf, `,"name":`...)
+		buf = appendString(buf, name)
+	}
+	if meta.Command == "emit" && !meta.GetNeedTaskIds() {
+		buf = append(buf, `,"need_task_ids":false`...)
+	}
+	if params := meta.GetParams(); len(params) > 0 {
+		buf = append(buf, `,"params":`...)
+		buf, err = appendRaw(buf, params)
+		if err != nil {
+			return buf, core.NewError(this.encoding, "ShellMsg", err)
+		}
+	}
+	if stream := meta.GetStream(); len(stream) > 0 {
+		buf = append(buf, `,"stream":`...)
+		buf = appendString(buf, stream)
+	}
+	if task := meta.GetTask(); task != 0 {
+		buf = append(buf, `,"task":`...)
+		buf = strconv.AppendInt(buf, task, 10)
+	}
+	if len(msg.Contents) > 0 {
+		buf = append(buf, `,"tuple":`...)
+		buf, err = this.appendContents(buf, "ShellMsg", msg.Contents)
+		if err != nil {
+			return buf, err
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+// appendBoltMsg appends a tuple sent by Storm, which is only done when
+// testing bolts without Storm
+func (this *jsonOutput) appendBoltMsg(buf []byte, msg *messages.BoltMsgJson) (_ []byte, err error) {
+	meta := msg.BoltMsgMeta
+	if meta == nil {
+		meta = &messages.BoltMsgMeta{}
+	}
+	buf = append(buf, `{"id":`...)
+	buf = appendString(buf, meta.Id)
+	buf = append(buf, `,"comp":`...)
+	buf = appendString(buf, meta.Comp)
+	buf = append(buf, `,"stream":`...)
+	buf = appendString(buf, meta.Stream)
+	buf = append(buf, `,"task":`...)
+	buf = strconv.AppendInt(buf, meta.Task, 10)
+	buf = append(buf, `,"tuple":`...)
+	buf, err = this.appendContents(buf, "BoltMsg", msg.Contents)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, '}'), nil
+}
+
+// appendContents appends the fields of a tuple as a json array. Fields
+// of the types used by the json encodings are appended directly and
+// other fields are marshalled by encoding/json.
+func (this *jsonOutput) appendContents(buf []byte, msgName string, contents []interface{}) (_ []byte, err error) {
+	buf = append(buf, '[')
+	for i, content := range contents {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		switch content := content.(type) {
+		case nil:
+			buf = append(buf, "null"...)
+		case json.RawMessage:
+			buf, err = appendRaw(buf, content)
+		case *[]byte:
+			if content == nil {
+				buf = append(buf, "null"...)
+			} else {
+				buf = appendBytes(buf, *content)
+			}
+		case []byte:
+			buf = appendBytes(buf, content)
+		case string:
+			buf = appendString(buf, content)
+		case bool:
+			buf = strconv.AppendBool(buf, content)
+		case int:
+			buf = strconv.AppendInt(buf, int64(content), 10)
+		case int32:
+			buf = strconv.AppendInt(buf, int64(content), 10)
+		case int64:
+			buf = strconv.AppendInt(buf, content, 10)
+		default:
+			var data []byte
+			data, err = json.Marshal(content)
+			buf = append(buf, data...)
+		}
+		if err != nil {
+			return buf, core.NewFieldError(this.encoding, msgName, i, err)
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+const hex = "0123456789abcdef"
+
+// appendString appends s as a json string. Characters that are special
+// in html are escaped as encoding/json escapes them and invalid utf-8
+// is replaced by the unicode replacement character.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid json, but not valid javascript
+		if c == '\u2028' || c == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// appendBytes appends a byte slice as a base64 string, as encoding/json does
+func appendBytes(buf []byte, data []byte) []byte {
+	if data == nil {
+		return append(buf, "null"...)
+	}
+	buf = append(buf, '"')
+	start := len(buf)
+	buf = append(buf, make([]byte, base64.StdEncoding.EncodedLen(len(data)))...)
+	base64.StdEncoding.Encode(buf[start:], data)
+	return append(buf, '"')
+}
+
+// appendRaw appends encoded json after validating it. Newlines would
+// end the message early, so json that contains them is compacted.
+func appendRaw(buf []byte, raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return append(buf, "null"...), nil
+	}
+	if bytes.IndexAny(raw, "\r\n") < 0 && json.Valid(raw) {
+		return append(buf, raw...), nil
+	}
+	compacted := bytes.NewBuffer(buf)
+	err := json.Compact(compacted, raw)
+	return compacted.Bytes(), err
+}
+
+var errEnd = errors.New("unexpected end of json input")
+
+// scanner parses the json envelope of a message received from Storm
+type scanner struct {
+	data []byte
+	pos  int
+}
+
+func (this *scanner) syntaxError(msg string) error {
+	if this.pos >= len(this.data) {
+		return errEnd
+	}
+	return fmt.Errorf("invalid character %q %s at offset %d", this.data[this.pos], msg, this.pos)
+}
+
+func (this *scanner) skipSpace() {
+	for this.pos < len(this.data) {
+		switch this.data[this.pos] {
+		case ' ', '\t', '\r', '\n':
+			this.pos++
+		default:
+			return
+		}
+	}
+}
+
+// consume skips whitespace and the given character, if it is next
+func (this *scanner) consume(c byte) bool {
+	this.skipSpace()
+	if this.pos < len(this.data) && this.data[this.pos] == c {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+// null consumes a json null, if it is next
+func (this *scanner) null() bool {
+	this.skipSpace()
+	if bytes.HasPrefix(this.data[this.pos:], []byte("null")) {
+		this.pos += len("null")
+		return true
+	}
+	return false
+}
+
+// object calls found for every key of a json object, after which found
+// has to consume the value of the key
+func (this *scanner) object(found func(key []byte) error) error {
+	if !this.consume('{') {
+		return this.syntaxError("looking for beginning of object")
+	}
+	if this.consume('}') {
+		return nil
+	}
+	for {
+		this.skipSpace()
+		key, escaped, err := this.strSpan()
+		if err != nil {
+			return err
+		}
+		if escaped {
+			unescaped, err := unescape(key)
+			if err != nil {
+				return err
+			}
+			key = []byte(unescaped)
+		}
+		if !this.consume(':') {
+			return this.syntaxError("after object key")
+		}
+		err = found(key)
+		if err != nil {
+			return err
+		}
+		if this.consume('}') {
+			return nil
+		}
+		if !this.consume(',') {
+			return this.syntaxError("after object key:value pair")
+		}
+	}
+}
+
+// array appends the raw json of every value in a json array to values
+func (this *scanner) array(values [][]byte) ([][]byte, error) {
+	if this.null() {
+		return values, nil
+	}
+	if !this.consume('[') {
+		return values, this.syntaxError("looking for beginning of array")
+	}
+	if this.consume(']') {
+		return values, nil
+	}
+	for {
+		value, err := this.value()
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+		if this.consume(']') {
+			return values, nil
+		}
+		if !this.consume(',') {
+			return values, this.syntaxError("after array element")
+		}
+	}
+}
+
+// strSpan returns the contents of the json string at the current
+// position, without its quotes, and whether it has to be unescaped
+func (this *scanner) strSpan() (span []byte, escaped bool, err error) {
+	if this.pos >= len(this.data) || this.data[this.pos] != '"' {
+		return nil, false, this.syntaxError("looking for beginning of string")
+	}
+	this.pos++
+	start := this.pos
+	for this.pos < len(this.data) {
+		switch c := this.data[this.pos]; {
+		case c == '"':
+			span = this.data[start:this.pos]
+			this.pos++
+			return span, escaped, nil
+		case c == '\\':
+			escaped = true
+			this.pos += 2
+			continue
+		case c < ' ':
+			return nil, false, this.syntaxError("in string literal")
+		case c >= utf8.RuneSelf:
+			// Invalid utf-8 is replaced when the string is unescaped
+			escaped = true
+		}
+		this.pos++
+	}
+	return nil, false, errEnd
+}
+
+// str returns the json string at the current position. A null is
+// returned as an empty string.
+func (this *scanner) str() (string, error) {
+	if this.null() {
+		return "", nil
+	}
+	span, escaped, err := this.strSpan()
+	if err != nil {
+		return "", err
+	}
+	if escaped && (bytes.IndexByte(span, '\\') >= 0 || !utf8.Valid(span)) {
+		return unescape(span)
+	}
+	return string(span), nil
+}
+
+// int returns the json integer at the current position. A null is
+// returned as zero.
+func (this *scanner) int() (int64, error) {
+	if this.null() {
+		return 0, nil
+	}
+	start := this.pos
+	if this.pos < len(this.data) && this.data[this.pos] == '-' {
+		this.pos++
+	}
+	for this.pos < len(this.data) && this.data[this.pos] >= '0' && this.data[this.pos] <= '9' {
+		this.pos++
+	}
+	value, err := strconv.ParseInt(string(this.data[start:this.pos]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q at offset %d as an integer", this.data[start:this.pos], start)
+	}
+	return value, nil
+}
+
+// value returns the raw json of the value at the current position. The
+// structure of nested objects and arrays is only checked once they are
+// decoded.
+func (this *scanner) value() (raw []byte, err error) {
+	this.skipSpace()
+	if this.pos >= len(this.data) {
+		return nil, errEnd
+	}
+	start := this.pos
+	switch c := this.data[this.pos]; {
+	case c == '"':
+		_, _, err = this.strSpan()
+	case c == '{' || c == '[':
+		err = this.nested()
+	case c == 't':
+		err = this.literal("true")
+	case c == 'f':
+		err = this.literal("false")
+	case c == 'n':
+		err = this.literal("null")
+	case c == '-' || (c >= '0' && c <= '9'):
+		err = this.number()
+	default:
+		err = this.syntaxError("looking for beginning of value")
+	}
+	return this.data[start:this.pos], err
+}
+
+func (this *scanner) nested() error {
+	depth := 0
+	for this.pos < len(this.data) {
+		switch this.data[this.pos] {
+		case '"':
+			_, _, err := this.strSpan()
+			if err != nil {
+				return err
+			}
+			continue
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				this.pos++
+				return nil
+			}
+		}
+		this.pos++
+	}
+	return errEnd
+}
+
+func (this *scanner) literal(literal string) error {
+	if !bytes.HasPrefix(this.data[this.pos:], []byte(literal)) {
+		return this.syntaxError("in literal " + literal)
+	}
+	this.pos += len(literal)
+	return nil
+}
+
+func (this *scanner) digits() int {
+	start := this.pos
+	for this.pos < len(this.data) && this.data[this.pos] >= '0' && this.data[this.pos] <= '9' {
+		this.pos++
+	}
+	return this.pos - start
+}
+
+func (this *scanner) number() error {
+	if this.data[this.pos] == '-' {
+		this.pos++
+	}
+	if this.pos < len(this.data) && this.data[this.pos] == '0' {
+		this.pos++
+	} else if this.digits() == 0 {
+		return this.syntaxError("in numeric literal")
+	}
+	if this.pos < len(this.data) && this.data[this.pos] == '.' {
+		this.pos++
+		if this.digits() == 0 {
+			return this.syntaxError("after decimal point in numeric literal")
+		}
+	}
+	if this.pos < len(this.data) && (this.data[this.pos] == 'e' || this.data[this.pos] == 'E') {
+		this.pos++
+		if this.pos < len(this.data) && (this.data[this.pos] == '+' || this.data[this.pos] == '-') {
+			this.pos++
+		}
+		if this.digits() == 0 {
+			return this.syntaxError("in exponent of numeric literal")
+		}
+	}
+	return nil
+}
+
+// unescape returns the contents of a json string with its escape
+// sequences replaced
+func unescape(span []byte) (string, error) {
+	unescaped := make([]byte, 0, len(span))
+	for i := 0; i < len(span); {
+		c := span[i]
+		if c != '\\' {
+			r, size := utf8.DecodeRune(span[i:])
+			if r == utf8.RuneError && size == 1 {
+				unescaped = utf8.AppendRune(unescaped, utf8.RuneError)
+			} else {
+				unescaped = append(unescaped, span[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		if i+1 >= len(span) {
+			return "", errEnd
+		}
+		switch span[i+1] {
+		case '"', '\\', '/':
+			unescaped = append(unescaped, span[i+1])
+		case 'b':
+			unescaped = append(unescaped, '\b')
+		case 'f':
+			unescaped = append(unescaped, '\f')
+		case 'n':
+			unescaped = append(unescaped, '\n')
+		case 'r':
+			unescaped = append(unescaped, '\r')
+		case 't':
+			unescaped = append(unescaped, '\t')
+		case 'u':
+			r, ok := hexRune(span[i+2:])
+			if !ok {
+				return "", fmt.Errorf("invalid escape sequence %q", span[i:])
+			}
+			i += 6
+			if utf16.IsSurrogate(r) {
+				low, ok := rune(0), false
+				if i+1 < len(span) && span[i] == '\\' && span[i+1] == 'u' {
+					low, ok = hexRune(span[i+2:])
+				}
+				if decoded := utf16.DecodeRune(r, low); ok && decoded != utf8.RuneError {
+					r = decoded
+					i += 6
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			unescaped = utf8.AppendRune(unescaped, r)
+			continue
+		default:
+			return "", fmt.Errorf("invalid escape sequence %q", span[i:i+2])
+		}
+		i += 2
+	}
+	return string(unescaped), nil
+}
+
+func hexRune(data []byte) (r rune, ok bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+	for _, c := range data[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+// keyIs reports whether a json key matches the name of a field, which
+// is done without regard to case, as encoding/json does
+func keyIs(key []byte, name string) bool {
+	if len(key) != len(name) {
+		return false
+	}
+	for i := range key {
+		c := key[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != name[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBoltMsg parses a tuple sent by Storm into its metadata and the
+// raw json of each of its fields, which refer to data
+func parseBoltMsg(data []byte, metadata *messages.BoltMsgMeta) (raw [][]byte, err error) {
+	s := &scanner{data: data}
+	err = s.object(func(key []byte) (err error) {
+		switch {
+		case keyIs(key, "id"):
+			metadata.Id, err = s.str()
+		case keyIs(key, "comp"):
+			metadata.Comp, err = s.str()
+		case keyIs(key, "stream"):
+			metadata.Stream, err = s.str()
+		case keyIs(key, "task"):
+			metadata.Task, err = s.int()
+		case keyIs(key, "tuple"):
+			// Most tuples have only a few fields
+			raw, err = s.array(make([][]byte, 0, 4))
+		default:
+			_, err = s.value()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.skipSpace(); s.pos < len(data) {
+		return nil, s.syntaxError("after top-level value")
+	}
+	return raw, nil
+}
+
+// decodeBase64 decodes a field that was sent as a base64 string
+func decodeBase64(raw []byte) ([]byte, error) {
+	s := &scanner{data: raw}
+	if s.null() {
+		return nil, nil
+	}
+	span, escaped, err := s.strSpan()
+	if err != nil {
+		return nil, err
+	}
+	if escaped {
+		unescaped, err := unescape(span)
+		if err != nil {
+			return nil, err
+		}
+		span = []byte(unescaped)
+	}
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(span)))
+	n, err := base64.StdEncoding.Decode(decoded, span)
+	if err != nil {
+		return nil, err
+	}
+	return decoded[:n], nil
+}
@@ -0,0 +1,219 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jsgilmore/gostorm/messages"
+	"reflect"
+	"testing"
+)
+
+// special contains characters that have to be escaped in json strings
+const special = "quote\" backslash\\ newline\n tab\t control\x01 html<>& unicode\u00e9\u2028\u2029 invalid\xff"
+
+func newTestShellMsg() *messages.ShellMsg {
+	meta := newShellMsgMeta("emit", special, special, special, []string{special, "2"}, 3, false)
+	level := int32(2)
+	meta.Level = &level
+	name := special
+	meta.Name = &name
+	meta.Params = []byte(`{"a": [1, 2],` + "\n" + `"b": null}`)
+	data := []byte(special)
+	return &messages.ShellMsg{
+		ShellMsgJson: &messages.ShellMsgJson{
+			ShellMsgMeta: meta,
+			Contents:     []interface{}{NewTestObj(special, 5, []byte(special)), special, &data, json.RawMessage(`{"raw": true}`), nil, true, 4, int64(-5), 1.5},
+		},
+	}
+}
+
+func decodeJson(data []byte, t *testing.T) (value interface{}) {
+	checkErr(json.Unmarshal(data, &value), t)
+	return value
+}
+
+func TestAppendShellMsg(t *testing.T) {
+	msg := newTestShellMsg()
+	output := newJsonOutput("test", nil)
+	data, err := output.appendMsg(nil, msg)
+	checkErr(err, t)
+	if bytes.IndexAny(data, "\r\n") >= 0 {
+		t.Fatalf("Message contains a newline: %s", data)
+	}
+
+	// The message is equivalent to the message encoding/json produces
+	expected, err := json.Marshal(msg)
+	checkErr(err, t)
+	if !reflect.DeepEqual(decodeJson(data, t), decodeJson(expected, t)) {
+		t.Fatalf("Messages differ:\n%s\n%s", data, expected)
+	}
+}
+
+func TestAppendInvalidRaw(t *testing.T) {
+	msg := newTestShellMsg()
+	msg.ShellMsgJson.Contents = []interface{}{"valid", json.RawMessage(`{"raw": `)}
+	output := newJsonOutput("test", nil)
+	_, err := output.appendMsg(nil, msg)
+	if err == nil {
+		t.Fatalf("Expected an error for invalid raw json")
+	}
+}
+
+func TestParseBoltMsg(t *testing.T) {
+	data, err := json.Marshal(special)
+	checkErr(err, t)
+	quoted := string(data)
+	tuples := []string{
+		`{"id":` + quoted + `,"comp":` + quoted + `,"stream":"s","task":-3,"tuple":[` + quoted + `,{"a":[1,"]"]},[],1.5e3,-0,true,false,null]}`,
+		` { "Id" : "😀é\/" , "unknown" : {"x": [1, 2]}, "TASK" : 7 , "tuple" : [ ] } `,
+		`{"id":"\ud83d","comp":null,"task":null,"tuple":null}`,
+		`{}`,
+	}
+	for _, tuple := range tuples {
+		metadata := &messages.BoltMsgMeta{}
+		raw, err := parseBoltMsg([]byte(tuple), metadata)
+		checkErr(err, t)
+
+		expectedMeta := &messages.BoltMsgMeta{}
+		expected := &struct {
+			*messages.BoltMsgMeta
+			Contents []json.RawMessage `json:"tuple"`
+		}{BoltMsgMeta: expectedMeta}
+		checkErr(json.Unmarshal([]byte(tuple), expected), t)
+		if !reflect.DeepEqual(metadata, expectedMeta) {
+			t.Errorf("Metadata differs for %s: %+v, expected %+v", tuple, metadata, expectedMeta)
+		}
+		if len(raw) != len(expected.Contents) {
+			t.Fatalf("Received %d fields for %s, expected %d", len(raw), tuple, len(expected.Contents))
+		}
+		for i := range raw {
+			if !bytes.Equal(raw[i], expected.Contents[i]) {
+				t.Errorf("Field %d differs for %s: %s, expected %s", i, tuple, raw[i], expected.Contents[i])
+			}
+		}
+	}
+}
+
+func TestParseInvalidBoltMsg(t *testing.T) {
+	tuples := []string{
+		``,
+		`[]`,
+		`{"id":"1"`,
+		`{"id":"1",}`,
+		`{"id":1}`,
+		`{"id":"1\x"}`,
+		"{\"id\":\"1\n\"}",
+		`{"task":"1"}`,
+		`{"task":1.5}`,
+		`{"tuple":[1,]}`,
+		`{"tuple":[1 2]}`,
+		`{"tuple":[tru]}`,
+		`{"tuple":[01]}`,
+		`{"tuple":[-]}`,
+		`{"tuple":[{"a":1]}`,
+		`{"tuple":{}}`,
+		`{} {}`,
+	}
+	for _, tuple := range tuples {
+		_, err := parseBoltMsg([]byte(tuple), &messages.BoltMsgMeta{})
+		if err == nil {
+			t.Errorf("Expected an error for %s", tuple)
+		}
+	}
+}
+
+func TestDecodeBase64(t *testing.T) {
+	for _, field := range []string{`"ZGF0YQ=="`, `"ZGF0YQ\u003d\u003d"`} {
+		data, err := decodeBase64([]byte(field))
+		checkErr(err, t)
+		if string(data) != "data" {
+			t.Errorf("Decoded %s as %q", field, data)
+		}
+	}
+	data, err := decodeBase64([]byte("null"))
+	if err != nil || data != nil {
+		t.Errorf("Decoded null as %q, %v", data, err)
+	}
+	for _, field := range []string{`"ZGF0YQ="`, `5`, `"ZGF0YQ==`} {
+		if _, err := decodeBase64([]byte(field)); err == nil {
+			t.Errorf("Expected an error for %s", field)
+		}
+	}
+}
+
+func newBenchShellMsg() *messages.ShellMsg {
+	data := []byte("some encoded data")
+	return &messages.ShellMsg{
+		ShellMsgJson: &messages.ShellMsgJson{
+			ShellMsgMeta: newShellMsgMeta("emit", "", "stream", "", []string{"-6955786537413359385"}, 0, false),
+			Contents:     []interface{}{&data, json.RawMessage(`{"Name":"name","Number":5}`), "text"},
+		},
+	}
+}
+
+var benchBoltMsg = []byte(`{"id":"-6955786537413359385","comp":"spout","stream":"default","task":9,"tuple":[{"Name":"name","Number":5},"text",3]}`)
+
+// BenchmarkShellMsgMarshalJSON measures the encoding of a message by encoding/json
+func BenchmarkShellMsgMarshalJSON(b *testing.B) {
+	msg := newBenchShellMsg()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := json.Marshal(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendShellMsg(b *testing.B) {
+	msg := newBenchShellMsg()
+	output := newJsonOutput("bench", nil)
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		buf, err = output.appendMsg(buf[:0], msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBoltMsgUnmarshal measures the decoding of a tuple by encoding/json
+func BenchmarkBoltMsgUnmarshal(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		boltMsg := &struct {
+			*messages.BoltMsgMeta
+			Contents []json.RawMessage `json:"tuple"`
+		}{BoltMsgMeta: &messages.BoltMsgMeta{}}
+		err := json.Unmarshal(benchBoltMsg, boltMsg)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseBoltMsg(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := parseBoltMsg(benchBoltMsg, &messages.BoltMsgMeta{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -33,7 +33,7 @@ func (this *jsonEncodedInputFactory) NewInput(reader io.Reader) core.Input {
 
 func NewJsonEncodedInput(reader io.Reader) core.Input {
 	return &jsonEncodedInput{
-		jsonInput: newJsonInput(reader),
+		jsonInput: newJsonInput("jsonEncoded", reader),
 	}
 }
 
@@ -41,42 +41,49 @@ type jsonEncodedInput struct {
 	*jsonInput
 }
 
-// ConstructInput for encoded json returns a list of bytes into which marshalled json should be written
-func (this *jsonEncodedInput) constructInput(contents ...interface{}) []interface{} {
-	contentList := make([]interface{}, len(contents))
-	for i := 0; i < len(contents); i++ {
-		contentList[i] = &[]byte{}
-	}
-	return contentList
+// ReadTuple reads a tuple from Storm of which the contents are known
+// and decodes the contents into the provided list of structs
+func (this *jsonEncodedInput) ReadBoltMsg(metadata *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
+	return this.ReadBoltMsgFunc(metadata, core.StaticFields(contentStructs))
 }
 
-func (this *jsonEncodedInput) decodeInput(contentList []interface{}, contentStructs ...interface{}) {
-	for i, content := range contentList {
-		err := json.Unmarshal(*content.(*[]byte), contentStructs[i])
-		if err != nil {
-			panic(err)
+// ReadBoltMsgFunc reads a tuple from Storm and decodes the contents
+// into the structs returned for the metadata of the tuple
+func (this *jsonEncodedInput) ReadBoltMsgFunc(metadata *messages.BoltMsgMeta, fields core.FieldsFunc) (err error) {
+	// Ensure that the metadata of a previous tuple is never reported
+	metadata.Reset()
+	raw, err := this.ReadRawBoltMsg(metadata)
+	if err != nil {
+		if encodingErr, ok := err.(*core.Error); ok {
+			encodingErr.Id = metadata.Id
 		}
+		return err
 	}
-}
 
-// ReadTuple reads a tuple from Storm of which the contents are known
-// and decodes the contents into the provided list of structs
-func (this *jsonEncodedInput) ReadBoltMsg(metadata *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
-	boltMsg := &messages.BoltMsg{
-		BoltMsgJson: &messages.BoltMsgJson{
-			BoltMsgMeta: metadata,
-			Contents:    this.constructInput(contentStructs...),
-		},
-	}
-	err = this.ReadMsg(boltMsg)
+	err = decodeRaw(this.encoding, raw, fields(metadata)...)
 	if err != nil {
+		err.(*core.Error).Id = metadata.Id
 		return err
 	}
-
-	this.decodeInput(boltMsg.BoltMsgJson.Contents, contentStructs...)
 	return nil
 }
 
+// ReadRawBoltMsg reads a tuple from Storm and returns its encoded fields
+func (this *jsonEncodedInput) ReadRawBoltMsg(metadata *messages.BoltMsgMeta) (raw [][]byte, err error) {
+	raw, err = this.readBoltMsg(metadata)
+	if err != nil {
+		return nil, err
+	}
+	// The fields are sent as base64 strings
+	for i, field := range raw {
+		raw[i], err = decodeBase64(field)
+		if err != nil {
+			return nil, core.NewFieldError(this.encoding, "BoltMsg", i, err)
+		}
+	}
+	return raw, nil
+}
+
 func NewJsonEncodedOutputFactory() core.OutputFactory {
 	return &jsonEncodedOutputFactory{}
 }
@@ -89,7 +96,7 @@ func (this *jsonEncodedOutputFactory) NewOutput(writer io.Writer) core.Output {
 
 func NewJsonEncodedOutput(writer io.Writer) core.Output {
 	return &jsonEncodedOutput{
-		jsonOutput: newJsonOutput(writer),
+		jsonOutput: newJsonOutput("jsonEncoded", writer),
 	}
 }
 
@@ -97,37 +104,87 @@ type jsonEncodedOutput struct {
 	*jsonOutput
 }
 
-func (this *jsonEncodedOutput) constructOutput(contents ...interface{}) []interface{} {
+func (this *jsonEncodedOutput) constructOutput(contents ...interface{}) ([]interface{}, error) {
 	contentList := make([]interface{}, len(contents))
 	for i, content := range contents {
+		if raw, ok := content.(core.RawField); ok {
+			encoded := []byte(raw)
+			contentList[i] = &encoded
+			continue
+		}
 		encoded, err := json.Marshal(content)
 		if err != nil {
-			panic(err)
+			return nil, core.NewFieldError(this.encoding, "ShellMsg", i, err)
 		}
 		contentList[i] = &encoded
 	}
-	return contentList
+	return contentList, nil
+}
+
+func (this *jsonEncodedOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	return this.EmitShellMsg(newShellMsgMeta(command, id, stream, msg, anchors, directTask, needTaskIds), contents...)
+}
+
+func (this *jsonEncodedOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	contentList, err := this.constructOutput(contents...)
+	if err != nil {
+		return err
+	}
+	shellMsg := &messages.ShellMsg{
+		ShellMsgJson: &messages.ShellMsgJson{
+			ShellMsgMeta: meta,
+			Contents:     contentList,
+		},
+	}
+	return this.SendMsg(shellMsg)
 }
 
-func (this *jsonEncodedOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) {
+// EmitRaw sends a message with fields that have already been encoded
+func (this *jsonEncodedOutput) EmitRaw(meta *messages.ShellMsgMeta, raw [][]byte) (err error) {
+	contentList := make([]interface{}, len(raw))
+	for i := range raw {
+		contentList[i] = &raw[i]
+	}
 	shellMsg := &messages.ShellMsg{
 		ShellMsgJson: &messages.ShellMsgJson{
-			ShellMsgMeta: &messages.ShellMsgMeta{
-				Command:     command,
-				Anchors:     anchors,
-				Id:          &id,
-				Stream:      &stream,
-				Task:        &directTask,
-				NeedTaskIds: &needTaskIds,
-				Msg:         &msg,
-			},
-			Contents: this.constructOutput(contents...),
+			ShellMsgMeta: meta,
+			Contents:     contentList,
 		},
 	}
-	this.SendMsg(shellMsg)
+	return this.SendMsg(shellMsg)
+}
+
+// The json envelope sends the encoded fields of tuples in the same way
+// as the jsonEncoded encoding, as base64 strings. It is combined with a
+// payload codec using encoding names such as "json+gob".
+
+func NewJsonEnvelopeInputFactory() core.EnvelopeInputFactory {
+	return &jsonEnvelopeInputFactory{}
+}
+
+type jsonEnvelopeInputFactory struct{}
+
+func (this *jsonEnvelopeInputFactory) NewEnvelopeInput(reader io.Reader) core.EnvelopeInput {
+	return &jsonEncodedInput{
+		jsonInput: newJsonInput("json", reader),
+	}
+}
+
+func NewJsonEnvelopeOutputFactory() core.EnvelopeOutputFactory {
+	return &jsonEnvelopeOutputFactory{}
+}
+
+type jsonEnvelopeOutputFactory struct{}
+
+func (this *jsonEnvelopeOutputFactory) NewEnvelopeOutput(writer io.Writer) core.EnvelopeOutput {
+	return &jsonEncodedOutput{
+		jsonOutput: newJsonOutput("json", writer),
+	}
 }
 
 func init() {
 	core.RegisterInput("jsonEncoded", NewJsonEncodedInputFactory())
 	core.RegisterOutput("jsonEncoded", NewJsonEncodedOutputFactory())
+	core.RegisterEnvelopeInput("json", NewJsonEnvelopeInputFactory())
+	core.RegisterEnvelopeOutput("json", NewJsonEnvelopeOutputFactory())
 }
@@ -0,0 +1,118 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package json
+
+import (
+	"encoding/json"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+	"io"
+)
+
+// The jsonNative encoding embeds every tuple field as raw json in the
+// tuple array, so that tuples can be exchanged with multilang
+// components written in other languages. Fields are encoded and only
+// decoded once they are read into the structs of a bolt, one field at a
+// time, using the json payload codec. Any other payload codec that
+// produces json can be used with an encoding name such as
+// "jsonNative+codec".
+
+func NewJsonNativeInputFactory() core.InputFactory {
+	return &jsonNativeInputFactory{}
+}
+
+type jsonNativeInputFactory struct{}
+
+func (this *jsonNativeInputFactory) NewInput(reader io.Reader) core.Input {
+	return NewJsonNativeInput(reader)
+}
+
+func NewJsonNativeInput(reader io.Reader) core.Input {
+	return core.NewPayloadInput("jsonNative", newJsonNativeInput(reader), core.LookupPayloadCodec("json"))
+}
+
+func newJsonNativeInput(reader io.Reader) *jsonNativeInput {
+	return &jsonNativeInput{
+		jsonInput: newJsonInput("jsonNative", reader),
+	}
+}
+
+type jsonNativeInput struct {
+	*jsonInput
+}
+
+// ReadRawBoltMsg reads a tuple from Storm and returns the raw json of
+// its fields
+func (this *jsonNativeInput) ReadRawBoltMsg(metadata *messages.BoltMsgMeta) (raw [][]byte, err error) {
+	return this.readBoltMsg(metadata)
+}
+
+func NewJsonNativeOutputFactory() core.OutputFactory {
+	return &jsonNativeOutputFactory{}
+}
+
+type jsonNativeOutputFactory struct{}
+
+func (this *jsonNativeOutputFactory) NewOutput(writer io.Writer) core.Output {
+	return NewJsonNativeOutput(writer)
+}
+
+func NewJsonNativeOutput(writer io.Writer) core.Output {
+	return core.NewPayloadOutput("jsonNative", newJsonNativeOutput(writer), core.LookupPayloadCodec("json"))
+}
+
+func newJsonNativeOutput(writer io.Writer) *jsonNativeOutput {
+	return &jsonNativeOutput{
+		jsonOutput: newJsonOutput("jsonNative", writer),
+	}
+}
+
+type jsonNativeOutput struct {
+	*jsonOutput
+}
+
+// EmitRaw sends a message with fields that have already been encoded as json
+func (this *jsonNativeOutput) EmitRaw(meta *messages.ShellMsgMeta, raw [][]byte) (err error) {
+	contentList := make([]interface{}, len(raw))
+	for i, content := range raw {
+		contentList[i] = json.RawMessage(content)
+	}
+	shellMsg := &messages.ShellMsg{
+		ShellMsgJson: &messages.ShellMsgJson{
+			ShellMsgMeta: meta,
+			Contents:     contentList,
+		},
+	}
+	return this.SendMsg(shellMsg)
+}
+
+type jsonNativeEnvelopeInputFactory struct{}
+
+func (this *jsonNativeEnvelopeInputFactory) NewEnvelopeInput(reader io.Reader) core.EnvelopeInput {
+	return newJsonNativeInput(reader)
+}
+
+type jsonNativeEnvelopeOutputFactory struct{}
+
+func (this *jsonNativeEnvelopeOutputFactory) NewEnvelopeOutput(writer io.Writer) core.EnvelopeOutput {
+	return newJsonNativeOutput(writer)
+}
+
+func init() {
+	core.RegisterInput("jsonNative", NewJsonNativeInputFactory())
+	core.RegisterOutput("jsonNative", NewJsonNativeOutputFactory())
+	core.RegisterEnvelopeInput("jsonNative", &jsonNativeEnvelopeInputFactory{})
+	core.RegisterEnvelopeOutput("jsonNative", &jsonNativeEnvelopeOutputFactory{})
+}
@@ -0,0 +1,74 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package json
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+	"testing"
+)
+
+func TestNativeEmit(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	output := NewJsonNativeOutput(buffer)
+
+	outMsg := NewTestObj("name", 5, []byte("data"))
+	err := output.EmitGeneric("emit", "", "stream", "", []string{"1"}, 0, true, outMsg, "text", core.RawField(`{"raw":true}`))
+	checkErr(err, t)
+	checkErr(output.Flush(), t)
+
+	// Fields are embedded as json instead of as base64 strings
+	expect(`{"anchors":["1"],"command":"emit","stream":"stream","tuple":[{"Name":"name","Number":5,"Data":"ZGF0YQ=="},"text",{"raw":true}]}`, buffer, t)
+	expect("end", buffer, t)
+}
+
+func TestNativeReadBoltMsg(t *testing.T) {
+	// A tuple as emitted by a Python or Java multilang component
+	buffer := bytes.NewBufferString(`{"id":"1","comp":"spout","stream":"default","task":4,"tuple":[{"Name":"name","Number":5},"text",3]}` + "\nend\n")
+	input := NewJsonNativeInput(buffer)
+
+	inMsg := &testObj{}
+	var text string
+	var number int
+	meta := &messages.BoltMsgMeta{}
+	checkErr(input.ReadBoltMsg(meta, inMsg, &text, &number), t)
+	if meta.Id != "1" || meta.Comp != "spout" || meta.Task != 4 {
+		t.Fatalf("Unexpected tuple metadata: %+v", meta)
+	}
+	if !inMsg.Equal(NewTestObj("name", 5, nil)) || text != "text" || number != 3 {
+		t.Fatalf("Unexpected tuple fields: %+v, %s, %d", inMsg, text, number)
+	}
+}
+
+func TestNativeDecodeError(t *testing.T) {
+	buffer := bytes.NewBufferString(`{"id":"1","tuple":["text",{"not":"a number"}]}` + "\nend\n")
+	input := NewJsonNativeInput(buffer)
+
+	var text string
+	var number int
+	err := input.ReadBoltMsg(&messages.BoltMsgMeta{}, &text, &number)
+	decodeErr, ok := err.(*core.Error)
+	if !ok {
+		t.Fatalf("Expected an encoding error, received: %v", err)
+	}
+	if decodeErr.Encoding != "jsonNative" || decodeErr.Id != "1" || decodeErr.Field != 1 {
+		t.Fatalf("Unexpected encoding error: %v", decodeErr)
+	}
+	if raw := fmt.Sprintf("%s", decodeErr.Raw); raw != `["text" {"not":"a number"}]` {
+		t.Fatalf("Expected the raw json of the fields, received: %s", raw)
+	}
+}
@@ -15,6 +15,7 @@
 package json
 
 import (
+	"encoding/json"
 	"github.com/jsgilmore/gostorm/core"
 	"github.com/jsgilmore/gostorm/messages"
 	"io"
@@ -32,7 +33,7 @@ func (this *jsonObjectInputFactory) NewInput(reader io.Reader) core.Input {
 
 func NewJsonObjectInput(reader io.Reader) core.Input {
 	return &jsonObjectInput{
-		jsonInput: newJsonInput(reader),
+		jsonInput: newJsonInput("jsonObject", reader),
 	}
 }
 
@@ -40,30 +41,30 @@ type jsonObjectInput struct {
 	*jsonInput
 }
 
-// ConstructInput for object json returns a list of objects into which json objects should be unmarshalled
-func (this *jsonObjectInput) constructInput(contents ...interface{}) []interface{} {
-	contentList := make([]interface{}, len(contents))
-	for i, content := range contents {
-		contentList[i] = content
-	}
-	return contentList
-}
-
 // ReadTuple reads a tuple from Storm of which the contents are known
 // and decodes the contents into the provided list of structs
 func (this *jsonObjectInput) ReadBoltMsg(metadata *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
-	boltMsg := &messages.BoltMsg{
-		BoltMsgJson: &messages.BoltMsgJson{
-			BoltMsgMeta: metadata,
-			Contents:    this.constructInput(contentStructs...),
-		},
-	}
+	return this.ReadBoltMsgFunc(metadata, core.StaticFields(contentStructs))
+}
 
-	err = this.ReadMsg(boltMsg)
+// ReadBoltMsgFunc reads a tuple from Storm and decodes the contents
+// into the structs returned for the metadata of the tuple
+func (this *jsonObjectInput) ReadBoltMsgFunc(metadata *messages.BoltMsgMeta, fields core.FieldsFunc) (err error) {
+	// Ensure that the metadata of a previous tuple is never reported
+	metadata.Reset()
+	raw, err := this.readBoltMsg(metadata)
 	if err != nil {
+		if encodingErr, ok := err.(*core.Error); ok {
+			encodingErr.Id = metadata.Id
+		}
 		return err
 	}
 
+	err = decodeRaw(this.encoding, raw, fields(metadata)...)
+	if err != nil {
+		err.(*core.Error).Id = metadata.Id
+		return err
+	}
 	return nil
 }
 
@@ -79,7 +80,7 @@ func (this *jsonObjectOutputFactory) NewOutput(writer io.Writer) core.Output {
 
 func NewJsonObjectOutput(writer io.Writer) core.Output {
 	return &jsonObjectOutput{
-		jsonOutput: newJsonOutput(writer),
+		jsonOutput: newJsonOutput("jsonObject", writer),
 	}
 }
 
@@ -90,27 +91,27 @@ type jsonObjectOutput struct {
 func (this *jsonObjectOutput) constructOutput(contents ...interface{}) []interface{} {
 	contentList := make([]interface{}, len(contents))
 	for i, content := range contents {
+		if raw, ok := content.(core.RawField); ok {
+			contentList[i] = json.RawMessage(raw)
+			continue
+		}
 		contentList[i] = content
 	}
 	return contentList
 }
 
-func (this *jsonObjectOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) {
+func (this *jsonObjectOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	return this.EmitShellMsg(newShellMsgMeta(command, id, stream, msg, anchors, directTask, needTaskIds), contents...)
+}
+
+func (this *jsonObjectOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
 	shellMsg := &messages.ShellMsg{
 		ShellMsgJson: &messages.ShellMsgJson{
-			ShellMsgMeta: &messages.ShellMsgMeta{
-				Command:     command,
-				Anchors:     anchors,
-				Id:          &id,
-				Stream:      &stream,
-				Task:        &directTask,
-				NeedTaskIds: &needTaskIds,
-				Msg:         &msg,
-			},
-			Contents: this.constructOutput(contents...),
+			ShellMsgMeta: meta,
+			Contents:     this.constructOutput(contents...),
 		},
 	}
-	this.SendMsg(shellMsg)
+	return this.SendMsg(shellMsg)
 }
 
 func init() {
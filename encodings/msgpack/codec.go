@@ -0,0 +1,1000 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RawMessage is an encoded msgpack value. It is encoded as is and can
+// be used to delay decoding.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// Marshal returns the msgpack encoding of v. Structs are encoded as maps
+// that are keyed by the names of their exported fields. The key of a
+// field can be changed with a msgpack tag, or otherwise a json tag,
+// which has the same format as the tags of encoding/json.
+func Marshal(v interface{}) ([]byte, error) {
+	return Append(nil, v)
+}
+
+// Append appends the msgpack encoding of v to data
+func Append(data []byte, v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return appendNil(data), nil
+	case RawMessage:
+		return appendRaw(data, x), nil
+	case string:
+		return appendString(data, x), nil
+	case []byte:
+		return appendBytes(data, x), nil
+	case bool:
+		return appendBool(data, x), nil
+	case int:
+		return appendInt(data, int64(x)), nil
+	case int32:
+		return appendInt(data, int64(x)), nil
+	case int64:
+		return appendInt(data, x), nil
+	case uint64:
+		return appendUint(data, x), nil
+	case float64:
+		return appendFloat64(data, x), nil
+	}
+	return appendValue(data, reflect.ValueOf(v))
+}
+
+func appendNil(data []byte) []byte {
+	return append(data, 0xc0)
+}
+
+func appendRaw(data []byte, raw RawMessage) []byte {
+	if len(raw) == 0 {
+		return appendNil(data)
+	}
+	return append(data, raw...)
+}
+
+func appendBool(data []byte, b bool) []byte {
+	if b {
+		return append(data, 0xc3)
+	}
+	return append(data, 0xc2)
+}
+
+func appendInt(data []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(data, uint64(i))
+	case i >= -32:
+		return append(data, byte(i))
+	case i >= math.MinInt8:
+		return append(data, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return appendBigEndian(append(data, 0xd1), uint64(i), 2)
+	case i >= math.MinInt32:
+		return appendBigEndian(append(data, 0xd2), uint64(i), 4)
+	}
+	return appendBigEndian(append(data, 0xd3), uint64(i), 8)
+}
+
+func appendUint(data []byte, u uint64) []byte {
+	switch {
+	case u <= math.MaxInt8:
+		return append(data, byte(u))
+	case u <= math.MaxUint8:
+		return append(data, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendBigEndian(append(data, 0xcd), u, 2)
+	case u <= math.MaxUint32:
+		return appendBigEndian(append(data, 0xce), u, 4)
+	}
+	return appendBigEndian(append(data, 0xcf), u, 8)
+}
+
+func appendFloat32(data []byte, f float32) []byte {
+	return appendBigEndian(append(data, 0xca), uint64(math.Float32bits(f)), 4)
+}
+
+func appendFloat64(data []byte, f float64) []byte {
+	return appendBigEndian(append(data, 0xcb), math.Float64bits(f), 8)
+}
+
+func appendString(data []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		data = append(data, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		data = append(data, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		data = appendBigEndian(append(data, 0xda), uint64(n), 2)
+	default:
+		data = appendBigEndian(append(data, 0xdb), uint64(n), 4)
+	}
+	return append(data, s...)
+}
+
+func appendBytes(data []byte, b []byte) []byte {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		data = append(data, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		data = appendBigEndian(append(data, 0xc5), uint64(n), 2)
+	default:
+		data = appendBigEndian(append(data, 0xc6), uint64(n), 4)
+	}
+	return append(data, b...)
+}
+
+func appendArrayHeader(data []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(data, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(data, 0xdc), uint64(n), 2)
+	}
+	return appendBigEndian(append(data, 0xdd), uint64(n), 4)
+}
+
+func appendMapHeader(data []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(data, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendBigEndian(append(data, 0xde), uint64(n), 2)
+	}
+	return appendBigEndian(append(data, 0xdf), uint64(n), 4)
+}
+
+func appendBigEndian(data []byte, u uint64, size int) []byte {
+	for shift := 8 * (size - 1); shift >= 0; shift -= 8 {
+		data = append(data, byte(u>>uint(shift)))
+	}
+	return data
+}
+
+func appendValue(data []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return appendNil(data), nil
+	}
+	if v.Type() == rawMessageType {
+		return appendRaw(data, v.Bytes()), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return appendBool(data, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(data, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(data, v.Uint()), nil
+	case reflect.Float32:
+		return appendFloat32(data, float32(v.Float())), nil
+	case reflect.Float64:
+		return appendFloat64(data, v.Float()), nil
+	case reflect.String:
+		return appendString(data, v.String()), nil
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return appendNil(data), nil
+		}
+		return appendValue(data, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return appendNil(data), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBytes(data, v.Bytes()), nil
+		}
+		return appendArray(data, v)
+	case reflect.Array:
+		return appendArray(data, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return appendNil(data), nil
+		}
+		return appendMap(data, v)
+	case reflect.Struct:
+		return appendStruct(data, v)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type: %s", v.Type())
+}
+
+func appendArray(data []byte, v reflect.Value) (_ []byte, err error) {
+	data = appendArrayHeader(data, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		data, err = appendValue(data, v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// appendMap encodes a map, of which string keys are sorted to produce
+// the same encoding for equal maps
+func appendMap(data []byte, v reflect.Value) (_ []byte, err error) {
+	keys := v.MapKeys()
+	if v.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+	data = appendMapHeader(data, len(keys))
+	for _, key := range keys {
+		data, err = appendValue(data, key)
+		if err != nil {
+			return nil, err
+		}
+		data, err = appendValue(data, v.MapIndex(key))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func appendStruct(data []byte, v reflect.Value) (_ []byte, err error) {
+	fields := cachedFields(v.Type())
+	values := make([]reflect.Value, len(fields.list))
+	n := 0
+	for i, field := range fields.list {
+		value, ok := fieldByIndex(v, field.index, false)
+		if !ok || (field.omitEmpty && isEmpty(value)) {
+			continue
+		}
+		values[i] = value
+		n++
+	}
+	data = appendMapHeader(data, n)
+	for i, field := range fields.list {
+		if !values[i].IsValid() {
+			continue
+		}
+		data = appendString(data, field.name)
+		data, err = appendValue(data, values[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// beginMap appends the header of a map of which the number of entries
+// is not yet known, which is set by endMap
+func beginMap(data []byte) (_ []byte, start int) {
+	return append(data, 0xdf, 0, 0, 0, 0), len(data)
+}
+
+// endMap sets the number of entries of a map started by beginMap,
+// using the shortest header possible
+func endMap(data []byte, start, n int) []byte {
+	header := appendMapHeader(make([]byte, 0, 5), n)
+	copy(data[start:], header)
+	if len(header) < 5 {
+		copy(data[start+len(header):], data[start+5:])
+		data = data[:len(data)-5+len(header)]
+	}
+	return data
+}
+
+// field is an exported field of a struct, which may be promoted from
+// an embedded struct
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+type structFields struct {
+	list   []field
+	byName map[string]int
+}
+
+// lookup returns the field with the given key, preferring an exact
+// match over a case insensitive match like encoding/json
+func (this *structFields) lookup(key string) *field {
+	if i, ok := this.byName[key]; ok {
+		return &this.list[i]
+	}
+	for i := range this.list {
+		if strings.EqualFold(this.list[i].name, key) {
+			return &this.list[i]
+		}
+	}
+	return nil
+}
+
+var fieldCache sync.Map
+
+func cachedFields(t reflect.Type) *structFields {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(*structFields)
+	}
+	fields, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return fields.(*structFields)
+}
+
+// typeFields returns the fields of a struct type. The fields of embedded
+// structs are promoted, unless a field with the same name is found at a
+// shallower depth.
+func typeFields(t reflect.Type) *structFields {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	fields := &structFields{
+		byName: make(map[string]int),
+	}
+	visited := make(map[reflect.Type]bool)
+	level := []embedded{{t, nil}}
+	for len(level) > 0 {
+		var next []embedded
+		for _, e := range level {
+			if visited[e.t] {
+				continue
+			}
+			visited[e.t] = true
+			for i := 0; i < e.t.NumField(); i++ {
+				f := e.t.Field(i)
+				index := append(append([]int(nil), e.index...), i)
+				tag := f.Tag.Get("msgpack")
+				if len(tag) == 0 {
+					tag = f.Tag.Get("json")
+				}
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				if f.Anonymous && len(name) == 0 {
+					ft := f.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						next = append(next, embedded{ft, index})
+						continue
+					}
+				}
+				if len(f.PkgPath) > 0 {
+					continue
+				}
+				if len(name) == 0 {
+					name = f.Name
+				}
+				if _, ok := fields.byName[name]; ok {
+					continue
+				}
+				fields.byName[name] = len(fields.list)
+				fields.list = append(fields.list, field{
+					name:      name,
+					index:     index,
+					omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+				})
+			}
+		}
+		level = next
+	}
+	return fields
+}
+
+// fieldByIndex returns a possibly promoted field of a struct. Nil
+// embedded pointers are allocated if alloc is set and the field is
+// reported as missing otherwise.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// Unmarshal decodes the msgpack value in data into v, which has to be a
+// non-nil pointer. Maps are decoded into structs by matching their keys
+// with the keys of the fields as encoded by Marshal. Values decoded into
+// an empty interface are nil, bool, int64, uint64 (only if the value
+// does not fit into an int64), float64, string, []byte, []interface{} or
+// map[string]interface{} (map[interface{}]interface{} if a key is not a
+// string).
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Unmarshal requires a non-nil pointer, received %T", v)
+	}
+	d := &decoder{data: data}
+	err := d.value(rv.Elem())
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return fmt.Errorf("msgpack: %d bytes after the end of the value", len(data)-d.pos)
+	}
+	return nil
+}
+
+var errShortData = errors.New("msgpack: unexpected end of data")
+
+type kind int
+
+const (
+	kindNil kind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindStr
+	kindBin
+	kindArray
+	kindMap
+	kindExt
+)
+
+var kindNames = [...]string{"nil", "bool", "int", "uint", "float", "string", "bin", "array", "map", "ext"}
+
+func (this kind) String() string {
+	return kindNames[this]
+}
+
+// header describes the next value to be decoded. The contents of str,
+// bin and ext values, and the elements of arrays and maps, follow the
+// header and contain n bytes or elements.
+type header struct {
+	kind kind
+	n    int
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (this *decoder) readByte() (byte, error) {
+	if this.pos >= len(this.data) {
+		return 0, errShortData
+	}
+	b := this.data[this.pos]
+	this.pos++
+	return b, nil
+}
+
+func (this *decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || n > len(this.data)-this.pos {
+		return nil, errShortData
+	}
+	b := this.data[this.pos : this.pos+n]
+	this.pos += n
+	return b, nil
+}
+
+func (this *decoder) readUint(size int) (uint64, error) {
+	b, err := this.readBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, x := range b {
+		u = u<<8 | uint64(x)
+	}
+	return u, nil
+}
+
+func (this *decoder) readLen(size int) (int, error) {
+	u, err := this.readUint(size)
+	return int(u), err
+}
+
+func (this *decoder) peekNil() bool {
+	if this.pos < len(this.data) && this.data[this.pos] == 0xc0 {
+		this.pos++
+		return true
+	}
+	return false
+}
+
+func (this *decoder) header() (h header, err error) {
+	b, err := this.readByte()
+	if err != nil {
+		return h, err
+	}
+	switch {
+	case b <= 0x7f:
+		return header{kind: kindUint, u: uint64(b)}, nil
+	case b >= 0xe0:
+		return header{kind: kindInt, i: int64(int8(b))}, nil
+	case b <= 0x8f:
+		return header{kind: kindMap, n: int(b & 0x0f)}, nil
+	case b <= 0x9f:
+		return header{kind: kindArray, n: int(b & 0x0f)}, nil
+	case b <= 0xbf:
+		return header{kind: kindStr, n: int(b & 0x1f)}, nil
+	}
+
+	h = header{kind: kindInt}
+	switch b {
+	case 0xc0:
+		h.kind = kindNil
+	case 0xc2, 0xc3:
+		h.kind, h.b = kindBool, b == 0xc3
+	case 0xc4, 0xc5, 0xc6:
+		h.kind = kindBin
+		h.n, err = this.readLen(1 << (b - 0xc4))
+	case 0xc7, 0xc8, 0xc9:
+		h.kind = kindExt
+		if h.n, err = this.readLen(1 << (b - 0xc7)); err == nil {
+			_, err = this.readByte()
+		}
+	case 0xca:
+		var u uint64
+		u, err = this.readUint(4)
+		h.kind, h.f = kindFloat, float64(math.Float32frombits(uint32(u)))
+	case 0xcb:
+		var u uint64
+		u, err = this.readUint(8)
+		h.kind, h.f = kindFloat, math.Float64frombits(u)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		h.kind = kindUint
+		h.u, err = this.readUint(1 << (b - 0xcc))
+	case 0xd0:
+		var u uint64
+		u, err = this.readUint(1)
+		h.i = int64(int8(u))
+	case 0xd1:
+		var u uint64
+		u, err = this.readUint(2)
+		h.i = int64(int16(u))
+	case 0xd2:
+		var u uint64
+		u, err = this.readUint(4)
+		h.i = int64(int32(u))
+	case 0xd3:
+		var u uint64
+		u, err = this.readUint(8)
+		h.i = int64(u)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		h.kind, h.n = kindExt, 1<<(b-0xd4)
+		_, err = this.readByte()
+	case 0xd9, 0xda, 0xdb:
+		h.kind = kindStr
+		h.n, err = this.readLen(1 << (b - 0xd9))
+	case 0xdc, 0xdd:
+		h.kind = kindArray
+		h.n, err = this.readLen(2 << (b - 0xdc))
+	case 0xde, 0xdf:
+		h.kind = kindMap
+		h.n, err = this.readLen(2 << (b - 0xde))
+	default:
+		return h, fmt.Errorf("msgpack: invalid type byte 0x%x", b)
+	}
+	return h, err
+}
+
+// checkLen ensures that the data contains enough bytes for the given
+// number of elements, before any memory is allocated for them
+func (this *decoder) checkLen(n int) error {
+	if n > len(this.data)-this.pos {
+		return errShortData
+	}
+	return nil
+}
+
+// skip skips the next value
+func (this *decoder) skip() error {
+	h, err := this.header()
+	if err != nil {
+		return err
+	}
+	switch h.kind {
+	case kindStr, kindBin, kindExt:
+		_, err = this.readBytes(h.n)
+		return err
+	case kindArray:
+		return this.skipN(h.n)
+	case kindMap:
+		return this.skipN(2 * h.n)
+	}
+	return nil
+}
+
+func (this *decoder) skipN(n int) error {
+	if err := this.checkLen(n); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := this.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// raw returns the encoding of the next value
+func (this *decoder) raw() ([]byte, error) {
+	start := this.pos
+	if err := this.skip(); err != nil {
+		return nil, err
+	}
+	return this.data[start:this.pos], nil
+}
+
+// arrayLen reads the header of an array
+func (this *decoder) arrayLen() (int, error) {
+	h, err := this.header()
+	if err != nil {
+		return 0, err
+	}
+	if h.kind != kindArray {
+		return 0, fmt.Errorf("msgpack: expected array, received %s", h.kind)
+	}
+	return h.n, this.checkLen(h.n)
+}
+
+// mapLen reads the header of a map
+func (this *decoder) mapLen() (int, error) {
+	h, err := this.header()
+	if err != nil {
+		return 0, err
+	}
+	if h.kind != kindMap {
+		return 0, fmt.Errorf("msgpack: expected map, received %s", h.kind)
+	}
+	return h.n, this.checkLen(2 * h.n)
+}
+
+// str reads a string, which may also be encoded as bin
+func (this *decoder) str() (string, error) {
+	b, err := this.bin()
+	return string(b), err
+}
+
+// bin reads a bin value, which may also be encoded as a string, without
+// copying it
+func (this *decoder) bin() ([]byte, error) {
+	h, err := this.header()
+	if err != nil {
+		return nil, err
+	}
+	if h.kind != kindStr && h.kind != kindBin {
+		return nil, fmt.Errorf("msgpack: expected string or bin, received %s", h.kind)
+	}
+	return this.readBytes(h.n)
+}
+
+// decode decodes the next value into the value pointed to by v
+func (this *decoder) decode(v interface{}) error {
+	return this.value(reflect.ValueOf(v).Elem())
+}
+
+func typeError(h header, t reflect.Type) error {
+	return fmt.Errorf("msgpack: cannot decode %s into Go value of type %s", h.kind, t)
+}
+
+func (this *decoder) value(v reflect.Value) error {
+	if v.Type() == rawMessageType {
+		raw, err := this.raw()
+		if err != nil {
+			return err
+		}
+		v.SetBytes(append(RawMessage(nil), raw...))
+		return nil
+	}
+	if this.peekNil() {
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return this.value(v.Elem())
+	case reflect.Interface:
+		if !v.IsNil() && v.Elem().Kind() == reflect.Ptr && !v.Elem().IsNil() {
+			return this.value(v.Elem())
+		}
+		if v.NumMethod() > 0 {
+			return fmt.Errorf("msgpack: cannot decode into Go value of type %s", v.Type())
+		}
+		x, err := this.any()
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	h, err := this.header()
+	if err != nil {
+		return err
+	}
+	switch h.kind {
+	case kindBool:
+		if v.Kind() != reflect.Bool {
+			return typeError(h, v.Type())
+		}
+		v.SetBool(h.b)
+	case kindInt, kindUint:
+		return setInt(h, v)
+	case kindFloat:
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return typeError(h, v.Type())
+		}
+		v.SetFloat(h.f)
+	case kindStr, kindBin:
+		b, err := this.readBytes(h.n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(b))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), b...))
+		default:
+			return typeError(h, v.Type())
+		}
+	case kindArray:
+		return this.array(h, v)
+	case kindMap:
+		return this.mapValue(h, v)
+	default:
+		return typeError(h, v.Type())
+	}
+	return nil
+}
+
+func setInt(h header, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := h.i
+		if h.kind == kindUint {
+			if h.u > math.MaxInt64 {
+				return fmt.Errorf("msgpack: %d overflows Go value of type %s", h.u, v.Type())
+			}
+			i = int64(h.u)
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("msgpack: %d overflows Go value of type %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := h.u
+		if h.kind == kindInt {
+			if h.i < 0 {
+				return fmt.Errorf("msgpack: %d overflows Go value of type %s", h.i, v.Type())
+			}
+			u = uint64(h.i)
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: %d overflows Go value of type %s", u, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		if h.kind == kindUint {
+			v.SetFloat(float64(h.u))
+		} else {
+			v.SetFloat(float64(h.i))
+		}
+	default:
+		return typeError(h, v.Type())
+	}
+	return nil
+}
+
+func (this *decoder) array(h header, v reflect.Value) error {
+	if err := this.checkLen(h.n); err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), h.n, h.n))
+		for i := 0; i < h.n; i++ {
+			if err := this.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < h.n; i++ {
+			var err error
+			if i < v.Len() {
+				err = this.value(v.Index(i))
+			} else {
+				err = this.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for i := h.n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	default:
+		return typeError(h, v.Type())
+	}
+	return nil
+}
+
+func (this *decoder) mapValue(h header, v reflect.Value) error {
+	if err := this.checkLen(2 * h.n); err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		t := v.Type()
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, h.n))
+		}
+		for i := 0; i < h.n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := this.value(key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := this.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		for i := 0; i < h.n; i++ {
+			key, err := this.str()
+			if err != nil {
+				return err
+			}
+			field := fields.lookup(key)
+			if field == nil {
+				if err := this.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			fv, ok := fieldByIndex(v, field.index, true)
+			if !ok {
+				return fmt.Errorf("msgpack: cannot set embedded pointer to unexported struct of %s", v.Type())
+			}
+			if err := this.value(fv); err != nil {
+				return err
+			}
+		}
+	default:
+		return typeError(h, v.Type())
+	}
+	return nil
+}
+
+// any decodes the next value into its natural Go type
+func (this *decoder) any() (interface{}, error) {
+	h, err := this.header()
+	if err != nil {
+		return nil, err
+	}
+	switch h.kind {
+	case kindNil:
+		return nil, nil
+	case kindBool:
+		return h.b, nil
+	case kindInt:
+		return h.i, nil
+	case kindUint:
+		if h.u <= math.MaxInt64 {
+			return int64(h.u), nil
+		}
+		return h.u, nil
+	case kindFloat:
+		return h.f, nil
+	case kindStr:
+		b, err := this.readBytes(h.n)
+		return string(b), err
+	case kindBin:
+		b, err := this.readBytes(h.n)
+		return append([]byte(nil), b...), err
+	case kindArray:
+		if err := this.checkLen(h.n); err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, h.n)
+		for i := range array {
+			if array[i], err = this.any(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case kindMap:
+		return this.anyMap(h.n)
+	}
+	return nil, fmt.Errorf("msgpack: cannot decode %s into Go value of type interface {}", h.kind)
+}
+
+func (this *decoder) anyMap(n int) (interface{}, error) {
+	if err := this.checkLen(2 * n); err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	strKeys := true
+	for i := 0; i < n; i++ {
+		key, err := this.any()
+		if err != nil {
+			return nil, err
+		}
+		switch key.(type) {
+		case string:
+		case []interface{}, map[string]interface{}, map[interface{}]interface{}, []byte:
+			return nil, fmt.Errorf("msgpack: unsupported map key of type %T", key)
+		default:
+			strKeys = false
+		}
+		keys[i] = key
+		if values[i], err = this.any(); err != nil {
+			return nil, err
+		}
+	}
+	if strKeys {
+		m := make(map[string]interface{}, n)
+		for i, key := range keys {
+			m[key.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, key := range keys {
+		m[key] = values[i]
+	}
+	return m, nil
+}
@@ -0,0 +1,195 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkErr(err error, t *testing.T) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMarshalFormat(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		encoded string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{false, "c2"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{int64(math.MinInt64), "d38000000000000000"},
+		{uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{1.5, "cb3ff8000000000000"},
+		{float32(1.5), "ca3fc00000"},
+		{"a", "a161"},
+		{strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{[]byte{1, 2}, "c4020102"},
+		{[]int{1, 2}, "920102"},
+		{map[string]int{"b": 2, "a": 1}, "82a16101a16202"},
+		{RawMessage{0x01}, "01"},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.value)
+		checkErr(err, t)
+		if encoded := hex.EncodeToString(data); encoded != test.encoded {
+			t.Fatalf("Expected %v to be encoded as %s, received: %s", test.value, test.encoded, encoded)
+		}
+	}
+}
+
+type Inner struct {
+	Name string
+}
+
+type testStruct struct {
+	*Inner
+	Number  int64             `msgpack:"number"`
+	Text    string            `json:"text,omitempty"`
+	Skipped string            `json:"-"`
+	Data    []byte            `msgpack:"data"`
+	Values  []float64         `msgpack:"values"`
+	Fields  map[string]string `msgpack:"fields"`
+	Next    *testStruct       `msgpack:"next,omitempty"`
+	private int
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := []interface{}{
+		true,
+		int8(-100),
+		int16(-30000),
+		int32(-2000000000),
+		uint16(65535),
+		uint32(4000000000),
+		float32(0.25),
+		math.Pi,
+		strings.Repeat("x", 300),
+		strings.Repeat("y", 70000),
+		bytes.Repeat([]byte{7}, 300),
+		make([]string, 20),
+		[3]int{1, 2, 3},
+		map[int]string{1: "one", -2: "minus two"},
+		&testStruct{
+			Inner:  &Inner{Name: "outer"},
+			Number: -5,
+			Data:   []byte("data"),
+			Values: []float64{1.5, -2.5},
+			Fields: map[string]string{"key": "value"},
+			Next:   &testStruct{Text: "next"},
+		},
+	}
+	for _, value := range values {
+		data, err := Marshal(value)
+		checkErr(err, t)
+		decoded := reflect.New(reflect.TypeOf(value))
+		checkErr(Unmarshal(data, decoded.Interface()), t)
+		if !reflect.DeepEqual(decoded.Elem().Interface(), value) {
+			t.Fatalf("Expected %v, decoded: %v", value, decoded.Elem().Interface())
+		}
+	}
+}
+
+func TestStructKeys(t *testing.T) {
+	data, err := Marshal(&testStruct{Inner: &Inner{Name: "name"}, Skipped: "skipped", private: 1})
+	checkErr(err, t)
+	var decoded map[string]interface{}
+	checkErr(Unmarshal(data, &decoded), t)
+	expected := map[string]interface{}{
+		"Name":   "name",
+		"number": int64(0),
+		"data":   nil,
+		"values": nil,
+		"fields": nil,
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("Expected %v, decoded: %v", expected, decoded)
+	}
+
+	// Keys are matched case insensitively if there is no exact match
+	data, err = Marshal(map[string]interface{}{"NUMBER": 3, "name": "name", "unknown": []int{1}})
+	checkErr(err, t)
+	var decodedStruct testStruct
+	checkErr(Unmarshal(data, &decodedStruct), t)
+	if decodedStruct.Number != 3 || decodedStruct.Inner == nil || decodedStruct.Name != "name" {
+		t.Fatalf("Unexpected decoded struct: %+v", decodedStruct)
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	data, err := Marshal([]interface{}{1, -1, uint64(math.MaxUint64), 1.5, "s", []byte("b"), nil, map[int]int{1: 2}})
+	checkErr(err, t)
+	var decoded interface{}
+	checkErr(Unmarshal(data, &decoded), t)
+	expected := []interface{}{int64(1), int64(-1), uint64(math.MaxUint64), 1.5, "s", []byte("b"), nil, map[interface{}]interface{}{int64(1): int64(2)}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("Expected %v, decoded: %v", expected, decoded)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, err := Marshal(300)
+	checkErr(err, t)
+	var small int8
+	if err := Unmarshal(data, &small); err == nil {
+		t.Fatal("Expected an overflow error")
+	}
+	var text string
+	if err := Unmarshal(data, &text); err == nil {
+		t.Fatal("Expected a type error")
+	}
+	if err := Unmarshal(data[:1], &small); err == nil {
+		t.Fatal("Expected an error for truncated data")
+	}
+	// An array claiming more elements than there are bytes is rejected
+	// before it is allocated
+	var values []int
+	if err := Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &values); err != errShortData {
+		t.Fatalf("Expected %v, received: %v", errShortData, err)
+	}
+	if err := Unmarshal(data, small); err == nil {
+		t.Fatal("Expected an error for a non-pointer")
+	}
+}
+
+func TestRawMessage(t *testing.T) {
+	data, err := Marshal([]interface{}{"a", map[string]int{"b": 1}})
+	checkErr(err, t)
+	var raw []RawMessage
+	checkErr(Unmarshal(data, &raw), t)
+	var b map[string]int
+	checkErr(Unmarshal(raw[1], &b), t)
+	if b["b"] != 1 {
+		t.Fatalf("Unexpected decoded raw message: %v", b)
+	}
+	encoded, err := Marshal(raw)
+	checkErr(err, t)
+	if !bytes.Equal(encoded, data) {
+		t.Fatalf("Expected raw messages to be encoded as is: %x instead of %x", encoded, data)
+	}
+}
@@ -0,0 +1,810 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+	"io"
+)
+
+// The msgpack encoding sends the same messages as the json multilang
+// protocol, encoded as msgpack maps without any delimiters. Task ids are
+// sent as a msgpack array. The fields of tuples are encoded natively, so
+// that they can be decoded into Go structs and by other msgpack
+// multilang components.
+
+func NewMsgpackInputFactory() core.InputFactory {
+	return &msgpackInputFactory{}
+}
+
+type msgpackInputFactory struct{}
+
+func (this *msgpackInputFactory) NewInput(reader io.Reader) core.Input {
+	return NewMsgpackInput(reader)
+}
+
+func NewMsgpackInput(reader io.Reader) core.Input {
+	return &msgpackInput{
+		reader:      bufio.NewReader(reader),
+		tupleBuffer: list.New(),
+	}
+}
+
+type msgpackInput struct {
+	reader      *bufio.Reader
+	tupleBuffer *list.List
+}
+
+// readData reads a single msgpack value from Storm
+func (this *msgpackInput) readData() (data []byte, err error) {
+	data, err = readValue(this.reader, nil)
+	if err == io.ErrUnexpectedEOF {
+		return nil, core.NewError("msgpack", "message", err)
+	}
+	return data, err
+}
+
+// readValue appends the next msgpack value read from reader to data.
+// io.EOF is only returned if the reader ends before the value starts.
+func readValue(reader *bufio.Reader, data []byte) ([]byte, error) {
+	for pending := 1; pending > 0; pending-- {
+		b, err := reader.ReadByte()
+		if err == io.EOF && len(data) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		data = append(data, b)
+
+		var size, n int
+		switch {
+		case b <= 0x7f, b >= 0xe0, b == 0xc0, b == 0xc2, b == 0xc3:
+		case b <= 0x8f:
+			pending += 2 * int(b&0x0f)
+		case b <= 0x9f:
+			pending += int(b & 0x0f)
+		case b <= 0xbf:
+			n = int(b & 0x1f)
+		case b == 0xc1:
+			return nil, fmt.Errorf("msgpack: invalid type byte 0x%x", b)
+		case b <= 0xc6:
+			size = 1 << (b - 0xc4)
+		case b <= 0xc9:
+			// The length of ext values is followed by their type
+			size, n = 1<<(b-0xc7), 1
+		case b <= 0xcb:
+			n = 4 << (b - 0xca)
+		case b <= 0xcf:
+			n = 1 << (b - 0xcc)
+		case b <= 0xd3:
+			n = 1 << (b - 0xd0)
+		case b <= 0xd8:
+			n = 1 + 1<<(b-0xd4)
+		case b <= 0xdb:
+			size = 1 << (b - 0xd9)
+		default:
+			// Arrays and maps
+			data, err = readN(reader, data, 2<<((b-0xdc)%2))
+			if err != nil {
+				return nil, err
+			}
+			elems := lastUint(data, 2<<((b-0xdc)%2))
+			if b >= 0xde {
+				elems *= 2
+			}
+			pending += elems
+			continue
+		}
+
+		if size > 0 {
+			data, err = readN(reader, data, size)
+			if err != nil {
+				return nil, err
+			}
+			n += lastUint(data, size)
+		}
+		data, err = readN(reader, data, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// readN appends n bytes read from reader to data, without allocating
+// more than what has been read for large lengths
+func readN(reader *bufio.Reader, data []byte, n int) ([]byte, error) {
+	const chunk = 64 << 10
+	for n > 0 {
+		m := n
+		if m > chunk {
+			m = chunk
+		}
+		start := len(data)
+		data = append(data, make([]byte, m)...)
+		if _, err := io.ReadFull(reader, data[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		n -= m
+	}
+	return data, nil
+}
+
+func lastUint(data []byte, size int) int {
+	var u int
+	for _, b := range data[len(data)-size:] {
+		u = u<<8 | int(b)
+	}
+	return u
+}
+
+// Buffered returns the number of tuples and bytes that have been read
+// ahead, which can be read without blocking
+func (this *msgpackInput) Buffered() int {
+	return this.tupleBuffer.Len() + this.reader.Buffered()
+}
+
+func (this *msgpackInput) next() (data []byte, err error) {
+	if this.tupleBuffer.Len() > 0 {
+		e := this.tupleBuffer.Front()
+		return this.tupleBuffer.Remove(e).([]byte), nil
+	}
+	return this.readData()
+}
+
+// ReadMsg reads a message from Storm into the message provided. Storm
+// messages are decoded from the json multilang format and other
+// messages are decoded using Unmarshal.
+func (this *msgpackInput) ReadMsg(msg interface{}) (err error) {
+	data, err := this.next()
+	if err != nil {
+		return err
+	}
+	d := &decoder{data: data}
+	switch msg := msg.(type) {
+	case *messages.Context:
+		err = decodeContext(d, msg)
+	case *messages.Pid:
+		err = decodeMap(d, func(key string) error {
+			if key == "pid" {
+				return d.decode(&msg.Pid)
+			}
+			return d.skip()
+		})
+	case *messages.SpoutMsg:
+		err = decodeMap(d, func(key string) error {
+			switch key {
+			case "command":
+				return d.decode(&msg.Command)
+			case "id":
+				return d.decode(&msg.Id)
+			}
+			return d.skip()
+		})
+	case *messages.TaskIds:
+		err = d.decode(&msg.TaskIds)
+	case *messages.BoltMsg:
+		if msg.BoltMsgJson == nil {
+			msg.BoltMsgJson = &messages.BoltMsgJson{}
+		}
+		err = decodeBoltMsg(d, msg.BoltMsgJson)
+	case *messages.ShellMsg:
+		if msg.ShellMsgJson == nil {
+			msg.ShellMsgJson = &messages.ShellMsgJson{}
+		}
+		err = decodeShellMsg(d, msg.ShellMsgJson)
+	default:
+		err = Unmarshal(data, msg)
+	}
+	if err != nil {
+		return core.NewError("msgpack", core.MsgName(msg), err)
+	}
+	return nil
+}
+
+// decodeMap calls decodeValue with the key of every entry of a map,
+// which has to decode or skip the value of the entry
+func decodeMap(d *decoder, decodeValue func(key string) error) error {
+	n, err := d.mapLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		key, err := d.str()
+		if err != nil {
+			return err
+		}
+		if err := decodeValue(key); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// decodeContext decodes the context sent by Storm, of which the
+// configuration values are formatted in the same way as by the json
+// encodings
+func decodeContext(d *decoder, context *messages.Context) error {
+	context.Topology = &messages.Topology{}
+	return decodeMap(d, func(key string) error {
+		switch key {
+		case "pidDir":
+			return d.decode(&context.PidDir)
+		case "conf":
+			var conf map[string]interface{}
+			if err := d.decode(&conf); err != nil {
+				return err
+			}
+			for key, value := range conf {
+				context.Confs = append(context.Confs, &messages.Conf{
+					Key:   key,
+					Value: fmt.Sprintf("%v", value),
+				})
+			}
+			return nil
+		case "context":
+			return decodeMap(d, func(key string) error {
+				switch key {
+				case "taskid":
+					return d.decode(&context.Topology.TaskId)
+				case "task->component":
+					var mappings map[string]interface{}
+					if err := d.decode(&mappings); err != nil {
+						return err
+					}
+					for task, component := range mappings {
+						context.Topology.TaskComponentMappings = append(context.Topology.TaskComponentMappings, &messages.TaskComponentMapping{
+							Task:      task,
+							Component: fmt.Sprintf("%v", component),
+						})
+					}
+					return nil
+				}
+				return d.skip()
+			})
+		}
+		return d.skip()
+	})
+}
+
+// decodeContents decodes a tuple into contents, of which the pointers
+// already present are decoded into, like encoding/json does
+func decodeContents(d *decoder, contents *[]interface{}) error {
+	n, err := d.arrayLen()
+	if err != nil {
+		return err
+	}
+	decoded := make([]interface{}, n)
+	copy(decoded, *contents)
+	for i := range decoded {
+		if err := d.decode(&decoded[i]); err != nil {
+			return err
+		}
+	}
+	*contents = decoded
+	return nil
+}
+
+func decodeBoltMeta(d *decoder, meta *messages.BoltMsgMeta, key string) error {
+	switch key {
+	case "id":
+		return d.decode(&meta.Id)
+	case "comp":
+		return d.decode(&meta.Comp)
+	case "stream":
+		return d.decode(&meta.Stream)
+	case "task":
+		return d.decode(&meta.Task)
+	}
+	return d.skip()
+}
+
+func decodeBoltMsg(d *decoder, boltMsg *messages.BoltMsgJson) error {
+	if boltMsg.BoltMsgMeta == nil {
+		boltMsg.BoltMsgMeta = &messages.BoltMsgMeta{}
+	}
+	return decodeMap(d, func(key string) error {
+		if key == "tuple" {
+			return decodeContents(d, &boltMsg.Contents)
+		}
+		return decodeBoltMeta(d, boltMsg.BoltMsgMeta, key)
+	})
+}
+
+func decodeShellMsg(d *decoder, shellMsg *messages.ShellMsgJson) error {
+	if shellMsg.ShellMsgMeta == nil {
+		shellMsg.ShellMsgMeta = &messages.ShellMsgMeta{}
+	}
+	meta := shellMsg.ShellMsgMeta
+	return decodeMap(d, func(key string) error {
+		switch key {
+		case "command":
+			return d.decode(&meta.Command)
+		case "id":
+			return d.decode(&meta.Id)
+		case "anchors":
+			return d.decode(&meta.Anchors)
+		case "stream":
+			return d.decode(&meta.Stream)
+		case "task":
+			return d.decode(&meta.Task)
+		case "need_task_ids":
+			return d.decode(&meta.NeedTaskIds)
+		case "msg":
+			return d.decode(&meta.Msg)
+		case "level":
+			return d.decode(&meta.Level)
+		case "name":
+			return d.decode(&meta.Name)
+		case "params":
+			params, err := d.any()
+			if err != nil {
+				return err
+			}
+			meta.Params, err = json.Marshal(params)
+			return err
+		case "tuple":
+			return decodeContents(d, &shellMsg.Contents)
+		}
+		return d.skip()
+	})
+}
+
+// isTaskIds returns whether a message received while waiting for task
+// ids is a list of task ids, instead of a tuple that has to be buffered
+func isTaskIds(data []byte) bool {
+	b := data[0]
+	return (b >= 0x90 && b <= 0x9f) || b == 0xdc || b == 0xdd
+}
+
+// ReadTaskIds reads the task ids of an emission. Tuples that Storm sends
+// before the task ids are buffered and returned by subsequent reads.
+func (this *msgpackInput) ReadTaskIds() (taskIds []int32, err error) {
+	for {
+		data, err := this.readData()
+		if err != nil {
+			return nil, err
+		}
+		if !isTaskIds(data) {
+			this.tupleBuffer.PushBack(data)
+			continue
+		}
+		err = Unmarshal(data, &taskIds)
+		if err != nil {
+			return nil, core.NewError("msgpack", "TaskIds", err)
+		}
+		return taskIds, nil
+	}
+}
+
+// ReadBoltMsg reads a tuple from Storm and decodes its fields into the
+// provided structs
+func (this *msgpackInput) ReadBoltMsg(metadata *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
+	return this.ReadBoltMsgFunc(metadata, core.StaticFields(contentStructs))
+}
+
+// ReadBoltMsgFunc reads a tuple from Storm and decodes its fields into
+// the structs returned for the metadata of the tuple
+func (this *msgpackInput) ReadBoltMsgFunc(metadata *messages.BoltMsgMeta, fields core.FieldsFunc) (err error) {
+	// Ensure that the metadata of a previous tuple is never reported
+	metadata.Reset()
+	raw, err := this.readBoltMsg(metadata, (*decoder).raw)
+	if err != nil {
+		return err
+	}
+	err = decodeRaw(raw, fields(metadata)...)
+	if err != nil {
+		err.(*core.Error).Id = metadata.Id
+		return err
+	}
+	return nil
+}
+
+// ReadRawBoltMsg reads a tuple from Storm and returns its fields, which
+// are payloads encoded by another codec and sent as msgpack bin values
+func (this *msgpackInput) ReadRawBoltMsg(metadata *messages.BoltMsgMeta) (raw [][]byte, err error) {
+	return this.readBoltMsg(metadata, (*decoder).bin)
+}
+
+// readBoltMsg reads a tuple from Storm and returns its fields as read
+// by readField
+func (this *msgpackInput) readBoltMsg(metadata *messages.BoltMsgMeta, readField func(d *decoder) ([]byte, error)) (raw [][]byte, err error) {
+	data, err := this.next()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: data}
+	err = decodeMap(d, func(key string) error {
+		if key != "tuple" {
+			return decodeBoltMeta(d, metadata, key)
+		}
+		n, err := d.arrayLen()
+		if err != nil {
+			return err
+		}
+		raw = make([][]byte, n)
+		for i := range raw {
+			if raw[i], err = readField(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		decodeErr := core.NewError("msgpack", "BoltMsg", err)
+		decodeErr.Id = metadata.Id
+		return nil, decodeErr
+	}
+	return raw, nil
+}
+
+// decodeRaw decodes every received field into the corresponding content
+// struct. Tuples such as heartbeats may contain fewer fields than
+// expected. Decoding is skipped if no content structs are provided.
+func decodeRaw(raw [][]byte, contentStructs ...interface{}) error {
+	if len(contentStructs) == 0 {
+		return nil
+	}
+	if len(raw) > len(contentStructs) {
+		decodeErr := core.NewFieldError("msgpack", "BoltMsg", len(contentStructs), fmt.Errorf("received %d fields, expected at most %d", len(raw), len(contentStructs)))
+		decodeErr.Raw = raw
+		return decodeErr
+	}
+	for i, data := range raw {
+		err := Unmarshal(data, contentStructs[i])
+		if err != nil {
+			decodeErr := core.NewFieldError("msgpack", "BoltMsg", i, err)
+			decodeErr.Raw = raw
+			return decodeErr
+		}
+	}
+	return nil
+}
+
+func NewMsgpackOutputFactory() core.OutputFactory {
+	return &msgpackOutputFactory{}
+}
+
+type msgpackOutputFactory struct{}
+
+func (this *msgpackOutputFactory) NewOutput(writer io.Writer) core.Output {
+	return NewMsgpackOutput(writer)
+}
+
+func NewMsgpackOutput(writer io.Writer) core.Output {
+	return &msgpackOutput{
+		writer: bufio.NewWriter(writer),
+	}
+}
+
+type msgpackOutput struct {
+	writer *bufio.Writer
+	// buffer is reused to encode every message
+	buffer []byte
+}
+
+func (this *msgpackOutput) write(data []byte) (err error) {
+	this.buffer = data[:0]
+	_, err = this.writer.Write(data)
+	return err
+}
+
+// SendMsg sends a message to Storm. Storm messages are encoded in the
+// json multilang format and other messages are encoded using Marshal.
+func (this *msgpackOutput) SendMsg(msg interface{}) (err error) {
+	data := this.buffer[:0]
+	switch msg := msg.(type) {
+	case *messages.Pid:
+		data = appendMapHeader(data, 1)
+		data = appendString(data, "pid")
+		data = appendInt(data, int64(msg.Pid))
+	case *messages.SpoutMsg:
+		data = appendSpoutMsg(data, msg)
+	case *messages.TaskIds:
+		data = appendArrayHeader(data, len(msg.TaskIds))
+		for _, taskId := range msg.TaskIds {
+			data = appendInt(data, int64(taskId))
+		}
+	case *messages.Context:
+		data = appendContext(data, msg)
+	case *messages.BoltMsg:
+		if msg.BoltMsgJson == nil || msg.BoltMsgJson.BoltMsgMeta == nil {
+			return core.NewError("msgpack", "BoltMsg", errMissingMeta)
+		}
+		data, err = appendBoltMsg(data, msg.BoltMsgJson)
+	case *messages.ShellMsg:
+		if msg.ShellMsgJson == nil || msg.ShellMsgJson.ShellMsgMeta == nil {
+			return core.NewError("msgpack", "ShellMsg", errMissingMeta)
+		}
+		data, err = appendShellMsg(data, msg.ShellMsgJson.ShellMsgMeta, msg.ShellMsgJson.Contents)
+	default:
+		data, err = Append(data, msg)
+	}
+	if err != nil {
+		return encodingError(core.MsgName(msg), err)
+	}
+	return this.write(data)
+}
+
+// errMissingMeta is returned for tuples and shell messages that are sent
+// without the metadata of their JSON form
+var errMissingMeta = errors.New("missing message metadata")
+
+// encodingError returns err as an encoding error, unless it already
+// reports a specific tuple field
+func encodingError(msg string, err error) error {
+	if _, ok := err.(*core.Error); ok {
+		return err
+	}
+	return core.NewError("msgpack", msg, err)
+}
+
+func appendSpoutMsg(data []byte, msg *messages.SpoutMsg) []byte {
+	if len(msg.Id) == 0 {
+		data = appendMapHeader(data, 1)
+	} else {
+		data = appendMapHeader(data, 2)
+		data = appendString(data, "id")
+		data = appendString(data, msg.Id)
+	}
+	data = appendString(data, "command")
+	return appendString(data, msg.Command)
+}
+
+func appendContext(data []byte, context *messages.Context) []byte {
+	data = appendMapHeader(data, 3)
+	data = appendString(data, "pidDir")
+	data = appendString(data, context.PidDir)
+	data = appendString(data, "conf")
+	data = appendMapHeader(data, len(context.Confs))
+	for _, conf := range context.Confs {
+		data = appendString(data, conf.Key)
+		data = appendString(data, conf.Value)
+	}
+	topology := context.GetTopology()
+	data = appendString(data, "context")
+	data = appendMapHeader(data, 2)
+	data = appendString(data, "taskid")
+	data = appendInt(data, topology.GetTaskId())
+	data = appendString(data, "task->component")
+	data = appendMapHeader(data, len(topology.GetTaskComponentMappings()))
+	for _, mapping := range topology.GetTaskComponentMappings() {
+		data = appendString(data, mapping.Task)
+		data = appendString(data, mapping.Component)
+	}
+	return data
+}
+
+func appendBoltMsg(data []byte, boltMsg *messages.BoltMsgJson) ([]byte, error) {
+	meta := boltMsg.BoltMsgMeta
+	data = appendMapHeader(data, 5)
+	data = appendString(data, "id")
+	data = appendString(data, meta.Id)
+	data = appendString(data, "comp")
+	data = appendString(data, meta.Comp)
+	data = appendString(data, "stream")
+	data = appendString(data, meta.Stream)
+	data = appendString(data, "task")
+	data = appendInt(data, meta.Task)
+	data = appendString(data, "tuple")
+	return appendContents(data, "BoltMsg", boltMsg.Contents)
+}
+
+func appendContents(data []byte, msg string, contents []interface{}) (_ []byte, err error) {
+	data = appendArrayHeader(data, len(contents))
+	for i, content := range contents {
+		if raw, ok := content.(core.RawField); ok {
+			content = RawMessage(raw)
+		}
+		data, err = Append(data, content)
+		if err != nil {
+			return nil, core.NewFieldError("msgpack", msg, i, err)
+		}
+	}
+	return data, nil
+}
+
+// appendShellMsg encodes a message to Storm with the same entries as the
+// json encodings
+func appendShellMsg(data []byte, meta *messages.ShellMsgMeta, contents []interface{}) (_ []byte, err error) {
+	data, start := beginMap(data)
+	n := 1
+	data = appendString(data, "command")
+	data = appendString(data, meta.Command)
+	if id := meta.GetId(); len(id) > 0 {
+		data = appendString(data, "id")
+		data = appendString(data, id)
+		n++
+	}
+	if anchors := meta.GetAnchors(); len(anchors) > 0 {
+		data = appendString(data, "anchors")
+		data = appendArrayHeader(data, len(anchors))
+		for _, anchor := range anchors {
+			data = appendString(data, anchor)
+		}
+		n++
+	}
+	if stream := meta.GetStream(); len(stream) > 0 {
+		data = appendString(data, "stream")
+		data = appendString(data, stream)
+		n++
+	}
+	if task := meta.GetTask(); task != 0 {
+		data = appendString(data, "task")
+		data = appendInt(data, task)
+		n++
+	}
+	if msg := meta.GetMsg(); len(msg) > 0 {
+		data = appendString(data, "msg")
+		data = appendString(data, msg)
+		n++
+	}
+	if meta.Level != nil {
+		data = appendString(data, "level")
+		data = appendInt(data, int64(*meta.Level))
+		n++
+	}
+	if name := meta.GetName(); len(name) > 0 {
+		data = appendString(data, "name")
+		data = appendString(data, name)
+		n++
+	}
+	if params := meta.GetParams(); len(params) > 0 {
+		data = appendString(data, "params")
+		data, err = appendJson(data, params)
+		if err != nil {
+			return nil, err
+		}
+		n++
+	}
+	if len(contents) > 0 {
+		data = appendString(data, "tuple")
+		data, err = appendContents(data, "ShellMsg", contents)
+		if err != nil {
+			return nil, err
+		}
+		n++
+	}
+	// Storm sends task ids unless need_task_ids is false
+	if meta.Command == "emit" && !meta.GetNeedTaskIds() {
+		data = appendString(data, "need_task_ids")
+		data = appendBool(data, false)
+		n++
+	}
+	return endMap(data, start, n), nil
+}
+
+// appendJson encodes a json value, such as the parameters of a metric,
+// as the equivalent msgpack value. Integers remain integers.
+func appendJson(data []byte, encoded []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return Append(data, jsonNumbers(value))
+}
+
+// jsonNumbers replaces the json numbers in a decoded json value with
+// integers or floats
+func jsonNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case []interface{}:
+		for i := range value {
+			value[i] = jsonNumbers(value[i])
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = jsonNumbers(value[key])
+		}
+	}
+	return value
+}
+
+func (this *msgpackOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	meta := &messages.ShellMsgMeta{
+		Command:     command,
+		Anchors:     anchors,
+		Id:          &id,
+		Stream:      &stream,
+		Task:        &directTask,
+		NeedTaskIds: &needTaskIds,
+		Msg:         &msg,
+	}
+	return this.EmitShellMsg(meta, contents...)
+}
+
+func (this *msgpackOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	data, err := appendShellMsg(this.buffer[:0], meta, contents)
+	if err != nil {
+		return encodingError("ShellMsg", err)
+	}
+	return this.write(data)
+}
+
+// EmitRaw sends a message with fields that have already been encoded by
+// another codec as msgpack bin values
+func (this *msgpackOutput) EmitRaw(meta *messages.ShellMsgMeta, raw [][]byte) (err error) {
+	contents := make([]interface{}, len(raw))
+	for i := range raw {
+		contents[i] = raw[i]
+	}
+	return this.EmitShellMsg(meta, contents...)
+}
+
+func (this *msgpackOutput) Flush() (err error) {
+	return this.writer.Flush()
+}
+
+// The msgpack envelope is combined with a payload codec using encoding
+// names such as "msgpack+proto", while the msgpack payload codec can be
+// combined with other envelopes, e.g. "json+msgpack".
+
+func NewMsgpackEnvelopeInputFactory() core.EnvelopeInputFactory {
+	return &msgpackEnvelopeInputFactory{}
+}
+
+type msgpackEnvelopeInputFactory struct{}
+
+func (this *msgpackEnvelopeInputFactory) NewEnvelopeInput(reader io.Reader) core.EnvelopeInput {
+	return NewMsgpackInput(reader).(*msgpackInput)
+}
+
+func NewMsgpackEnvelopeOutputFactory() core.EnvelopeOutputFactory {
+	return &msgpackEnvelopeOutputFactory{}
+}
+
+type msgpackEnvelopeOutputFactory struct{}
+
+func (this *msgpackEnvelopeOutputFactory) NewEnvelopeOutput(writer io.Writer) core.EnvelopeOutput {
+	return NewMsgpackOutput(writer).(*msgpackOutput)
+}
+
+// payloadCodec encodes the fields of tuples as msgpack
+type payloadCodec struct{}
+
+func (payloadCodec) Marshal(field interface{}) ([]byte, error) {
+	return Marshal(field)
+}
+
+func (payloadCodec) Unmarshal(data []byte, field interface{}) error {
+	return Unmarshal(data, field)
+}
+
+func init() {
+	core.RegisterInput("msgpack", NewMsgpackInputFactory())
+	core.RegisterOutput("msgpack", NewMsgpackOutputFactory())
+	core.RegisterEnvelopeInput("msgpack", NewMsgpackEnvelopeInputFactory())
+	core.RegisterEnvelopeOutput("msgpack", NewMsgpackEnvelopeOutputFactory())
+	core.RegisterPayloadCodec("msgpack", payloadCodec{})
+}
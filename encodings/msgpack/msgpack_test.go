@@ -0,0 +1,357 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+type testObj struct {
+	Name   string `msgpack:"name"`
+	Number int64  `msgpack:"number"`
+	Data   []byte `msgpack:"data"`
+}
+
+func newTestObj(name string, number int64, data []byte) *testObj {
+	return &testObj{
+		Name:   name,
+		Number: number,
+		Data:   data,
+	}
+}
+
+func TestSendRecv(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+
+	var outMsgStream []*testObj
+	for i := 0; i < 100; i++ {
+		num := rand.Int63()
+		numStr := fmt.Sprintf("%d", num)
+		outMsg := newTestObj(numStr, num, []byte(numStr))
+		outMsgStream = append(outMsgStream, outMsg)
+		checkErr(output.SendMsg(outMsg), t)
+	}
+	checkErr(output.Flush(), t)
+
+	input := NewMsgpackInput(buffer)
+	for i := 0; i < 100; i++ {
+		inMsg := &testObj{}
+		checkErr(input.ReadMsg(inMsg), t)
+		if fmt.Sprint(inMsg) != fmt.Sprint(outMsgStream[i]) {
+			t.Fatalf("Written message (%v) does not equal read message (%v)", outMsgStream[i], inMsg)
+		}
+	}
+	if err := input.ReadMsg(&testObj{}); err != io.EOF {
+		t.Fatalf("Expected EOF, received: %v", err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	data, err := Marshal(newTestObj("name", 1, []byte("data")))
+	checkErr(err, t)
+	input := NewMsgpackInput(bytes.NewReader(data[:len(data)-1]))
+	err = input.ReadMsg(&testObj{})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected an unexpected EOF, received: %v", err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	outContext := &messages.Context{
+		PidDir: "/tmp",
+		Topology: &messages.Topology{
+			TaskId: 3,
+			TaskComponentMappings: []*messages.TaskComponentMapping{
+				{Task: "3", Component: "split"},
+			},
+		},
+		Confs: []*messages.Conf{
+			{Key: "topology.name", Value: "word-count"},
+		},
+	}
+	checkErr(output.SendMsg(outContext), t)
+	checkErr(output.Flush(), t)
+
+	inContext := &messages.Context{}
+	checkErr(input.ReadMsg(inContext), t)
+	if !inContext.Equal(outContext) {
+		t.Fatalf("Context (%v) does not equal read context (%v)", outContext, inContext)
+	}
+
+	// Configuration values are formatted like the json encodings do
+	data, err := Marshal(map[string]interface{}{
+		"conf": map[string]interface{}{
+			"topology.message.timeout.secs": 30,
+			"topology.debug":                true,
+			"topology.tasks":                nil,
+		},
+	})
+	checkErr(err, t)
+	inContext = &messages.Context{}
+	checkErr(NewMsgpackInput(bytes.NewReader(data)).ReadMsg(inContext), t)
+	for key, expected := range map[string]string{"topology.message.timeout.secs": "30", "topology.debug": "true"} {
+		if value, ok := inContext.Conf(key); !ok || value != expected {
+			t.Fatalf("Expected %s to be %s, received: %s", key, expected, value)
+		}
+	}
+	if _, ok := inContext.Conf("topology.tasks"); ok {
+		t.Fatal("Expected a nil configuration value to be reported as not found")
+	}
+}
+
+func TestSpoutMsg(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	for _, outMsg := range []*messages.SpoutMsg{{Command: "next"}, {Command: "ack", Id: "1231231"}} {
+		checkErr(output.SendMsg(outMsg), t)
+		checkErr(output.Flush(), t)
+		inMsg := &messages.SpoutMsg{}
+		checkErr(input.ReadMsg(inMsg), t)
+		if !inMsg.Equal(outMsg) {
+			t.Fatalf("Spout message (%v) does not equal read spout message (%v)", outMsg, inMsg)
+		}
+	}
+}
+
+func TestEmitGeneric(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	for i := 0; i < 100; i++ {
+		num := rand.Int63()
+		numStr := fmt.Sprintf("%d", num)
+		outMsg := newTestObj(numStr, num, []byte(numStr))
+		needTaskIds := i%2 == 0
+		outMeta := &messages.ShellMsgMeta{
+			Command: "emit",
+			Anchors: []string{"1", "2"},
+			Id:      &numStr,
+			Stream:  &numStr,
+			Task:    &num,
+			Msg:     &numStr,
+		}
+		if !needTaskIds {
+			outMeta.NeedTaskIds = &needTaskIds
+		}
+		checkErr(output.EmitGeneric(outMeta.Command, outMeta.GetId(), outMeta.GetStream(), outMeta.GetMsg(), outMeta.GetAnchors(), outMeta.GetTask(), needTaskIds, outMsg), t)
+		checkErr(output.Flush(), t)
+
+		inMsg := &testObj{}
+		shellMsg := &messages.ShellMsg{
+			ShellMsgJson: &messages.ShellMsgJson{
+				Contents: []interface{}{inMsg},
+			},
+		}
+		checkErr(input.ReadMsg(shellMsg), t)
+
+		if !shellMsg.ShellMsgJson.ShellMsgMeta.Equal(outMeta) {
+			t.Fatalf("Emission metadata (%+v) does not equal read emission metadata (%+v)", outMeta, shellMsg.ShellMsgJson.ShellMsgMeta)
+		}
+		if fmt.Sprint(inMsg) != fmt.Sprint(outMsg) {
+			t.Fatalf("Emission data (%+v) does not equal read emission data (%+v)", outMsg, inMsg)
+		}
+	}
+}
+
+func TestEmitMetric(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	name := "count"
+	outMeta := &messages.ShellMsgMeta{
+		Command: "metrics",
+		Name:    &name,
+		Params:  []byte(`{"a":1,"b":[1.5]}`),
+	}
+	checkErr(output.EmitShellMsg(outMeta), t)
+	checkErr(output.Flush(), t)
+
+	var params map[string]interface{}
+	checkErr(input.ReadMsg(&params), t)
+	expected := map[string]interface{}{"a": int64(1), "b": []interface{}{1.5}}
+	if fmt.Sprint(params["params"]) != fmt.Sprint(expected) {
+		t.Fatalf("Expected params %v, received: %v", expected, params["params"])
+	}
+}
+
+func newBoltMsg(id string, contents ...interface{}) *messages.BoltMsg {
+	return &messages.BoltMsg{
+		BoltMsgJson: &messages.BoltMsgJson{
+			BoltMsgMeta: &messages.BoltMsgMeta{
+				Id:     id,
+				Comp:   "comp",
+				Stream: "stream",
+				Task:   7,
+			},
+			Contents: contents,
+		},
+	}
+}
+
+func TestReadBoltMsg(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	for i := 0; i < 100; i++ {
+		num := rand.Int63()
+		numStr := fmt.Sprintf("%d", num)
+		outMsg := newTestObj(numStr, num, []byte(numStr))
+		outTuple := newBoltMsg(numStr, outMsg, numStr)
+		checkErr(output.SendMsg(outTuple), t)
+		checkErr(output.Flush(), t)
+
+		inMsg := &testObj{}
+		var inStr string
+		inMeta := &messages.BoltMsgMeta{}
+		checkErr(input.ReadBoltMsg(inMeta, inMsg, &inStr), t)
+
+		if !inMeta.Equal(outTuple.BoltMsgJson.BoltMsgMeta) {
+			t.Fatalf("Tuple metadata (%+v) does not equal read tuple metadata (%+v)", outTuple.BoltMsgJson.BoltMsgMeta, inMeta)
+		}
+		if fmt.Sprint(inMsg) != fmt.Sprint(outMsg) || inStr != numStr {
+			t.Fatalf("Tuple data (%+v, %s) does not equal read tuple data (%+v, %s)", outMsg, numStr, inMsg, inStr)
+		}
+	}
+}
+
+func TestReadBoltMsgFieldError(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	checkErr(output.SendMsg(newBoltMsg("id", "text", "not a number")), t)
+	checkErr(output.Flush(), t)
+
+	var text string
+	var number int
+	err := input.ReadBoltMsg(&messages.BoltMsgMeta{}, &text, &number)
+	decodeErr, ok := err.(*core.Error)
+	if !ok {
+		t.Fatalf("Expected an encoding error, received: %v", err)
+	}
+	if decodeErr.Id != "id" || decodeErr.Field != 1 || len(decodeErr.Raw) != 2 {
+		t.Fatalf("Unexpected encoding error: %+v", decodeErr)
+	}
+
+	// Fields that could not be decoded can be forwarded unchanged
+	checkErr(output.EmitGeneric("emit", "", "", "", nil, 0, false, core.RawField(decodeErr.Raw[1])), t)
+	checkErr(output.Flush(), t)
+	shellMsg := &messages.ShellMsg{
+		ShellMsgJson: &messages.ShellMsgJson{},
+	}
+	checkErr(input.ReadMsg(shellMsg), t)
+	if fmt.Sprint(shellMsg.ShellMsgJson.Contents) != "[not a number]" {
+		t.Fatalf("Expected the raw field to be forwarded, received: %v", shellMsg.ShellMsgJson.Contents)
+	}
+}
+
+func TestNilJsonMsg(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	// Messages without their JSON form cannot be sent
+	for _, msg := range []interface{}{&messages.BoltMsg{}, &messages.ShellMsg{}} {
+		if _, ok := output.SendMsg(msg).(*core.Error); !ok {
+			t.Fatalf("Expected an encoding error for %T without metadata", msg)
+		}
+	}
+
+	// Received messages are decoded into a new JSON form
+	checkErr(output.SendMsg(newBoltMsg("id", "text")), t)
+	checkErr(output.EmitGeneric("emit", "", "stream", "", nil, 0, false, "text"), t)
+	checkErr(output.Flush(), t)
+	boltMsg := &messages.BoltMsg{}
+	checkErr(input.ReadMsg(boltMsg), t)
+	if boltMsg.BoltMsgJson.BoltMsgMeta.Id != "id" || fmt.Sprint(boltMsg.BoltMsgJson.Contents) != "[text]" {
+		t.Fatalf("Unexpected tuple: %+v", boltMsg.BoltMsgJson)
+	}
+	shellMsg := &messages.ShellMsg{}
+	checkErr(input.ReadMsg(shellMsg), t)
+	if shellMsg.ShellMsgJson.ShellMsgMeta.GetStream() != "stream" || fmt.Sprint(shellMsg.ShellMsgJson.Contents) != "[text]" {
+		t.Fatalf("Unexpected shell message: %+v", shellMsg.ShellMsgJson)
+	}
+}
+
+func TestReadTaskIdsInterleaved(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	input := NewMsgpackInput(buffer)
+
+	for i := 0; i < 3; i++ {
+		checkErr(output.SendMsg(newBoltMsg(fmt.Sprint(i), i)), t)
+	}
+	outIds := &messages.TaskIds{TaskIds: []int32{4, 5, 6}}
+	checkErr(output.SendMsg(outIds), t)
+	checkErr(output.Flush(), t)
+
+	taskIds, err := input.ReadTaskIds()
+	checkErr(err, t)
+	if fmt.Sprint(taskIds) != fmt.Sprint(outIds.TaskIds) {
+		t.Fatalf("Expected task ids %v, received: %v", outIds.TaskIds, taskIds)
+	}
+	if buffered := input.(core.BufferedInput).Buffered(); buffered != 3 {
+		t.Fatalf("Expected 3 buffered tuples, received: %d", buffered)
+	}
+
+	for i := 0; i < 3; i++ {
+		var field int
+		inMeta := &messages.BoltMsgMeta{}
+		checkErr(input.ReadBoltMsg(inMeta, &field), t)
+		if inMeta.Id != fmt.Sprint(i) || field != i {
+			t.Fatalf("Expected buffered tuple %d, received: %s with field %d", i, inMeta.Id, field)
+		}
+	}
+}
+
+func BenchmarkEmit(b *testing.B) {
+	output := NewMsgpackOutput(io.Discard)
+	msg := newTestObj("name", 1234567, []byte("data"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		output.EmitGeneric("emit", "", "stream", "", []string{"1"}, 0, false, msg)
+	}
+}
+
+func BenchmarkReadBoltMsg(b *testing.B) {
+	buffer := new(bytes.Buffer)
+	output := NewMsgpackOutput(buffer)
+	output.SendMsg(newBoltMsg("id", newTestObj("name", 1234567, []byte("data"))))
+	output.Flush()
+	data := buffer.Bytes()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		input := NewMsgpackInput(bytes.NewReader(data))
+		input.ReadBoltMsg(&messages.BoltMsgMeta{}, &testObj{})
+	}
+}
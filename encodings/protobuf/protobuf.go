@@ -18,6 +18,7 @@ import (
 	"bufio"
 	"container/list"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
@@ -30,18 +31,38 @@ func NewProtobufInputFactory() core.InputFactory {
 	return &protobufInputFactory{}
 }
 
-type protobufInputFactory struct{}
+// NewProtobufTaggedInputFactory creates inputs for the protobufTagged
+// encoding, of which every message is preceded by a type tag
+func NewProtobufTaggedInputFactory() core.InputFactory {
+	return &protobufInputFactory{tagged: true}
+}
+
+type protobufInputFactory struct {
+	tagged bool
+}
 
 func (this *protobufInputFactory) NewInput(reader io.Reader) core.Input {
-	return NewProtobufInput(reader)
+	return newProtobufInput(reader, this.tagged)
 }
 
 func NewProtobufInput(reader io.Reader) core.Input {
+	return newProtobufInput(reader, false)
+}
+
+// NewProtobufTaggedInput reads messages that are each preceded by a type
+// tag, which allows tuples that Storm sends before the task ids of an
+// emission to be buffered
+func NewProtobufTaggedInput(reader io.Reader) core.Input {
+	return newProtobufInput(reader, true)
+}
+
+func newProtobufInput(reader io.Reader, tagged bool) *protobufInput {
 	return &protobufInput{
 		// TODO Only a spout should have an unbuffered byte reader
 		reader:      bufio.NewReader(reader),
 		tupleBuffer: list.New(),
 		bufferPool:  NewBufferPoolSingle(NewAllocatorHeap()),
+		tagged:      tagged,
 	}
 }
 
@@ -49,6 +70,57 @@ type protobufInput struct {
 	reader      *bufio.Reader
 	tupleBuffer *list.List
 	bufferPool  BufferPool
+	tagged      bool
+}
+
+// In the protobufTagged encoding, every message is preceded by a tag that
+// identifies its type, so that tuples that Storm sends before the task ids
+// of an emission can be told apart from the task ids. The length prefix of
+// a message includes the tag. The protobuf encoding sends messages without
+// tags, as the ProtoShell components of Storm expect.
+const (
+	tagOther byte = iota
+	tagContext
+	tagPid
+	tagBoltMsg
+	tagTaskIds
+	tagSpoutMsg
+	tagShellMsg
+)
+
+// msgTag returns the tag of the type of a message. Messages of other
+// types, such as those used in tests, are tagged with tagOther.
+func msgTag(msg interface{}) byte {
+	switch msg.(type) {
+	case *messages.Context:
+		return tagContext
+	case *messages.Pid:
+		return tagPid
+	case *messages.BoltMsg:
+		return tagBoltMsg
+	case *messages.TaskIds:
+		return tagTaskIds
+	case *messages.SpoutMsg:
+		return tagSpoutMsg
+	case *messages.ShellMsg:
+		return tagShellMsg
+	}
+	return tagOther
+}
+
+// untag checks that data holds a message of the expected type and
+// returns the message without its tag. Untagged data is returned as is.
+func (this *protobufInput) untag(data []byte, msg interface{}) ([]byte, error) {
+	if !this.tagged {
+		return data, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("received a message without a tag")
+	}
+	if tag := msgTag(msg); data[0] != tag {
+		return nil, fmt.Errorf("received a message with tag %d, expected tag %d", data[0], tag)
+	}
+	return data[1:], nil
 }
 
 func (this *protobufInput) readData() (data []byte, err error) {
@@ -66,6 +138,12 @@ func (this *protobufInput) readData() (data []byte, err error) {
 	return data, nil
 }
 
+// Buffered returns the number of tuples and bytes that have been read
+// ahead, which can be read without blocking
+func (this *protobufInput) Buffered() int {
+	return this.tupleBuffer.Len() + this.reader.Buffered()
+}
+
 // readBytes reads data from stdin into the struct provided.
 func (this *protobufInput) ReadMsg(msg interface{}) (err error) {
 	var data []byte
@@ -84,103 +162,170 @@ func (this *protobufInput) ReadMsg(msg interface{}) (err error) {
 		bufferPoolRead = true
 	}
 
-	err = proto.Unmarshal(data, msg.(proto.Message))
+	protoMsg, ok := msg.(proto.Message)
+	if !ok {
+		err = fmt.Errorf("%T is not a protocol buffer message", msg)
+	} else {
+		var body []byte
+		body, err = this.untag(data, msg)
+		if err == nil {
+			err = proto.Unmarshal(body, protoMsg)
+		}
+	}
 	if bufferPoolRead {
 		// If the buffer pool was mmapped, we don't want to mix heap and mmapped data
 		this.bufferPool.Dispose(data)
 	}
-	return err
-}
-
-// TODO This asynchronous behaviour should still be added to protobuf
-func isTuple(data []byte) bool {
-	return false
+	if err != nil {
+		return core.NewError("protobuf", core.MsgName(msg), err)
+	}
+	return nil
 }
 
-func (this *protobufInput) ReadTaskIds() (taskIds []int32) {
-	// Read a single json record from the input file
+// ReadTaskIds reads the task ids of an emission. With tagged messages,
+// tuples that Storm sends before the task ids are buffered and returned
+// by subsequent reads.
+func (this *protobufInput) ReadTaskIds() (taskIds []int32, err error) {
+	// Read a single protobuf record from the input file
 	data, err := this.readData()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	if isTuple(data) {
+	if this.tagged && len(data) > 0 && data[0] == tagBoltMsg {
 		// Since we want to dispose of the data slice to reuse it for
 		// reading, we create a new slice for buffering. Creating a
 		// new slice here is ok, since we probably didn't create one
 		// when we actually read the data.
 		bufferedData := make([]byte, len(data))
 		copy(bufferedData, data)
+		this.bufferPool.Dispose(data)
 		this.tupleBuffer.PushBack(bufferedData)
 		return this.ReadTaskIds()
 	}
 
 	taskIdsProto := &messages.TaskIds{}
-	err = proto.Unmarshal(data, taskIdsProto)
-	if err != nil {
-		panic(err)
+	body, err := this.untag(data, taskIdsProto)
+	if err == nil {
+		err = proto.Unmarshal(body, taskIdsProto)
 	}
 	this.bufferPool.Dispose(data)
-	return taskIdsProto.TaskIds
-}
-
-func (this *protobufInput) constructInput(contents ...interface{}) [][]byte {
-	contentList := make([][]byte, len(contents))
-	return contentList
+	if err != nil {
+		return nil, core.NewError("protobuf", "TaskIds", err)
+	}
+	return taskIdsProto.TaskIds, nil
 }
 
-func (this *protobufInput) decodeInput(contentList [][]byte, contentStructs ...interface{}) {
-	for i, content := range contentList {
-		err := proto.Unmarshal(content, contentStructs[i].(proto.Message))
+func (this *protobufInput) decodeInput(contentList [][]byte, contentStructs ...interface{}) error {
+	if len(contentStructs) == 0 {
+		return nil
+	}
+	// Tuples such as heartbeats may contain fewer fields than expected
+	if len(contentList) > len(contentStructs) {
+		decodeErr := core.NewFieldError("protobuf", "BoltMsg", len(contentStructs), fmt.Errorf("received %d fields, expected at most %d", len(contentList), len(contentStructs)))
+		decodeErr.Raw = contentList
+		return decodeErr
+	}
+	for i, data := range contentList {
+		var err error
+		protoStruct, ok := contentStructs[i].(proto.Message)
+		if !ok {
+			err = fmt.Errorf("%T is not a protocol buffer message", contentStructs[i])
+		} else {
+			err = proto.Unmarshal(data, protoStruct)
+		}
 		if err != nil {
-			panic(err)
+			decodeErr := core.NewFieldError("protobuf", "BoltMsg", i, err)
+			decodeErr.Raw = contentList
+			return decodeErr
 		}
 	}
+	return nil
 }
 
 // ReadTuple reads a tuple from Storm of which the contents are known
 // and decodes the contents into the provided list of structs
 func (this *protobufInput) ReadBoltMsg(metadata *messages.BoltMsgMeta, contentStructs ...interface{}) (err error) {
+	return this.ReadBoltMsgFunc(metadata, core.StaticFields(contentStructs))
+}
+
+// ReadBoltMsgFunc reads a tuple from Storm and decodes the contents
+// into the structs returned for the metadata of the tuple
+func (this *protobufInput) ReadBoltMsgFunc(metadata *messages.BoltMsgMeta, fields core.FieldsFunc) (err error) {
+	// Ensure that the metadata of a previous tuple is never reported
+	metadata.Reset()
+	raw, err := this.ReadRawBoltMsg(metadata)
+	if err != nil {
+		return err
+	}
+	err = this.decodeInput(raw, fields(metadata)...)
+	if err != nil {
+		err.(*core.Error).Id = metadata.Id
+		return err
+	}
+	return nil
+}
+
+// ReadRawBoltMsg reads a tuple from Storm and returns its encoded fields
+func (this *protobufInput) ReadRawBoltMsg(metadata *messages.BoltMsgMeta) (raw [][]byte, err error) {
 	boltMsg := &messages.BoltMsg{
 		BoltMsgProto: &messages.BoltMsgProto{
 			//BoltMsgMeta: metadata,
-			Contents: this.constructInput(contentStructs...),
 		},
 	}
 	err = this.ReadMsg(boltMsg)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if boltMsg.BoltMsgMeta == nil {
+		return nil, core.NewError("protobuf", "BoltMsg", errors.New("missing tuple metadata"))
 	}
 
-	this.decodeInput(boltMsg.Contents, contentStructs...)
 	// TODO This assignment needs to be replaced with the top
 	// assignmet and unmarshalMerge used when that feature has been
 	// added to gogoprotobuf
 	*metadata = *boltMsg.BoltMsgMeta
-	return nil
+	return boltMsg.Contents, nil
 }
 
 func NewProtobufOutputFactory() core.OutputFactory {
 	return &protobufOutputFactory{}
 }
 
-type protobufOutputFactory struct{}
+// NewProtobufTaggedOutputFactory creates outputs for the protobufTagged
+// encoding, of which every message is preceded by a type tag
+func NewProtobufTaggedOutputFactory() core.OutputFactory {
+	return &protobufOutputFactory{tagged: true}
+}
+
+type protobufOutputFactory struct {
+	tagged bool
+}
 
 func (this *protobufOutputFactory) NewOutput(writer io.Writer) core.Output {
-	return NewProtobufOutput(writer)
+	return newProtobufOutput(writer, this.tagged)
 }
 
 func NewProtobufOutput(writer io.Writer) core.Output {
+	return newProtobufOutput(writer, false)
+}
+
+// NewProtobufTaggedOutput writes every message preceded by a type tag
+func NewProtobufTaggedOutput(writer io.Writer) core.Output {
+	return newProtobufOutput(writer, true)
+}
+
+func newProtobufOutput(writer io.Writer, tagged bool) *protobufOutput {
 	shellMsg := &messages.ShellMsg{
-		ShellMsgProto: &messages.ShellMsgProto{
-			ShellMsgMeta: &messages.ShellMsgMeta{},
-		},
+		ShellMsgProto: &messages.ShellMsgProto{},
 	}
 
 	return &protobufOutput{
 		writer:     bufio.NewWriter(writer),
 		bufferPool: NewBufferPoolSingle(NewAllocatorHeap()),
 		shellMsg:   shellMsg,
+		meta:       &messages.ShellMsgMeta{},
+		tagged:     tagged,
 	}
 }
 
@@ -188,6 +333,9 @@ type protobufOutput struct {
 	writer     *bufio.Writer
 	bufferPool BufferPool
 	shellMsg   *messages.ShellMsg
+	// meta is reused by EmitGeneric to avoid an allocation per emission
+	meta   *messages.ShellMsgMeta
+	tagged bool
 }
 
 func varintSize(x uint64) (n int) {
@@ -207,49 +355,69 @@ type ProtoMarshaler interface {
 }
 
 // sendMsg sends the contents of a known Storm message to Storm
-func (this *protobufOutput) SendMsg(msg interface{}) {
-	protoMsg := msg.(ProtoMarshaler)
-	protoSiz := protoMsg.Size()
-	varIntSiz := varintSize(uint64(protoSiz))
-	buffer := this.bufferPool.New(varIntSiz + protoSiz)
+func (this *protobufOutput) SendMsg(msg interface{}) (err error) {
+	protoMsg, ok := msg.(ProtoMarshaler)
+	if !ok {
+		return core.NewError("protobuf", core.MsgName(msg), fmt.Errorf("%T does not implement Size and MarshalTo", msg))
+	}
+	// The length prefix includes the tag that precedes the message
+	tagSiz := 0
+	if this.tagged {
+		tagSiz = 1
+	}
+	msgSiz := protoMsg.Size() + tagSiz
+	varIntSiz := varintSize(uint64(msgSiz))
+	buffer := this.bufferPool.New(varIntSiz + msgSiz)
+	defer this.bufferPool.Dispose(buffer)
 
-	n := binary.PutUvarint(buffer, uint64(protoSiz))
+	n := binary.PutUvarint(buffer, uint64(msgSiz))
 	if varIntSiz != n {
-		panic(fmt.Sprintf("Actual varint size did not match calculated varint size: %d instead of %d", n, varIntSiz))
+		return core.NewError("protobuf", core.MsgName(msg), fmt.Errorf("Actual varint size did not match calculated varint size: %d instead of %d", n, varIntSiz))
 	}
-
-	n, err := protoMsg.MarshalTo(buffer[n:])
-	if n+varIntSiz != len(buffer) {
-		panic(fmt.Sprintf("Protobuf: Invalid size written by MarshalTo: %d instead of %d", n, len(buffer)))
+	if this.tagged {
+		buffer[n] = msgTag(msg)
 	}
+
+	n, err = protoMsg.MarshalTo(buffer[n+tagSiz:])
 	if err != nil {
-		panic(err)
+		return core.NewError("protobuf", core.MsgName(msg), err)
+	}
+	if n+varIntSiz+tagSiz != len(buffer) {
+		return core.NewError("protobuf", core.MsgName(msg), fmt.Errorf("Invalid size written by MarshalTo: %d instead of %d", n, len(buffer)-varIntSiz-tagSiz))
 	}
 
 	n, err = this.writer.Write(buffer)
-	if n != varIntSiz+protoSiz {
-		panic("Incorrect length written")
-	}
 	if err != nil {
-		panic(err)
+		return err
 	}
-	this.bufferPool.Dispose(buffer)
+	if n != varIntSiz+msgSiz {
+		return io.ErrShortWrite
+	}
+	return nil
 }
 
-func (this *protobufOutput) constructOutput(contents ...interface{}) [][]byte {
+func (this *protobufOutput) constructOutput(contents ...interface{}) ([][]byte, error) {
 	contentList := make([][]byte, len(contents))
 	for i, content := range contents {
-		encoded, err := proto.Marshal(content.(proto.Message))
+		if raw, ok := content.(core.RawField); ok {
+			contentList[i] = raw
+			continue
+		}
+		protoContent, ok := content.(proto.Message)
+		if !ok {
+			return nil, core.NewFieldError("protobuf", "ShellMsg", i, fmt.Errorf("%T is not a protocol buffer message", content))
+		}
+		encoded, err := proto.Marshal(protoContent)
 		if err != nil {
-			panic(err)
+			return nil, core.NewFieldError("protobuf", "ShellMsg", i, err)
 		}
 		contentList[i] = encoded
 	}
-	return contentList
+	return contentList, nil
 }
 
-func (this *protobufOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) {
-	meta := this.shellMsg.ShellMsgMeta
+func (this *protobufOutput) EmitGeneric(command, id, stream, msg string, anchors []string, directTask int64, needTaskIds bool, contents ...interface{}) (err error) {
+	meta := this.meta
 	meta.Command = command
 	meta.Anchors = anchors
 	meta.Id = &id
@@ -257,15 +425,91 @@ func (this *protobufOutput) EmitGeneric(command, id, stream, msg string, anchors
 	meta.Task = &directTask
 	meta.NeedTaskIds = &needTaskIds
 	meta.Msg = &msg
-	this.shellMsg.ShellMsgProto.Contents = this.constructOutput(contents...)
-	this.SendMsg(this.shellMsg)
+	return this.EmitShellMsg(meta, contents...)
+}
+
+func (this *protobufOutput) EmitShellMsg(meta *messages.ShellMsgMeta, contents ...interface{}) (err error) {
+	contentList, err := this.constructOutput(contents...)
+	if err != nil {
+		return err
+	}
+	return this.EmitRaw(meta, contentList)
 }
 
-func (this *protobufOutput) Flush() {
-	this.writer.Flush()
+// EmitRaw sends a message with fields that have already been encoded
+func (this *protobufOutput) EmitRaw(meta *messages.ShellMsgMeta, raw [][]byte) (err error) {
+	this.shellMsg.ShellMsgProto.ShellMsgMeta = meta
+	this.shellMsg.ShellMsgProto.Contents = raw
+	return this.SendMsg(this.shellMsg)
+}
+
+func (this *protobufOutput) Flush() (err error) {
+	return this.writer.Flush()
+}
+
+// The protobuf envelope is combined with a payload codec using encoding
+// names such as "protobuf+msgpack". The proto payload codec can be
+// combined with other envelopes, e.g. "msgpack+proto".
+
+func NewProtobufEnvelopeInputFactory() core.EnvelopeInputFactory {
+	return &protobufEnvelopeInputFactory{}
+}
+
+func NewProtobufTaggedEnvelopeInputFactory() core.EnvelopeInputFactory {
+	return &protobufEnvelopeInputFactory{tagged: true}
+}
+
+type protobufEnvelopeInputFactory struct {
+	tagged bool
+}
+
+func (this *protobufEnvelopeInputFactory) NewEnvelopeInput(reader io.Reader) core.EnvelopeInput {
+	return newProtobufInput(reader, this.tagged)
+}
+
+func NewProtobufEnvelopeOutputFactory() core.EnvelopeOutputFactory {
+	return &protobufEnvelopeOutputFactory{}
+}
+
+func NewProtobufTaggedEnvelopeOutputFactory() core.EnvelopeOutputFactory {
+	return &protobufEnvelopeOutputFactory{tagged: true}
+}
+
+type protobufEnvelopeOutputFactory struct {
+	tagged bool
+}
+
+func (this *protobufEnvelopeOutputFactory) NewEnvelopeOutput(writer io.Writer) core.EnvelopeOutput {
+	return newProtobufOutput(writer, this.tagged)
+}
+
+// protoCodec encodes the fields of tuples as protocol buffers
+type protoCodec struct{}
+
+func (protoCodec) Marshal(field interface{}) ([]byte, error) {
+	protoField, ok := field.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protocol buffer message", field)
+	}
+	return proto.Marshal(protoField)
+}
+
+func (protoCodec) Unmarshal(data []byte, field interface{}) error {
+	protoField, ok := field.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a protocol buffer message", field)
+	}
+	return proto.Unmarshal(data, protoField)
 }
 
 func init() {
 	core.RegisterInput("protobuf", NewProtobufInputFactory())
 	core.RegisterOutput("protobuf", NewProtobufOutputFactory())
+	core.RegisterEnvelopeInput("protobuf", NewProtobufEnvelopeInputFactory())
+	core.RegisterEnvelopeOutput("protobuf", NewProtobufEnvelopeOutputFactory())
+	core.RegisterInput("protobufTagged", NewProtobufTaggedInputFactory())
+	core.RegisterOutput("protobufTagged", NewProtobufTaggedOutputFactory())
+	core.RegisterEnvelopeInput("protobufTagged", NewProtobufTaggedEnvelopeInputFactory())
+	core.RegisterEnvelopeOutput("protobufTagged", NewProtobufTaggedEnvelopeOutputFactory())
+	core.RegisterPayloadCodec("proto", protoCodec{})
 }
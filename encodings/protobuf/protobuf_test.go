@@ -16,6 +16,7 @@ package protobuf
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	proto "github.com/jsgilmore/gostorm/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	"github.com/jsgilmore/gostorm/messages"
@@ -128,6 +129,29 @@ func TestEmitGeneric(t *testing.T) {
 	}
 }
 
+func TestEmitShellMsg(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewProtobufOutput(buffer)
+	input := NewProtobufInput(buffer)
+
+	for i := 0; i < 100; i++ {
+		outMeta := messages.NewPopulatedShellMsgMeta(rand.New(rand.NewSource(int64(i))), true)
+		err := output.EmitShellMsg(outMeta)
+		checkErr(err, t)
+		output.Flush()
+
+		shellMsg := &messages.ShellMsg{
+			ShellMsgProto: &messages.ShellMsgProto{},
+		}
+		err = input.ReadMsg(shellMsg)
+		checkErr(err, t)
+
+		if err := shellMsg.ShellMsgProto.ShellMsgMeta.VerboseEqual(outMeta); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestReadBoltMsg(t *testing.T) {
 	buffer := new(bytes.Buffer)
 	output := NewProtobufOutput(buffer)
@@ -167,3 +191,116 @@ func TestReadBoltMsg(t *testing.T) {
 		}
 	}
 }
+
+func TestReadTaskIdsInterleaved(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewProtobufTaggedOutput(buffer)
+	input := NewProtobufTaggedInput(buffer)
+
+	var outTuples []*messages.BoltMsg
+	for i := 0; i < 3; i++ {
+		numStr := fmt.Sprintf("%d", i)
+		outTuple := &messages.BoltMsg{
+			BoltMsgProto: &messages.BoltMsgProto{
+				BoltMsgMeta: &messages.BoltMsgMeta{
+					Id:     numStr,
+					Comp:   "comp",
+					Stream: "stream",
+					Task:   int64(i),
+				},
+			},
+		}
+		outTuples = append(outTuples, outTuple)
+		checkErr(output.SendMsg(outTuple), t)
+	}
+	outIds := &messages.TaskIds{TaskIds: []int32{4, 5, 6}}
+	checkErr(output.SendMsg(outIds), t)
+	checkErr(output.SendMsg(&messages.TaskIds{}), t)
+	checkErr(output.Flush(), t)
+
+	taskIds, err := input.ReadTaskIds()
+	checkErr(err, t)
+	if fmt.Sprint(taskIds) != fmt.Sprint(outIds.TaskIds) {
+		t.Fatalf("Expected task ids %v, received: %v", outIds.TaskIds, taskIds)
+	}
+	taskIds, err = input.ReadTaskIds()
+	checkErr(err, t)
+	if len(taskIds) != 0 {
+		t.Fatalf("Expected no task ids, received: %v", taskIds)
+	}
+
+	for _, outTuple := range outTuples {
+		inMeta := &messages.BoltMsgMeta{}
+		checkErr(input.ReadBoltMsg(inMeta), t)
+		if !inMeta.Equal(outTuple.BoltMsgProto.BoltMsgMeta) {
+			t.Fatalf("Tuple metadata (%+v) does not equal buffered tuple metadata (%+v)", outTuple.BoltMsgProto.BoltMsgMeta, inMeta)
+		}
+	}
+}
+
+// writeFrame writes a tagged message that has been encoded by hand
+func writeFrame(buffer *bytes.Buffer, tag byte, data []byte) {
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(data)+1))
+	buffer.Write(length[:n])
+	buffer.WriteByte(tag)
+	buffer.Write(data)
+}
+
+func TestReadTaskIdsTagged(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	input := NewProtobufTaggedInput(buffer)
+
+	// A tuple of which the contents are encoded before the metadata is
+	// still buffered, since only its tag is inspected
+	outMeta := &messages.BoltMsgMeta{Id: "1", Comp: "comp", Stream: "stream", Task: 1}
+	meta, err := proto.Marshal(outMeta)
+	checkErr(err, t)
+	tuple := []byte{2<<3 | proto.WireBytes, 1, 'a', 1<<3 | proto.WireBytes, byte(len(meta))}
+	writeFrame(buffer, tagBoltMsg, append(tuple, meta...))
+	writeFrame(buffer, tagTaskIds, []byte{1<<3 | proto.WireVarint, 4})
+
+	taskIds, err := input.ReadTaskIds()
+	checkErr(err, t)
+	if fmt.Sprint(taskIds) != "[4]" {
+		t.Fatalf("Expected task ids [4], received: %v", taskIds)
+	}
+	inMeta := &messages.BoltMsgMeta{}
+	raw, err := input.(*protobufInput).ReadRawBoltMsg(inMeta)
+	checkErr(err, t)
+	if !inMeta.Equal(outMeta) || len(raw) != 1 || string(raw[0]) != "a" {
+		t.Fatalf("Expected the buffered tuple %+v with field a, received: %+v with %q", outMeta, inMeta, raw)
+	}
+
+	// Messages of other types are reported instead of being decoded as task ids
+	writeFrame(buffer, tagSpoutMsg, nil)
+	if _, err := input.ReadTaskIds(); err == nil {
+		t.Fatal("Expected a message that is not a tuple or task ids to be reported")
+	}
+}
+
+// The protobuf encoding sends messages without tags, so that it stays
+// compatible with the ProtoShell components of Storm
+func TestUntaggedFraming(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	output := NewProtobufOutput(buffer)
+	outIds := &messages.TaskIds{TaskIds: []int32{4, 5}}
+	checkErr(output.SendMsg(outIds), t)
+	checkErr(output.Flush(), t)
+
+	data, err := proto.Marshal(outIds)
+	checkErr(err, t)
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(data)))
+	expected := append(length[:n], data...)
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Fatalf("Expected untagged frame %v, sent: %v", expected, buffer.Bytes())
+	}
+
+	input := NewProtobufInput(buffer)
+	taskIds, err := input.ReadTaskIds()
+	checkErr(err, t)
+	if fmt.Sprint(taskIds) != fmt.Sprint(outIds.TaskIds) {
+		t.Fatalf("Expected task ids %v, received: %v", outIds.TaskIds, taskIds)
+	}
+}
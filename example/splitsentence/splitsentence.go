@@ -16,29 +16,34 @@ package main
 
 import (
 	"fmt"
-	"github.com/jsgilmore/gostorm/core"
-	jsonencoding "github.com/jsgilmore/gostorm/encodings/json"
+	"github.com/jsgilmore/gostorm"
 	"github.com/jsgilmore/gostorm/messages"
 	"log"
 	"os"
-	"os/signal"
 	"strings"
 )
 
-func handleSigTerm() {
-	// Enable the capture of Ctrl-C, to cleanly close the application
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
-	sig := <-c
-	log.Printf("Received %s signal, closing.", sig.String())
-	os.Exit(1)
+// splitSentence emits every word of a sentence. Since it is a basic
+// bolt, the words are anchored to the sentence and the sentence is
+// acked once Execute returns.
+type splitSentence struct{}
+
+func (this *splitSentence) Prepare(context *messages.Context, collector gostorm.BasicOutputCollector) {
 }
 
-func emitWords(sentance, id string, boltConn core.BoltConn) {
-	words := strings.Split(sentance, " ")
-	for _, word := range words {
-		boltConn.Emit([]string{id}, "", word)
+func (this *splitSentence) Execute(meta messages.BoltMsgMeta, collector gostorm.BasicOutputCollector, fields ...interface{}) error {
+	sentence := *fields[0].(*string)
+	for _, word := range strings.Split(sentence, " ") {
+		collector.Emit("", word)
 	}
+	return nil
+}
+
+func (this *splitSentence) Cleanup() {}
+
+func (this *splitSentence) Fields() []interface{} {
+	var sentence string
+	return []interface{}{&sentence}
 }
 
 func main() {
@@ -51,34 +56,12 @@ func main() {
 	log.SetOutput(fo)
 	//log.SetOutput(os.Stdout)
 
-	// This section allows us to correctly log signals and system panics
-	go handleSigTerm()
-	defer func() {
-		if r := recover(); r != nil {
-			log.Panicf("Recovered panic: %v", r)
-		}
-	}()
-
-	input := jsonencoding.NewJsonObjectInput(os.Stdin)
-	output := jsonencoding.NewJsonObjectOutput(os.Stdout)
-	boltConn := core.NewBoltConn(input, output, false)
-	boltConn.Connect()
-
-	for {
-		var sentence string
-		// We have to read Raw here, since the spout is not json encoding the tuple contents
-		meta := &messages.BoltMsgMeta{}
-		err := boltConn.ReadBoltMsg(meta, &sentence)
-		if err != nil {
-			panic(err)
-		}
-
-		if meta.GetStream() == "__heartbeat" {
-			boltConn.SendSync()
-			continue
-		}
-
-		emitWords(sentence, meta.Id, boltConn)
-		boltConn.SendAck(meta.Id)
-	}
+	// Clean up on signals, report panics to Storm and keep stray writes
+	// to stdout from corrupting the messages sent to Storm
+	gostorm.RunBolt(gostorm.NewBasicBolt(&splitSentence{}),
+		gostorm.WithEncoding("jsonObject"),
+		gostorm.WithSignalHandling(),
+		gostorm.WithPanicRecovery(),
+		gostorm.WithStdoutRedirect(),
+	)
 }
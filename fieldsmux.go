@@ -0,0 +1,88 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"fmt"
+)
+
+// FieldsMux is an InputFieldsFactory that returns the fields registered
+// for the component and stream a tuple was received from. Bolts that
+// join several inputs with different fields can embed a FieldsMux and
+// register a fields function for each input in their constructor.
+// The zero value of a FieldsMux is an empty mux.
+type FieldsMux struct {
+	inputs map[inputStream]func() []interface{}
+}
+
+type inputStream struct {
+	comp   string
+	stream string
+}
+
+// normaliseStream maps the empty stream name to the default stream,
+// as Storm does when a component emits without naming a stream
+func normaliseStream(stream string) string {
+	if len(stream) == 0 {
+		return "default"
+	}
+	return stream
+}
+
+// Handle registers the function that returns the fields of the tuples
+// received from the given stream of a component. Registering the "*"
+// stream registers the fields for all the streams of the component
+// that have not been registered separately.
+func (this *FieldsMux) Handle(comp, stream string, fields func() []interface{}) {
+	if this.inputs == nil {
+		this.inputs = make(map[inputStream]func() []interface{})
+	}
+	if stream != "*" {
+		stream = normaliseStream(stream)
+	}
+	input := inputStream{comp: comp, stream: stream}
+	if _, ok := this.inputs[input]; ok {
+		panic(fmt.Sprintf("FieldsMux: Fields already registered for component %s stream %s", comp, stream))
+	}
+	this.inputs[input] = fields
+}
+
+// InputFields returns the fields registered for the given component and
+// stream, or nil if none were registered
+func (this *FieldsMux) InputFields(comp, stream string) []interface{} {
+	fields, ok := this.inputs[inputStream{comp: comp, stream: normaliseStream(stream)}]
+	if !ok {
+		fields, ok = this.inputs[inputStream{comp: comp, stream: "*"}]
+	}
+	if !ok {
+		return nil
+	}
+	return fields()
+}
+
+// BoltFields returns the structs into which the fields of a tuple
+// received from the given component and stream are decoded. The fields
+// of a bolt that implements InputFieldsFactory are used if it returns
+// any for the input, otherwise the fields returned by Fields are used.
+// The InputFieldsFactory of a bolt wrapped by NewBasicBolt or
+// NewTypedBolt is found as well.
+func BoltFields(bolt Bolt, comp, stream string) []interface{} {
+	if factory, ok := As[InputFieldsFactory](bolt); ok {
+		if fields := factory.InputFields(comp, stream); fields != nil {
+			return fields
+		}
+	}
+	return bolt.Fields()
+}
@@ -0,0 +1,293 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package local
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AckHandler is informed of the outcome of a reliably emitted spout tuple.
+// Any gostorm.Spout satisfies this interface.
+type AckHandler interface {
+	Acked(id string)
+	Failed(id string)
+}
+
+// Acker tracks the tuple trees of reliably emitted spout tuples in the
+// same way as the Storm acker bolt does. A spout tuple is acked once
+// every tuple in its tree has been acked and failed as soon as any
+// tuple in its tree fails or when its tree has not completed within
+// the message timeout.
+//
+// An acker can be used concurrently. Handlers are informed after the
+// acker has been unlocked, so they may emit tuples that are tracked by
+// the same acker.
+type Acker struct {
+	lock    sync.Mutex
+	lastId  int64
+	roots   map[*ackRoot]struct{}
+	tuples  map[string]*ackTuple
+	timeout time.Duration
+	now     func() time.Time
+}
+
+type ackRoot struct {
+	msgId    string
+	handler  AckHandler
+	pending  int
+	deadline time.Time
+}
+
+type ackTuple struct {
+	roots []*ackRoot
+}
+
+// ackOutcome is the outcome of a tuple tree, of which the handler is
+// informed once the acker has been unlocked
+type ackOutcome struct {
+	root  *ackRoot
+	acked bool
+}
+
+func notify(outcomes []ackOutcome) {
+	for _, outcome := range outcomes {
+		if outcome.acked {
+			outcome.root.handler.Acked(outcome.root.msgId)
+		} else {
+			outcome.root.handler.Failed(outcome.root.msgId)
+		}
+	}
+}
+
+// NewAcker returns an acker without any pending tuple trees.
+// Message timeouts are disabled until a timeout is set.
+func NewAcker() *Acker {
+	return &Acker{
+		roots:  make(map[*ackRoot]struct{}),
+		tuples: make(map[string]*ackTuple),
+		now:    time.Now,
+	}
+}
+
+// MessageTimeout returns the message timeout configured by
+// topology.message.timeout.secs, which defaults to 30 seconds as in
+// Storm. A zero timeout is returned if topology.enable.message.timeouts
+// is false.
+func MessageTimeout(conf map[string]interface{}) time.Duration {
+	if enabled, ok := conf["topology.enable.message.timeouts"].(bool); ok && !enabled {
+		return 0
+	}
+	switch secs := conf["topology.message.timeout.secs"].(type) {
+	case int:
+		return time.Duration(secs) * time.Second
+	case int64:
+		return time.Duration(secs) * time.Second
+	case float64:
+		return time.Duration(secs * float64(time.Second))
+	}
+	return 30 * time.Second
+}
+
+// SetTimeout sets the time within which a tuple tree has to complete
+// before its spout tuple is failed. A zero timeout disables timeouts.
+func (this *Acker) SetTimeout(timeout time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.timeout = timeout
+}
+
+// Timeout returns the message timeout of the acker
+func (this *Acker) Timeout() time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.timeout
+}
+
+// SetClock replaces the clock used for message timeouts. This allows
+// tests to time out tuple trees without having to wait for them.
+func (this *Acker) SetClock(now func() time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.now = now
+}
+
+// Expire fails the spout tuples of all the trees that have not
+// completed within the message timeout and returns the number of
+// spout tuples that were failed.
+func (this *Acker) Expire() (expired int) {
+	this.lock.Lock()
+	outcomes := this.expire()
+	this.lock.Unlock()
+	notify(outcomes)
+	return len(outcomes)
+}
+
+func (this *Acker) expire() (outcomes []ackOutcome) {
+	if this.timeout <= 0 {
+		return nil
+	}
+	now := this.now()
+	var roots []*ackRoot
+	for root := range this.roots {
+		if !now.Before(root.deadline) {
+			roots = append(roots, root)
+		}
+	}
+	// Fail the oldest trees first
+	sort.Slice(roots, func(i, j int) bool { return roots[i].deadline.Before(roots[j].deadline) })
+	for _, root := range roots {
+		delete(this.roots, root)
+		outcomes = append(outcomes, ackOutcome{root: root})
+	}
+	return outcomes
+}
+
+func (this *Acker) newId() string {
+	this.lastId++
+	return strconv.FormatInt(this.lastId, 10)
+}
+
+// SpoutEmit starts a new tuple tree for the spout tuple with the given
+// message id and returns a tuple id for each of the deliveries of that
+// tuple. An empty message id denotes an unreliable emission, which is
+// not tracked. A reliable emission without any deliveries is acked
+// immediately, as Storm does.
+func (this *Acker) SpoutEmit(handler AckHandler, msgId string, deliveries int) (ids []string) {
+	if len(msgId) > 0 && deliveries == 0 {
+		handler.Acked(msgId)
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if len(msgId) == 0 {
+		return this.untracked(deliveries)
+	}
+
+	root := &ackRoot{
+		msgId:    msgId,
+		handler:  handler,
+		deadline: this.now().Add(this.timeout),
+	}
+	this.roots[root] = struct{}{}
+	return this.track([]*ackRoot{root}, deliveries)
+}
+
+// BoltEmit adds the deliveries of a bolt tuple to the tuple trees of
+// the given anchors and returns a tuple id for each delivery.
+// Anchors that are not part of a pending tuple tree are ignored.
+func (this *Acker) BoltEmit(anchors []string, deliveries int) (ids []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	var roots []*ackRoot
+	seen := make(map[*ackRoot]bool)
+	for _, anchor := range anchors {
+		tuple, ok := this.tuples[anchor]
+		if !ok {
+			continue
+		}
+		for _, root := range tuple.roots {
+			if _, pending := this.roots[root]; pending && !seen[root] {
+				seen[root] = true
+				roots = append(roots, root)
+			}
+		}
+	}
+	if len(roots) == 0 {
+		return this.untracked(deliveries)
+	}
+	return this.track(roots, deliveries)
+}
+
+func (this *Acker) untracked(deliveries int) (ids []string) {
+	for i := 0; i < deliveries; i++ {
+		ids = append(ids, this.newId())
+	}
+	return ids
+}
+
+func (this *Acker) track(roots []*ackRoot, deliveries int) (ids []string) {
+	for i := 0; i < deliveries; i++ {
+		id := this.newId()
+		this.tuples[id] = &ackTuple{roots: roots}
+		ids = append(ids, id)
+	}
+	for _, root := range roots {
+		root.pending += deliveries
+	}
+	return ids
+}
+
+// Ack acks the tuple with the given id. The spout tuples of all the
+// trees that have been completed by this ack are acked.
+func (this *Acker) Ack(id string) {
+	this.lock.Lock()
+	outcomes := this.ack(id)
+	this.lock.Unlock()
+	notify(outcomes)
+}
+
+func (this *Acker) ack(id string) (outcomes []ackOutcome) {
+	tuple, ok := this.tuples[id]
+	if !ok {
+		return nil
+	}
+	delete(this.tuples, id)
+	for _, root := range tuple.roots {
+		if _, pending := this.roots[root]; !pending {
+			continue
+		}
+		root.pending--
+		if root.pending == 0 {
+			delete(this.roots, root)
+			outcomes = append(outcomes, ackOutcome{root: root, acked: true})
+		}
+	}
+	return outcomes
+}
+
+// Fail fails the tuple with the given id and with it the spout tuples
+// of all the trees the tuple is part of.
+func (this *Acker) Fail(id string) {
+	this.lock.Lock()
+	outcomes := this.fail(id)
+	this.lock.Unlock()
+	notify(outcomes)
+}
+
+func (this *Acker) fail(id string) (outcomes []ackOutcome) {
+	tuple, ok := this.tuples[id]
+	if !ok {
+		return nil
+	}
+	delete(this.tuples, id)
+	for _, root := range tuple.roots {
+		if _, pending := this.roots[root]; !pending {
+			continue
+		}
+		delete(this.roots, root)
+		outcomes = append(outcomes, ackOutcome{root: root})
+	}
+	return outcomes
+}
+
+// Pending returns the number of spout tuples whose trees have not yet completed
+func (this *Acker) Pending() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.roots)
+}
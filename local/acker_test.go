@@ -0,0 +1,136 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package local
+
+import (
+	"testing"
+	"time"
+)
+
+type outcomeRecorder struct {
+	acked  []string
+	failed []string
+}
+
+func (this *outcomeRecorder) Acked(id string) {
+	this.acked = append(this.acked, id)
+}
+
+func (this *outcomeRecorder) Failed(id string) {
+	this.failed = append(this.failed, id)
+}
+
+func TestAckTree(t *testing.T) {
+	recorder := &outcomeRecorder{}
+	acker := NewAcker()
+
+	roots := acker.SpoutEmit(recorder, "msg", 2)
+	children := acker.BoltEmit(roots[:1], 2)
+	acker.Ack(roots[0])
+	acker.Ack(children[0])
+	acker.Ack(roots[1])
+	if len(recorder.acked) != 0 {
+		t.Fatalf("Tuple acked before its tree completed: %v", recorder.acked)
+	}
+	acker.Ack(children[1])
+	if len(recorder.acked) != 1 || recorder.acked[0] != "msg" || acker.Pending() != 0 {
+		t.Fatalf("Tuple not acked after its tree completed: %v", recorder.acked)
+	}
+}
+
+func TestJoinedTrees(t *testing.T) {
+	recorder := &outcomeRecorder{}
+	acker := NewAcker()
+
+	first := acker.SpoutEmit(recorder, "first", 1)
+	second := acker.SpoutEmit(recorder, "second", 1)
+	joined := acker.BoltEmit([]string{first[0], second[0]}, 1)
+	acker.Ack(first[0])
+	acker.Ack(second[0])
+	acker.Fail(joined[0])
+	if len(recorder.failed) != 2 || len(recorder.acked) != 0 {
+		t.Fatalf("Expected both joined tuples to fail, acked: %v, failed: %v", recorder.acked, recorder.failed)
+	}
+}
+
+func TestUnreliableEmit(t *testing.T) {
+	recorder := &outcomeRecorder{}
+	acker := NewAcker()
+
+	ids := acker.SpoutEmit(recorder, "", 1)
+	acker.Ack(ids[0])
+	acker.SpoutEmit(recorder, "unrouted", 0)
+	if len(recorder.acked) != 1 || recorder.acked[0] != "unrouted" || acker.Pending() != 0 {
+		t.Fatalf("Only the unrouted reliable tuple should be acked: %v", recorder.acked)
+	}
+}
+
+func TestMessageTimeout(t *testing.T) {
+	recorder := &outcomeRecorder{}
+	acker := NewAcker()
+	acker.SetTimeout(MessageTimeout(map[string]interface{}{"topology.message.timeout.secs": 3}))
+	now := time.Unix(0, 0)
+	acker.SetClock(func() time.Time { return now })
+
+	ids := acker.SpoutEmit(recorder, "slow", 1)
+	now = now.Add(2 * time.Second)
+	if acker.Expire() != 0 {
+		t.Fatal("Tuple expired before its timeout")
+	}
+	now = now.Add(time.Second)
+	if acker.Expire() != 1 || len(recorder.failed) != 1 || recorder.failed[0] != "slow" {
+		t.Fatalf("Expected tuple to time out, failed: %v", recorder.failed)
+	}
+	acker.Ack(ids[0])
+	if len(recorder.acked) != 0 {
+		t.Fatal("Timed out tuple acked")
+	}
+}
+
+// replayHandler emits a failed tuple again, as a spout that replays tuples does
+type replayHandler struct {
+	outcomeRecorder
+	acker *Acker
+}
+
+func (this *replayHandler) Failed(id string) {
+	this.outcomeRecorder.Failed(id)
+	this.acker.SpoutEmit(this, id, 1)
+}
+
+func TestReplayOnFail(t *testing.T) {
+	acker := NewAcker()
+	handler := &replayHandler{acker: acker}
+
+	// Handlers are informed without holding the lock of the acker
+	ids := acker.SpoutEmit(handler, "replayed", 1)
+	acker.Fail(ids[0])
+	if len(handler.failed) != 1 || acker.Pending() != 1 {
+		t.Fatalf("Expected the failed tuple to be replayed, failed: %v, pending: %d", handler.failed, acker.Pending())
+	}
+}
+
+func TestMessageTimeoutConf(t *testing.T) {
+	if MessageTimeout(nil) != 30*time.Second {
+		t.Fatal("Default message timeout should be 30 seconds")
+	}
+	conf := map[string]interface{}{
+		"topology.message.timeout.secs":    10,
+		"topology.enable.message.timeouts": false,
+	}
+	if MessageTimeout(conf) != 0 {
+		t.Fatal("Message timeouts should be disabled")
+	}
+}
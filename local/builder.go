@@ -0,0 +1,154 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package local runs GoStorm spouts and bolts as a topology inside a
+// single process, without requiring a Storm cluster.
+package local
+
+import (
+	"fmt"
+
+	"github.com/jsgilmore/gostorm"
+)
+
+// SpoutFactory creates a new instance of a spout for every spout task
+type SpoutFactory func() gostorm.Spout
+
+// BoltFactory creates a new instance of a bolt for every bolt task
+type BoltFactory func() gostorm.Bolt
+
+// BoltDeclarer declares the input streams of a bolt and how their
+// tuples are grouped over the tasks of the bolt.
+// A stream value of "" or "default" can be used to denote the default stream.
+type BoltDeclarer interface {
+	// ShuffleGrouping distributes tuples evenly over the tasks of the bolt
+	ShuffleGrouping(componentId, streamId string) BoltDeclarer
+	// FieldsGrouping sends tuples with equal values at the given field
+	// indices to the same task
+	FieldsGrouping(componentId, streamId string, fields ...int) BoltDeclarer
+	// AllGrouping replicates tuples to all the tasks of the bolt
+	AllGrouping(componentId, streamId string) BoltDeclarer
+	// DirectGrouping lets the emitter decide which task receives a tuple
+	DirectGrouping(componentId, streamId string) BoltDeclarer
+}
+
+type spoutSpec struct {
+	id          string
+	factory     SpoutFactory
+	parallelism int
+}
+
+type subscription struct {
+	componentId string
+	streamId    string
+	grouping    grouping
+}
+
+type boltSpec struct {
+	id          string
+	factory     BoltFactory
+	parallelism int
+	inputs      []*subscription
+}
+
+func (this *boltSpec) subscribe(componentId, streamId string, grouping grouping) BoltDeclarer {
+	this.inputs = append(this.inputs, &subscription{
+		componentId: componentId,
+		streamId:    normaliseStream(streamId),
+		grouping:    grouping,
+	})
+	return this
+}
+
+func (this *boltSpec) ShuffleGrouping(componentId, streamId string) BoltDeclarer {
+	return this.subscribe(componentId, streamId, &shuffleGrouping{})
+}
+
+func (this *boltSpec) FieldsGrouping(componentId, streamId string, fields ...int) BoltDeclarer {
+	return this.subscribe(componentId, streamId, &fieldsGrouping{fields: fields})
+}
+
+func (this *boltSpec) AllGrouping(componentId, streamId string) BoltDeclarer {
+	return this.subscribe(componentId, streamId, &allGrouping{})
+}
+
+func (this *boltSpec) DirectGrouping(componentId, streamId string) BoltDeclarer {
+	return this.subscribe(componentId, streamId, &directGrouping{})
+}
+
+// TopologyBuilder wires spouts and bolts together into a local topology
+type TopologyBuilder struct {
+	spouts []*spoutSpec
+	bolts  []*boltSpec
+	ids    map[string]bool
+}
+
+func NewTopologyBuilder() *TopologyBuilder {
+	return &TopologyBuilder{
+		ids: make(map[string]bool),
+	}
+}
+
+func (this *TopologyBuilder) checkId(id string, parallelism int) {
+	if this.ids[id] {
+		panic(fmt.Sprintf("Local: Component id already declared: %s", id))
+	}
+	if parallelism < 1 {
+		panic(fmt.Sprintf("Local: Invalid parallelism for %s: %d", id, parallelism))
+	}
+	this.ids[id] = true
+}
+
+// SetSpout adds a spout with the given number of tasks to the topology
+func (this *TopologyBuilder) SetSpout(id string, factory SpoutFactory, parallelism int) {
+	this.checkId(id, parallelism)
+	this.spouts = append(this.spouts, &spoutSpec{
+		id:          id,
+		factory:     factory,
+		parallelism: parallelism,
+	})
+}
+
+// SetBolt adds a bolt with the given number of tasks to the topology.
+// The returned declarer is used to subscribe the bolt to its input streams.
+func (this *TopologyBuilder) SetBolt(id string, factory BoltFactory, parallelism int) BoltDeclarer {
+	this.checkId(id, parallelism)
+	bolt := &boltSpec{
+		id:          id,
+		factory:     factory,
+		parallelism: parallelism,
+	}
+	this.bolts = append(this.bolts, bolt)
+	return bolt
+}
+
+// CreateTopology instantiates the tasks of all the declared components.
+// The configuration is made available to the components through their context.
+func (this *TopologyBuilder) CreateTopology(conf map[string]interface{}) *Topology {
+	for _, bolt := range this.bolts {
+		for _, input := range bolt.inputs {
+			if !this.ids[input.componentId] {
+				panic(fmt.Sprintf("Local: Bolt %s subscribes to unknown component %s", bolt.id, input.componentId))
+			}
+		}
+	}
+	return newTopology(this.spouts, this.bolts, conf)
+}
+
+func normaliseStream(streamId string) string {
+	if len(streamId) == 0 {
+		return "default"
+	}
+	return streamId
+}
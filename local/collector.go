@@ -0,0 +1,187 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package local
+
+import (
+	"log"
+
+	"github.com/jsgilmore/gostorm"
+	"github.com/jsgilmore/gostorm/core"
+)
+
+// newSpoutCollector returns the collector of a spout task, which
+// validates emissions if the spout declares its outputs
+func newSpoutCollector(topology *Topology, task *spoutTask) gostorm.SpoutOutputCollector {
+	var collector gostorm.SpoutOutputCollector = &spoutCollector{
+		topology: topology,
+		task:     task,
+	}
+	if schema := gostorm.DeclareOutputSchema(task.spout); schema != nil {
+		collector = gostorm.NewCheckedSpoutOutputCollector(collector, schema)
+	}
+	return collector
+}
+
+type spoutCollector struct {
+	topology *Topology
+	task     *spoutTask
+}
+
+func (this *spoutCollector) Log(msg string) {
+	log.Printf("%s:%d: %s", this.task.componentId, this.task.id, msg)
+}
+
+func (this *spoutCollector) logLevel(level core.LogLevel, msg string) {
+	log.Printf("%s:%d: %s %s", this.task.componentId, this.task.id, level, msg)
+}
+
+func (this *spoutCollector) Debug(msg string) {
+	this.logLevel(core.LogDebug, msg)
+}
+
+func (this *spoutCollector) Info(msg string) {
+	this.logLevel(core.LogInfo, msg)
+}
+
+func (this *spoutCollector) Warn(msg string) {
+	this.logLevel(core.LogWarn, msg)
+}
+
+func (this *spoutCollector) Error(msg string) {
+	this.logLevel(core.LogError, msg)
+}
+
+func (this *spoutCollector) Metric(name string, value interface{}) {
+	logMetric(this.task.componentId, this.task.id, name, value)
+}
+
+func (this *spoutCollector) RegisterMetric(name string, metric core.Metric) {
+	this.task.metrics.Register(name, metric)
+}
+
+func (this *spoutCollector) ReportError(err error) {
+	if err != nil {
+		log.Printf("%s:%d: error: %v", this.task.componentId, this.task.id, err)
+	}
+}
+
+func (this *spoutCollector) Emit(id string, stream string, fields ...interface{}) (taskIds []int32) {
+	return this.emit(id, stream, -1, fields)
+}
+
+// EmitWithTaskIds emits like Emit, which always returns task ids in local mode
+func (this *spoutCollector) EmitWithTaskIds(id string, stream string, fields ...interface{}) (taskIds []int32) {
+	return this.Emit(id, stream, fields...)
+}
+
+func (this *spoutCollector) EmitDirect(id string, stream string, directTask int64, fields ...interface{}) {
+	this.emit(id, stream, directTask, fields)
+}
+
+func (this *spoutCollector) emit(id string, stream string, directTask int64, fields []interface{}) (taskIds []int32) {
+	fields = core.TupleValues(fields)
+	tasks := this.topology.route(this.task.componentId, stream, directTask, fields)
+	ids := this.topology.acker.SpoutEmit(this.task, id, len(tasks))
+	return this.topology.deliver(this.task.id, stream, tasks, ids, fields)
+}
+
+func logMetric(componentId string, taskId int32, name string, value interface{}) error {
+	log.Printf("%s:%d: metric %s: %v", componentId, taskId, name, value)
+	return nil
+}
+
+// newBoltCollector returns the collector of a bolt task, which
+// validates emissions if the bolt declares its outputs
+func newBoltCollector(topology *Topology, task *boltTask) gostorm.OutputCollector {
+	var collector gostorm.OutputCollector = &boltCollector{
+		topology: topology,
+		task:     task,
+	}
+	if schema := gostorm.DeclareOutputSchema(task.bolt); schema != nil {
+		collector = gostorm.NewCheckedOutputCollector(collector, schema)
+	}
+	return collector
+}
+
+type boltCollector struct {
+	topology *Topology
+	task     *boltTask
+}
+
+func (this *boltCollector) Log(msg string) {
+	log.Printf("%s:%d: %s", this.task.componentId, this.task.id, msg)
+}
+
+func (this *boltCollector) logLevel(level core.LogLevel, msg string) {
+	log.Printf("%s:%d: %s %s", this.task.componentId, this.task.id, level, msg)
+}
+
+func (this *boltCollector) Debug(msg string) {
+	this.logLevel(core.LogDebug, msg)
+}
+
+func (this *boltCollector) Info(msg string) {
+	this.logLevel(core.LogInfo, msg)
+}
+
+func (this *boltCollector) Warn(msg string) {
+	this.logLevel(core.LogWarn, msg)
+}
+
+func (this *boltCollector) Error(msg string) {
+	this.logLevel(core.LogError, msg)
+}
+
+func (this *boltCollector) Metric(name string, value interface{}) {
+	logMetric(this.task.componentId, this.task.id, name, value)
+}
+
+func (this *boltCollector) RegisterMetric(name string, metric core.Metric) {
+	this.task.metrics.Register(name, metric)
+}
+
+func (this *boltCollector) ReportError(err error) {
+	if err != nil {
+		log.Printf("%s:%d: error: %v", this.task.componentId, this.task.id, err)
+	}
+}
+
+func (this *boltCollector) SendAck(id string) {
+	this.topology.acker.Ack(id)
+}
+
+func (this *boltCollector) SendFail(id string) {
+	this.topology.acker.Fail(id)
+}
+
+func (this *boltCollector) Emit(anchors []string, stream string, fields ...interface{}) (taskIds []int32) {
+	return this.emit(anchors, stream, -1, fields)
+}
+
+// EmitWithTaskIds emits like Emit, which always returns task ids in local mode
+func (this *boltCollector) EmitWithTaskIds(anchors []string, stream string, fields ...interface{}) (taskIds []int32) {
+	return this.Emit(anchors, stream, fields...)
+}
+
+func (this *boltCollector) EmitDirect(anchors []string, stream string, directTask int64, fields ...interface{}) {
+	this.emit(anchors, stream, directTask, fields)
+}
+
+func (this *boltCollector) emit(anchors []string, stream string, directTask int64, fields []interface{}) (taskIds []int32) {
+	fields = core.TupleValues(fields)
+	tasks := this.topology.route(this.task.componentId, stream, directTask, fields)
+	ids := this.topology.acker.BoltEmit(anchors, len(tasks))
+	return this.topology.deliver(this.task.id, stream, tasks, ids, fields)
+}
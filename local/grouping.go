@@ -0,0 +1,94 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+)
+
+// grouping decides which of the tasks of a subscribing bolt receive a tuple
+type grouping interface {
+	chooseTasks(tasks []*boltTask, values []interface{}) []*boltTask
+	isDirect() bool
+}
+
+// shuffleGrouping distributes tuples evenly over the tasks of a bolt.
+// Tasks are chosen round robin, which gives the same distribution as
+// Storm's random shuffle, but is deterministic.
+type shuffleGrouping struct {
+	next int
+}
+
+func (this *shuffleGrouping) chooseTasks(tasks []*boltTask, values []interface{}) []*boltTask {
+	task := tasks[this.next%len(tasks)]
+	this.next++
+	return []*boltTask{task}
+}
+
+func (this *shuffleGrouping) isDirect() bool {
+	return false
+}
+
+// fieldsGrouping sends tuples with equal values for the grouped fields
+// to the same task. Values are compared by their JSON encoding, which
+// is how they would be sent to Storm, so that pointers to equal values
+// are grouped together.
+type fieldsGrouping struct {
+	fields []int
+}
+
+func (this *fieldsGrouping) chooseTasks(tasks []*boltTask, values []interface{}) []*boltTask {
+	hash := fnv.New32a()
+	for _, field := range this.fields {
+		if field < 0 || field >= len(values) {
+			panic(fmt.Sprintf("Local: Fields grouping on field %d of a tuple with %d fields", field, len(values)))
+		}
+		data, err := json.Marshal(values[field])
+		if err != nil {
+			panic(fmt.Sprintf("Local: Marshalling grouped field %d (%T): %v", field, values[field], err))
+		}
+		hash.Write(data)
+		hash.Write([]byte{0})
+	}
+	return []*boltTask{tasks[hash.Sum32()%uint32(len(tasks))]}
+}
+
+func (this *fieldsGrouping) isDirect() bool {
+	return false
+}
+
+// allGrouping replicates tuples to all the tasks of a bolt
+type allGrouping struct{}
+
+func (this *allGrouping) chooseTasks(tasks []*boltTask, values []interface{}) []*boltTask {
+	return tasks
+}
+
+func (this *allGrouping) isDirect() bool {
+	return false
+}
+
+// directGrouping only receives tuples that were emitted directly to one of its tasks
+type directGrouping struct{}
+
+func (this *directGrouping) chooseTasks(tasks []*boltTask, values []interface{}) []*boltTask {
+	return nil
+}
+
+func (this *directGrouping) isDirect() bool {
+	return true
+}
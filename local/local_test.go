@@ -0,0 +1,428 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package local
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jsgilmore/gostorm"
+	"github.com/jsgilmore/gostorm/messages"
+)
+
+var sentences = []string{
+	"Call me Ishmael",
+	"It was the best of times it was the worst of times",
+	"In the beginning God created the heavens and the earth",
+}
+
+type sentenceSpout struct {
+	collector gostorm.SpoutOutputCollector
+	next      int
+	acked     []string
+	failed    []string
+}
+
+func (this *sentenceSpout) Open(context *messages.Context, collector gostorm.SpoutOutputCollector) {
+	this.collector = collector
+}
+
+func (this *sentenceSpout) NextTuple() {
+	if this.next < len(sentences) {
+		this.collector.Emit(fmt.Sprintf("%d", this.next), "", sentences[this.next])
+		this.next++
+	}
+}
+
+func (this *sentenceSpout) Acked(id string) {
+	this.acked = append(this.acked, id)
+}
+
+func (this *sentenceSpout) Failed(id string) {
+	this.failed = append(this.failed, id)
+}
+
+func (this *sentenceSpout) Exit() {}
+
+type splitBolt struct {
+	collector gostorm.OutputCollector
+	failWord  string
+}
+
+func (this *splitBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *splitBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	sentence := *fields[0].(*string)
+	for _, word := range strings.Split(sentence, " ") {
+		this.collector.Emit([]string{meta.Id}, "", word, 1)
+	}
+	if len(this.failWord) > 0 && strings.Contains(sentence, this.failWord) {
+		this.collector.SendFail(meta.Id)
+		return
+	}
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *splitBolt) Cleanup() {}
+
+func (this *splitBolt) Fields() []interface{} {
+	var sentence string
+	return []interface{}{&sentence}
+}
+
+type countBolt struct {
+	collector gostorm.OutputCollector
+	taskId    int64
+	counts    map[string]int
+	tasks     map[string]int64
+	cleaned   bool
+}
+
+func (this *countBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	this.taskId = context.Topology.TaskId
+}
+
+func (this *countBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	word := *fields[0].(*string)
+	this.counts[word] += *fields[1].(*int)
+	this.tasks[word] = this.taskId
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *countBolt) Cleanup() {
+	this.cleaned = true
+}
+
+func (this *countBolt) Fields() []interface{} {
+	var word string
+	var count int
+	return []interface{}{&word, &count}
+}
+
+func wordCountTopology(failWord string) (*Topology, *sentenceSpout, []*countBolt) {
+	spout := &sentenceSpout{}
+	counts := make(map[string]int)
+	tasks := make(map[string]int64)
+	var counters []*countBolt
+
+	builder := NewTopologyBuilder()
+	builder.SetSpout("spout", func() gostorm.Spout { return spout }, 1)
+	builder.SetBolt("split", func() gostorm.Bolt { return &splitBolt{failWord: failWord} }, 2).
+		ShuffleGrouping("spout", "")
+	builder.SetBolt("count", func() gostorm.Bolt {
+		counter := &countBolt{counts: counts, tasks: tasks}
+		counters = append(counters, counter)
+		return counter
+	}, 3).FieldsGrouping("split", "default", 0)
+	return builder.CreateTopology(nil), spout, counters
+}
+
+func TestWordCount(t *testing.T) {
+	topology, spout, counters := wordCountTopology("")
+	topology.Run()
+
+	if len(spout.acked) != len(sentences) || len(spout.failed) != 0 {
+		t.Fatalf("Expected all %d sentences to be acked, acked: %v, failed: %v", len(sentences), spout.acked, spout.failed)
+	}
+	if topology.Pending() != 0 {
+		t.Fatalf("Expected no pending tuples, %d pending", topology.Pending())
+	}
+
+	counter := counters[0]
+	if counter.counts["times"] != 2 || counter.counts["the"] != 5 || counter.counts["Ishmael"] != 1 {
+		t.Fatalf("Unexpected word counts: %v", counter.counts)
+	}
+	for _, c := range counters {
+		if !c.cleaned {
+			t.Fatal("Bolt was not cleaned up")
+		}
+	}
+}
+
+func TestFieldsGrouping(t *testing.T) {
+	spout := &sentenceSpout{}
+	var counters []*countBolt
+	builder := NewTopologyBuilder()
+	builder.SetSpout("spout", func() gostorm.Spout { return spout }, 1)
+	builder.SetBolt("split", func() gostorm.Bolt { return &splitBolt{} }, 1).ShuffleGrouping("spout", "")
+	builder.SetBolt("count", func() gostorm.Bolt {
+		counter := &countBolt{counts: make(map[string]int), tasks: make(map[string]int64)}
+		counters = append(counters, counter)
+		return counter
+	}, 4).FieldsGrouping("split", "", 0)
+	builder.CreateTopology(nil).Run()
+
+	seen := make(map[string]int64)
+	for _, counter := range counters {
+		for word := range counter.counts {
+			if task, ok := seen[word]; ok {
+				t.Fatalf("Word %s was counted by task %d and task %d", word, task, counter.taskId)
+			}
+			seen[word] = counter.taskId
+		}
+	}
+}
+
+func TestFieldsGroupingPointers(t *testing.T) {
+	tasks := []*boltTask{{id: 1}, {id: 2}, {id: 3}, {id: 4}}
+	grouping := &fieldsGrouping{fields: []int{0}}
+	for _, word := range strings.Split(sentences[2], " ") {
+		byValue := grouping.chooseTasks(tasks, []interface{}{word})
+		// Pointers to equal values are grouped by the values they point to
+		first, second := word, word
+		for _, value := range []*string{&first, &second} {
+			if byPointer := grouping.chooseTasks(tasks, []interface{}{value}); byPointer[0] != byValue[0] {
+				t.Fatalf("Word %s was sent to task %d by pointer and to task %d by value", word, byPointer[0].id, byValue[0].id)
+			}
+		}
+	}
+}
+
+func TestFailedTree(t *testing.T) {
+	topology, spout, _ := wordCountTopology("best")
+	topology.Run()
+
+	if len(spout.failed) != 1 || spout.failed[0] != "1" {
+		t.Fatalf("Expected sentence 1 to fail, failed: %v", spout.failed)
+	}
+	if len(spout.acked) != len(sentences)-1 {
+		t.Fatalf("Expected %d acked sentences, acked: %v", len(sentences)-1, spout.acked)
+	}
+}
+
+type recordBolt struct {
+	collector gostorm.OutputCollector
+	taskId    int64
+	received  map[int64][]string
+}
+
+func (this *recordBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	this.taskId = context.Topology.TaskId
+}
+
+func (this *recordBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.received[this.taskId] = append(this.received[this.taskId], *fields[0].(*string))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *recordBolt) Cleanup() {}
+
+func (this *recordBolt) Fields() []interface{} {
+	var word string
+	return []interface{}{&word}
+}
+
+type directBolt struct {
+	collector gostorm.OutputCollector
+	target    int64
+}
+
+func (this *directBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	for _, mapping := range context.Topology.TaskComponentMappings {
+		if mapping.Component == "direct" {
+			fmt.Sscanf(mapping.Task, "%d", &this.target)
+		}
+	}
+}
+
+func (this *directBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.collector.EmitDirect([]string{meta.Id}, "targeted", this.target, *fields[0].(*string))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *directBolt) Cleanup() {}
+
+func (this *directBolt) Fields() []interface{} {
+	var sentence string
+	return []interface{}{&sentence}
+}
+
+func TestAllAndDirectGrouping(t *testing.T) {
+	spout := &sentenceSpout{}
+	all := make(map[int64][]string)
+	direct := make(map[int64][]string)
+
+	builder := NewTopologyBuilder()
+	builder.SetSpout("spout", func() gostorm.Spout { return spout }, 1)
+	builder.SetBolt("all", func() gostorm.Bolt { return &recordBolt{received: all} }, 2).AllGrouping("spout", "")
+	builder.SetBolt("director", func() gostorm.Bolt { return &directBolt{} }, 1).ShuffleGrouping("spout", "")
+	builder.SetBolt("direct", func() gostorm.Bolt { return &recordBolt{received: direct} }, 3).DirectGrouping("director", "targeted")
+	builder.CreateTopology(nil).Run()
+
+	if len(all) != 2 {
+		t.Fatalf("Expected tuples at 2 tasks, received at: %v", all)
+	}
+	for task, received := range all {
+		if len(received) != len(sentences) {
+			t.Fatalf("Task %d received %d instead of %d tuples", task, len(received), len(sentences))
+		}
+	}
+	if len(direct) != 1 {
+		t.Fatalf("Expected direct tuples at a single task, received at: %v", direct)
+	}
+	if len(spout.acked) != len(sentences) {
+		t.Fatalf("Expected all sentences to be acked, acked: %v", spout.acked)
+	}
+}
+
+type activatedSpout struct {
+	sentenceSpout
+	active bool
+}
+
+func (this *activatedSpout) Activate() {
+	this.active = true
+}
+
+func (this *activatedSpout) Deactivate() {
+	this.active = false
+}
+
+func TestDeactivate(t *testing.T) {
+	spout := &activatedSpout{}
+	builder := NewTopologyBuilder()
+	builder.SetSpout("spout", func() gostorm.Spout { return spout }, 1)
+	builder.SetBolt("split", func() gostorm.Bolt { return &splitBolt{} }, 1).ShuffleGrouping("spout", "")
+	topology := builder.CreateTopology(nil)
+
+	topology.Step()
+	if !spout.active || spout.next != 1 {
+		t.Fatalf("Expected an active spout that emitted once, active: %v, emitted: %d", spout.active, spout.next)
+	}
+
+	topology.Deactivate()
+	if topology.Step() || spout.active || spout.next != 1 {
+		t.Fatalf("Expected an inactive spout that did not emit, active: %v, emitted: %d", spout.active, spout.next)
+	}
+
+	topology.Activate()
+	topology.Run()
+	if !spout.active || len(spout.acked) != len(sentences) {
+		t.Fatalf("Expected an active spout with all sentences acked, active: %v, acked: %v", spout.active, spout.acked)
+	}
+}
+
+type tickBolt struct {
+	recordBolt
+	ticks int
+}
+
+func (this *tickBolt) Tick(meta messages.BoltMsgMeta) {
+	this.ticks++
+	this.collector.Emit(nil, "", "tick")
+}
+
+func TestTick(t *testing.T) {
+	received := make(map[int64][]string)
+	ticker := &tickBolt{}
+	builder := NewTopologyBuilder()
+	builder.SetBolt("ticker", func() gostorm.Bolt { return ticker }, 1)
+	builder.SetBolt("record", func() gostorm.Bolt { return &recordBolt{received: received} }, 1).ShuffleGrouping("ticker", "")
+	topology := builder.CreateTopology(nil)
+
+	topology.Tick()
+	topology.Tick()
+	if ticker.ticks != 2 {
+		t.Fatalf("Expected 2 ticks, received: %d", ticker.ticks)
+	}
+	for _, words := range received {
+		if len(words) != 2 {
+			t.Fatalf("Expected 2 tuples emitted on tick, received: %v", words)
+		}
+	}
+	topology.Shutdown()
+}
+
+type wordCount struct {
+	Word  string `storm:"0"`
+	Count int    `storm:"1"`
+}
+
+type typedCountBolt struct {
+	collector gostorm.OutputCollector
+	received  []wordCount
+}
+
+func (this *typedCountBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *typedCountBolt) Execute(meta messages.BoltMsgMeta, in wordCount) {
+	this.received = append(this.received, in)
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *typedCountBolt) Cleanup() {}
+
+func TestTypedBoltFieldMismatch(t *testing.T) {
+	spout := &sentenceSpout{}
+	counter := &typedCountBolt{}
+	builder := NewTopologyBuilder()
+	builder.SetSpout("spout", func() gostorm.Spout { return spout }, 1)
+	// The sentences have a single field, while the typed bolt expects two
+	builder.SetBolt("count", func() gostorm.Bolt { return gostorm.NewTypedBolt[wordCount](counter) }, 1).
+		ShuffleGrouping("spout", "")
+	topology := builder.CreateTopology(nil)
+	topology.Run()
+
+	if len(counter.received) != 0 {
+		t.Fatalf("Unexpected tuples: %v", counter.received)
+	}
+	if len(spout.failed) != len(sentences) {
+		t.Fatalf("Expected every tuple to fail, failed: %v", spout.failed)
+	}
+}
+
+type declaredBolt struct {
+	splitBolt
+	errs []error
+}
+
+func (this *declaredBolt) DeclareOutputs(schema *gostorm.OutputSchema) {
+	schema.Declare("", "")
+}
+
+func (this *declaredBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	checked := this.collector.(gostorm.CheckedOutputCollector)
+	if _, err := checked.CheckedEmit([]string{meta.Id}, "", *fields[0].(*string), 1); err != nil {
+		this.errs = append(this.errs, err)
+	}
+	this.collector.SendAck(meta.Id)
+}
+
+func TestDeclaredOutputs(t *testing.T) {
+	spout := &sentenceSpout{}
+	bolt := &declaredBolt{}
+	builder := NewTopologyBuilder()
+	builder.SetSpout("spout", func() gostorm.Spout { return spout }, 1)
+	builder.SetBolt("declared", func() gostorm.Bolt { return bolt }, 1).ShuffleGrouping("spout", "")
+	builder.CreateTopology(nil).Run()
+
+	if len(bolt.errs) != len(sentences) {
+		t.Fatalf("Expected an error for each of the %d emissions, received: %v", len(sentences), bolt.errs)
+	}
+	if _, ok := bolt.errs[0].(*gostorm.SchemaError); !ok {
+		t.Fatalf("Expected a schema error, received: %v", bolt.errs[0])
+	}
+	if len(spout.acked) != len(sentences) {
+		t.Fatalf("Expected all sentences to be acked, acked: %v", spout.acked)
+	}
+}
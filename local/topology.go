@@ -0,0 +1,442 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package local
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jsgilmore/gostorm"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/messages"
+)
+
+type spoutTask struct {
+	id          int32
+	componentId string
+	spout       gostorm.Spout
+	topology    *Topology
+	metrics     core.MetricRegistry
+}
+
+// Acked queues the ack, so that the spout is only informed once the
+// current bolt or spout function has returned.
+func (this *spoutTask) Acked(id string) {
+	this.topology.outcomes = append(this.topology.outcomes, &outcome{task: this, msgId: id, acked: true})
+}
+
+// Failed queues the failure, so that the spout is only informed once
+// the current bolt or spout function has returned.
+func (this *spoutTask) Failed(id string) {
+	this.topology.outcomes = append(this.topology.outcomes, &outcome{task: this, msgId: id, acked: false})
+}
+
+type boltTask struct {
+	id          int32
+	componentId string
+	bolt        gostorm.Bolt
+	metrics     core.MetricRegistry
+}
+
+type outcome struct {
+	task  *spoutTask
+	msgId string
+	acked bool
+}
+
+type delivery struct {
+	task   *boltTask
+	meta   messages.BoltMsgMeta
+	values []interface{}
+}
+
+type streamKey struct {
+	componentId string
+	streamId    string
+}
+
+type subscriber struct {
+	tasks    []*boltTask
+	grouping grouping
+}
+
+// Topology is a set of spout and bolt tasks running in a single process.
+// Like Storm, the topology never calls the functions of a single
+// component concurrently. In fact, all components are driven from the
+// goroutine that calls Step or Run.
+type Topology struct {
+	conf        map[string]interface{}
+	spouts      []*spoutTask
+	bolts       []*boltTask
+	tasks       map[int32]string
+	subscribers map[streamKey][]*subscriber
+	acker       *Acker
+	deliveries  *list.List
+	outcomes    []*outcome
+	opened      bool
+	closed      bool
+	busy        bool
+	deactivated bool
+}
+
+func newTopology(spoutSpecs []*spoutSpec, boltSpecs []*boltSpec, conf map[string]interface{}) *Topology {
+	this := &Topology{
+		conf:        conf,
+		tasks:       make(map[int32]string),
+		subscribers: make(map[streamKey][]*subscriber),
+		acker:       NewAcker(),
+		deliveries:  list.New(),
+	}
+	this.acker.SetTimeout(MessageTimeout(conf))
+
+	// Storm assigns task ids to components in the order of their ids
+	type component struct {
+		id    string
+		spout *spoutSpec
+		bolt  *boltSpec
+	}
+	var components []component
+	for _, spec := range spoutSpecs {
+		components = append(components, component{id: spec.id, spout: spec})
+	}
+	for _, spec := range boltSpecs {
+		components = append(components, component{id: spec.id, bolt: spec})
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i].id < components[j].id })
+
+	boltTasks := make(map[string][]*boltTask)
+	taskId := int32(1)
+	for _, comp := range components {
+		if comp.spout != nil {
+			for i := 0; i < comp.spout.parallelism; i++ {
+				this.spouts = append(this.spouts, &spoutTask{
+					id:          taskId,
+					componentId: comp.id,
+					spout:       comp.spout.factory(),
+					topology:    this,
+				})
+				this.tasks[taskId] = comp.id
+				taskId++
+			}
+		} else {
+			for i := 0; i < comp.bolt.parallelism; i++ {
+				task := &boltTask{
+					id:          taskId,
+					componentId: comp.id,
+					bolt:        comp.bolt.factory(),
+				}
+				this.bolts = append(this.bolts, task)
+				boltTasks[comp.id] = append(boltTasks[comp.id], task)
+				this.tasks[taskId] = comp.id
+				taskId++
+			}
+		}
+	}
+
+	for _, spec := range boltSpecs {
+		for _, input := range spec.inputs {
+			key := streamKey{componentId: input.componentId, streamId: input.streamId}
+			this.subscribers[key] = append(this.subscribers[key], &subscriber{
+				tasks:    boltTasks[spec.id],
+				grouping: input.grouping,
+			})
+		}
+	}
+	return this
+}
+
+func (this *Topology) context(taskId int32) *messages.Context {
+	context := &messages.Context{
+		Topology: &messages.Topology{
+			TaskId: int64(taskId),
+		},
+	}
+	var taskIds []int
+	for id := range this.tasks {
+		taskIds = append(taskIds, int(id))
+	}
+	sort.Ints(taskIds)
+	for _, id := range taskIds {
+		context.Topology.TaskComponentMappings = append(context.Topology.TaskComponentMappings, &messages.TaskComponentMapping{
+			Task:      strconv.Itoa(id),
+			Component: this.tasks[int32(id)],
+		})
+	}
+
+	var keys []string
+	for key := range this.conf {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		context.Confs = append(context.Confs, &messages.Conf{
+			Key:   key,
+			Value: fmt.Sprintf("%v", this.conf[key]),
+		})
+	}
+	return context
+}
+
+// Open prepares all bolts and opens and activates all spouts.
+// Open is called by Step and Run if it has not been called yet.
+func (this *Topology) Open() {
+	if this.opened {
+		return
+	}
+	this.opened = true
+	for _, task := range this.bolts {
+		task.bolt.Prepare(this.context(task.id), newBoltCollector(this, task))
+	}
+	for _, task := range this.spouts {
+		task.spout.Open(this.context(task.id), newSpoutCollector(this, task))
+	}
+	if !this.deactivated {
+		this.activate()
+	}
+}
+
+func (this *Topology) activate() {
+	for _, task := range this.spouts {
+		if activator, ok := gostorm.As[gostorm.Activator](task.spout); ok {
+			activator.Activate()
+			this.settle()
+		}
+	}
+}
+
+// Deactivate deactivates all spouts, after which Step does not call
+// NextTuple until the topology is activated again. A topology that is
+// deactivated before it is opened is opened without being activated.
+func (this *Topology) Deactivate() {
+	if this.deactivated {
+		return
+	}
+	this.deactivated = true
+	if !this.opened {
+		return
+	}
+	for _, task := range this.spouts {
+		if deactivator, ok := gostorm.As[gostorm.Deactivator](task.spout); ok {
+			deactivator.Deactivate()
+			this.settle()
+		}
+	}
+}
+
+// Activate activates all spouts of a deactivated topology
+func (this *Topology) Activate() {
+	if !this.deactivated {
+		return
+	}
+	this.deactivated = false
+	if this.opened {
+		this.activate()
+	}
+}
+
+// Step calls NextTuple once on every spout task, unless the topology
+// is deactivated, and then processes tuples, acks and fails until the
+// topology has settled.
+// Step reports whether any tuples were emitted or any spout was
+// informed of the outcome of a tuple during the step.
+func (this *Topology) Step() bool {
+	if this.closed {
+		panic("Local: Step called on a topology that has been shut down")
+	}
+	this.Open()
+	this.busy = false
+	this.acker.Expire()
+	this.settle()
+	if !this.deactivated {
+		for _, task := range this.spouts {
+			task.spout.NextTuple()
+			this.settle()
+		}
+	}
+	this.flushMetrics()
+	return this.busy
+}
+
+// flushMetrics logs the values of the metrics registered by all tasks.
+// Registered metrics are flushed after every step, instead of after
+// every sync sent to Storm.
+func (this *Topology) flushMetrics() {
+	for _, task := range this.spouts {
+		task.metrics.Flush(func(name string, value interface{}) error {
+			return logMetric(task.componentId, task.id, name, value)
+		})
+	}
+	for _, task := range this.bolts {
+		task.metrics.Flush(func(name string, value interface{}) error {
+			return logMetric(task.componentId, task.id, name, value)
+		})
+	}
+}
+
+// Run steps the topology until it is idle and then shuts it down.
+// A topology is idle when no spout emits during a step, no spout
+// tuples were acked or failed and, if message timeouts are enabled, no
+// tuple trees are waiting to time out. Run does not return for spouts
+// that never stop emitting; such topologies should be driven using Step.
+func (this *Topology) Run() {
+	for {
+		if this.Step() {
+			continue
+		}
+		if this.acker.Timeout() <= 0 || this.acker.Pending() == 0 {
+			break
+		}
+		// Wait for pending tuple trees to time out, similar to the
+		// Storm sleep spout wait strategy.
+		time.Sleep(time.Millisecond)
+	}
+	this.Shutdown()
+}
+
+// Tick sends a tick tuple to every bolt task that implements
+// gostorm.Ticker and processes the resulting tuples. Unlike Storm, the
+// local topology does not send tick tuples periodically.
+func (this *Topology) Tick() {
+	this.Open()
+	for _, task := range this.bolts {
+		if ticker, ok := gostorm.As[gostorm.Ticker](task.bolt); ok {
+			ticker.Tick(messages.BoltMsgMeta{
+				Comp:   "__system",
+				Stream: "__tick",
+				Task:   -1,
+			})
+			this.settle()
+		}
+	}
+}
+
+// Acker returns the acker that tracks the tuple trees of the topology.
+// Its clock can be replaced to time out tuple trees in tests.
+func (this *Topology) Acker() *Acker {
+	return this.acker
+}
+
+// Shutdown cleans up all bolts and exits all spouts
+func (this *Topology) Shutdown() {
+	if this.closed {
+		return
+	}
+	this.closed = true
+	if !this.opened {
+		return
+	}
+	for _, task := range this.bolts {
+		task.bolt.Cleanup()
+	}
+	for _, task := range this.spouts {
+		task.spout.Exit()
+	}
+}
+
+// Pending returns the number of spout tuples whose tuple trees have not completed
+func (this *Topology) Pending() int {
+	return this.acker.Pending()
+}
+
+// settle delivers queued tuples to bolts and informs spouts of acks and
+// fails until there is nothing left to process.
+func (this *Topology) settle() {
+	for this.deliveries.Len() > 0 || len(this.outcomes) > 0 {
+		for this.deliveries.Len() > 0 {
+			d := this.deliveries.Remove(this.deliveries.Front()).(*delivery)
+			d.task.bolt.Execute(d.meta, decodeFields(d.task.bolt, &d.meta, d.values)...)
+		}
+		if len(this.outcomes) > 0 {
+			this.busy = true
+			o := this.outcomes[0]
+			this.outcomes = this.outcomes[1:]
+			if o.acked {
+				o.task.spout.Acked(o.msgId)
+			} else {
+				o.task.spout.Failed(o.msgId)
+			}
+		}
+	}
+}
+
+// route returns the tasks that should receive a tuple emitted by the
+// given component. A negative direct task denotes a regular emission.
+func (this *Topology) route(componentId, streamId string, directTask int64, values []interface{}) (tasks []*boltTask) {
+	key := streamKey{componentId: componentId, streamId: normaliseStream(streamId)}
+	for _, sub := range this.subscribers[key] {
+		if directTask < 0 {
+			if !sub.grouping.isDirect() {
+				tasks = append(tasks, sub.grouping.chooseTasks(sub.tasks, values)...)
+			}
+			continue
+		}
+		if !sub.grouping.isDirect() {
+			continue
+		}
+		for _, task := range sub.tasks {
+			if int64(task.id) == directTask {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks
+}
+
+// deliver queues a tuple for each of the given tasks, using the tuple ids assigned by the acker
+func (this *Topology) deliver(source int32, streamId string, tasks []*boltTask, ids []string, values []interface{}) (taskIds []int32) {
+	this.busy = true
+	for i, task := range tasks {
+		this.deliveries.PushBack(&delivery{
+			task: task,
+			meta: messages.BoltMsgMeta{
+				Id:     ids[i],
+				Comp:   this.tasks[source],
+				Stream: normaliseStream(streamId),
+				Task:   int64(source),
+			},
+			values: values,
+		})
+		taskIds = append(taskIds, task.id)
+	}
+	return taskIds
+}
+
+// decodeFields hands the emitted values to a bolt in the form it would
+// have received them from Storm. When the bolt's fields factory
+// declares a struct for every value of the tuple's input, each value is
+// marshalled and unmarshalled into its struct. Otherwise the values are
+// passed as is.
+func decodeFields(bolt gostorm.Bolt, meta *messages.BoltMsgMeta, values []interface{}) []interface{} {
+	fields := gostorm.BoltFields(bolt, meta.Comp, meta.Stream)
+	tupleFields := core.TupleFields(fields)
+	if len(tupleFields) != len(values) {
+		return values
+	}
+	for i, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			panic(fmt.Sprintf("Local: Marshalling field %d (%T): %v", i, value, err))
+		}
+		err = json.Unmarshal(data, tupleFields[i])
+		if err != nil {
+			panic(fmt.Sprintf("Local: Unmarshalling field %d into %T: %v", i, tupleFields[i], err))
+		}
+	}
+	return fields
+}
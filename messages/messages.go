@@ -81,6 +81,31 @@ func (this *Context) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Conf returns the value of the topology configuration with the given
+// key and whether the configuration was found. Values that were null
+// in the configuration sent by Storm are reported as not found.
+func (this *Context) Conf(key string) (value string, ok bool) {
+	for _, conf := range this.GetConfs() {
+		if conf.Key == key {
+			// Null values are formatted as such by UnmarshalJSON
+			if conf.Value == "<nil>" {
+				return "", false
+			}
+			return conf.Value, true
+		}
+	}
+	return "", false
+}
+
+// quote returns a string as an escaped json string
+func quote(s string) string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
 // Multilang message definition:
 // {"pid": 1234}
 func (this *Pid) MarshalJSON() ([]byte, error) {
@@ -122,11 +147,11 @@ func (this *BoltMsg) MarshalJSON() ([]byte, error) {
 	if err != nil {
 		panic(err)
 	}
-	id := this.BoltMsgJson.Id
-	comp := this.BoltMsgJson.Comp
-	stream := this.BoltMsgJson.Stream
+	id := quote(this.BoltMsgJson.Id)
+	comp := quote(this.BoltMsgJson.Comp)
+	stream := quote(this.BoltMsgJson.Stream)
 	task := this.BoltMsgJson.Task
-	return []byte(fmt.Sprintf(`{"id": "%s", "comp": "%s", "stream": "%s", "task": %d, "tuple": %s}`, id, comp, stream, task, contents)), nil
+	return []byte(fmt.Sprintf(`{"id": %s, "comp": %s, "stream": %s, "task": %d, "tuple": %s}`, id, comp, stream, task, contents)), nil
 }
 
 func (this *BoltMsg) UnmarshalJSON(data []byte) error {
@@ -141,16 +166,22 @@ func (this *BoltMsg) UnmarshalJSON(data []byte) error {
 // {"command": "next"}
 // {"command": "ack", "id": "1231231"}
 // {"command": "fail", "id": "1231231"}
+// {"command": "activate"}
+// {"command": "deactivate"}
 
 func (this *SpoutMsg) MarshalJSON() ([]byte, error) {
 	if len(this.Id) > 0 {
-		return []byte(fmt.Sprintf(`{"command": "%s", "id": "%s"}`, this.Command, this.Id)), nil
+		return []byte(fmt.Sprintf(`{"command": %s, "id": %s}`, quote(this.Command), quote(this.Id))), nil
 	} else {
 		switch this.Command {
 		case "next":
 			return []byte(`{"command": "next"}`), nil
 		case "sync":
 			return []byte(`{"command": "sync"}`), nil
+		case "activate":
+			return []byte(`{"command": "activate"}`), nil
+		case "deactivate":
+			return []byte(`{"command": "deactivate"}`), nil
 		default:
 			panic("GoStorm: unknown spout command specified")
 		}
@@ -216,6 +247,15 @@ func (this *ShellMsg) MarshalJSON() ([]byte, error) {
 	if msg := this.ShellMsgJson.ShellMsgMeta.GetMsg(); len(msg) > 0 {
 		result["msg"] = msg
 	}
+	if level := this.ShellMsgJson.ShellMsgMeta.Level; level != nil {
+		result["level"] = *level
+	}
+	if name := this.ShellMsgJson.ShellMsgMeta.GetName(); len(name) > 0 {
+		result["name"] = name
+	}
+	if params := this.ShellMsgJson.ShellMsgMeta.GetParams(); len(params) > 0 {
+		result["params"] = json.RawMessage(params)
+	}
 	if contents := this.ShellMsgJson.Contents; len(contents) > 0 {
 		result["tuple"] = contents
 	}
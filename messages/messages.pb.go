@@ -265,6 +265,9 @@ type ShellMsgMeta struct {
 	Task             *int64   `protobuf:"varint,5,opt,name=Task" json:"Task,omitempty"`
 	NeedTaskIds      *bool    `protobuf:"varint,6,opt,name=NeedTaskIds" json:"NeedTaskIds,omitempty"`
 	Msg              *string  `protobuf:"bytes,7,opt,name=Msg" json:"Msg,omitempty"`
+	Level            *int32   `protobuf:"varint,8,opt,name=Level" json:"Level,omitempty"`
+	Name             *string  `protobuf:"bytes,9,opt,name=Name" json:"Name,omitempty"`
+	Params           []byte   `protobuf:"bytes,10,opt,name=Params" json:"Params,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -320,6 +323,27 @@ func (m *ShellMsgMeta) GetMsg() string {
 	return ""
 }
 
+func (m *ShellMsgMeta) GetLevel() int32 {
+	if m != nil && m.Level != nil {
+		return *m.Level
+	}
+	return 0
+}
+
+func (m *ShellMsgMeta) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *ShellMsgMeta) GetParams() []byte {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
 type ShellMsgProto struct {
 	ShellMsgMeta     *ShellMsgMeta `protobuf:"bytes,1,opt,name=ShellMsgMeta" json:"ShellMsgMeta,omitempty"`
 	Contents         [][]byte      `protobuf:"bytes,2,rep,name=Contents" json:"Contents,omitempty"`
@@ -1054,6 +1078,27 @@ func (this *ShellMsgMeta) VerboseEqual(that interface{}) error {
 	} else if that1.Msg != nil {
 		return fmt.Errorf("Msg this(%v) Not Equal that(%v)", this.Msg, that1.Msg)
 	}
+	if this.Level != nil && that1.Level != nil {
+		if *this.Level != *that1.Level {
+			return fmt.Errorf("Level this(%v) Not Equal that(%v)", *this.Level, *that1.Level)
+		}
+	} else if this.Level != nil {
+		return fmt.Errorf("this.Level == nil && that.Level != nil")
+	} else if that1.Level != nil {
+		return fmt.Errorf("Level this(%v) Not Equal that(%v)", this.Level, that1.Level)
+	}
+	if this.Name != nil && that1.Name != nil {
+		if *this.Name != *that1.Name {
+			return fmt.Errorf("Name this(%v) Not Equal that(%v)", *this.Name, *that1.Name)
+		}
+	} else if this.Name != nil {
+		return fmt.Errorf("this.Name == nil && that.Name != nil")
+	} else if that1.Name != nil {
+		return fmt.Errorf("Name this(%v) Not Equal that(%v)", this.Name, that1.Name)
+	}
+	if !bytes.Equal(this.Params, that1.Params) {
+		return fmt.Errorf("Params this(%v) Not Equal that(%v)", this.Params, that1.Params)
+	}
 	if !bytes.Equal(this.XXX_unrecognized, that1.XXX_unrecognized) {
 		return fmt.Errorf("XXX_unrecognized this(%v) Not Equal that(%v)", this.XXX_unrecognized, that1.XXX_unrecognized)
 	}
@@ -1135,6 +1180,27 @@ func (this *ShellMsgMeta) Equal(that interface{}) bool {
 	} else if that1.Msg != nil {
 		return false
 	}
+	if this.Level != nil && that1.Level != nil {
+		if *this.Level != *that1.Level {
+			return false
+		}
+	} else if this.Level != nil {
+		return false
+	} else if that1.Level != nil {
+		return false
+	}
+	if this.Name != nil && that1.Name != nil {
+		if *this.Name != *that1.Name {
+			return false
+		}
+	} else if this.Name != nil {
+		return false
+	} else if that1.Name != nil {
+		return false
+	}
+	if !bytes.Equal(this.Params, that1.Params) {
+		return false
+	}
 	if !bytes.Equal(this.XXX_unrecognized, that1.XXX_unrecognized) {
 		return false
 	}
@@ -1644,6 +1710,23 @@ func (m *ShellMsgMeta) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintMessages(data, i, uint64(len(*m.Msg)))
 		i += copy(data[i:], *m.Msg)
 	}
+	if m.Level != nil {
+		data[i] = 0x40
+		i++
+		i = encodeVarintMessages(data, i, uint64(*m.Level))
+	}
+	if m.Name != nil {
+		data[i] = 0x4a
+		i++
+		i = encodeVarintMessages(data, i, uint64(len(*m.Name)))
+		i += copy(data[i:], *m.Name)
+	}
+	if m.Params != nil {
+		data[i] = 0x52
+		i++
+		i = encodeVarintMessages(data, i, uint64(len(m.Params)))
+		i += copy(data[i:], m.Params)
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(data[i:], m.XXX_unrecognized)
 	}
@@ -1918,8 +2001,26 @@ func NewPopulatedShellMsgMeta(r randyMessages, easy bool) *ShellMsgMeta {
 		v11 := randStringMessages(r)
 		this.Msg = &v11
 	}
+	if r.Intn(10) != 0 {
+		v17 := int32(r.Int31())
+		if r.Intn(2) == 0 {
+			v17 *= -1
+		}
+		this.Level = &v17
+	}
+	if r.Intn(10) != 0 {
+		v18 := randStringMessages(r)
+		this.Name = &v18
+	}
+	if r.Intn(10) != 0 {
+		v19 := r.Intn(100)
+		this.Params = make([]byte, v19)
+		for i := 0; i < v19; i++ {
+			this.Params[i] = byte(r.Intn(256))
+		}
+	}
 	if !easy && r.Intn(10) != 0 {
-		this.XXX_unrecognized = randUnrecognizedMessages(r, 8)
+		this.XXX_unrecognized = randUnrecognizedMessages(r, 11)
 	}
 	return this
 }
@@ -2202,6 +2303,17 @@ func (m *ShellMsgMeta) Size() (n int) {
 		l = len(*m.Msg)
 		n += 1 + l + sovMessages(uint64(l))
 	}
+	if m.Level != nil {
+		n += 1 + sovMessages(uint64(*m.Level))
+	}
+	if m.Name != nil {
+		l = len(*m.Name)
+		n += 1 + l + sovMessages(uint64(l))
+	}
+	if m.Params != nil {
+		l = len(m.Params)
+		n += 1 + l + sovMessages(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -2377,6 +2489,9 @@ func (this *ShellMsgMeta) String() string {
 		`Task:` + valueToStringMessages(this.Task) + `,`,
 		`NeedTaskIds:` + valueToStringMessages(this.NeedTaskIds) + `,`,
 		`Msg:` + valueToStringMessages(this.Msg) + `,`,
+		`Level:` + valueToStringMessages(this.Level) + `,`,
+		`Name:` + valueToStringMessages(this.Name) + `,`,
+		`Params:` + valueToStringMessages(this.Params) + `,`,
 		`XXX_unrecognized:` + fmt.Sprintf("%v", this.XXX_unrecognized) + `,`,
 		`}`,
 	}, "")
@@ -3616,6 +3731,84 @@ func (m *ShellMsgMeta) Unmarshal(data []byte) error {
 			s := string(data[iNdEx:postIndex])
 			m.Msg = &s
 			iNdEx = postIndex
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Level", wireType)
+			}
+			var v int32
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessages
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Level = &v
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessages
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMessages
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			s := string(data[iNdEx:postIndex])
+			m.Name = &s
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Params", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessages
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthMessages
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Params = append([]byte{}, data[iNdEx:postIndex]...)
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMessages(data[iNdEx:])
@@ -34,6 +34,31 @@ func TestMarshalShellMsg(t *testing.T) {
 	verifyJsonOutput(t, msg, expected)
 }
 
+func TestMarshalBoltMsg(t *testing.T) {
+	msg := &BoltMsg{
+		BoltMsgJson: &BoltMsgJson{
+			BoltMsgMeta: &BoltMsgMeta{
+				Id:     `id"1`,
+				Comp:   "comp\\1",
+				Stream: "stream\n1",
+				Task:   9,
+			},
+			Contents: []interface{}{"field"},
+		},
+	}
+	expected := []byte(`{"id":"id\"1","comp":"comp\\1","stream":"stream\n1","task":9,"tuple":["field"]}`)
+	verifyJsonOutput(t, msg, expected)
+}
+
+func TestMarshalSpoutMsg(t *testing.T) {
+	msg := &SpoutMsg{
+		Command: "ack",
+		Id:      `id"1`,
+	}
+	expected := []byte(`{"command":"ack","id":"id\"1"}`)
+	verifyJsonOutput(t, msg, expected)
+}
+
 func verifyJsonOutput(t *testing.T, msg interface{}, expected []byte) {
 	marshaled, err := json.Marshal(msg)
 	if err != nil {
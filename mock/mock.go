@@ -17,7 +17,10 @@ package gostorm
 import (
 	"fmt"
 	"github.com/jsgilmore/gostorm"
+	"github.com/jsgilmore/gostorm/core"
+	"github.com/jsgilmore/gostorm/local"
 	stormmsg "github.com/jsgilmore/gostorm/messages"
+	"time"
 )
 
 func NewPrinter() gostorm.Bolt {
@@ -59,6 +62,27 @@ type mockOutputCollectorImpl struct {
 func (this *mockOutputCollectorImpl) Log(msg string) {
 }
 
+func (this *mockOutputCollectorImpl) Debug(msg string) {
+}
+
+func (this *mockOutputCollectorImpl) Info(msg string) {
+}
+
+func (this *mockOutputCollectorImpl) Warn(msg string) {
+}
+
+func (this *mockOutputCollectorImpl) Error(msg string) {
+}
+
+func (this *mockOutputCollectorImpl) ReportError(err error) {
+}
+
+func (this *mockOutputCollectorImpl) Metric(name string, value interface{}) {
+}
+
+func (this *mockOutputCollectorImpl) RegisterMetric(name string, metric core.Metric) {
+}
+
 func (this *mockOutputCollectorImpl) SendAck(id string) {
 	this.EmitDirect(nil, "", 0, "Ack:"+id)
 }
@@ -72,6 +96,10 @@ func (this *mockOutputCollectorImpl) Emit(anchors []string, stream string, conte
 	return []int32{1}
 }
 
+func (this *mockOutputCollectorImpl) EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	return this.Emit(anchors, stream, contents...)
+}
+
 func (this *mockOutputCollectorImpl) EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) {
 	meta := stormmsg.BoltMsgMeta{
 		Stream: stream,
@@ -94,11 +122,36 @@ type mockSpoutSpoutOutputCollectorImpl struct {
 func (this *mockSpoutSpoutOutputCollectorImpl) Log(msg string) {
 }
 
+func (this *mockSpoutSpoutOutputCollectorImpl) Debug(msg string) {
+}
+
+func (this *mockSpoutSpoutOutputCollectorImpl) Info(msg string) {
+}
+
+func (this *mockSpoutSpoutOutputCollectorImpl) Warn(msg string) {
+}
+
+func (this *mockSpoutSpoutOutputCollectorImpl) Error(msg string) {
+}
+
+func (this *mockSpoutSpoutOutputCollectorImpl) ReportError(err error) {
+}
+
+func (this *mockSpoutSpoutOutputCollectorImpl) Metric(name string, value interface{}) {
+}
+
+func (this *mockSpoutSpoutOutputCollectorImpl) RegisterMetric(name string, metric core.Metric) {
+}
+
 func (this *mockSpoutSpoutOutputCollectorImpl) Emit(id string, stream string, contents ...interface{}) (taskIds []int32) {
 	this.EmitDirect(id, stream, 0, contents...)
 	return []int32{1}
 }
 
+func (this *mockSpoutSpoutOutputCollectorImpl) EmitWithTaskIds(id string, stream string, contents ...interface{}) (taskIds []int32) {
+	return this.Emit(id, stream, contents...)
+}
+
 func (this *mockSpoutSpoutOutputCollectorImpl) EmitDirect(id string, stream string, directTask int64, contents ...interface{}) {
 	meta := stormmsg.BoltMsgMeta{
 		Id:     id,
@@ -106,3 +159,166 @@ func (this *mockSpoutSpoutOutputCollectorImpl) EmitDirect(id string, stream stri
 	}
 	this.bolt.Execute(meta, contents...)
 }
+
+// NewAckingSpoutOutputCollector returns a spout output collector that
+// delivers emitted tuples to the given bolt and tracks them using the
+// given acker. The acker informs the spout through Acked and Failed
+// once the tree of a reliably emitted tuple has completed, failed or
+// timed out. The bolt may be nil, in which case emitted tuples have no
+// receivers and reliable tuples are acked immediately, as in Storm.
+//
+// Tuples are delivered synchronously, so the spout may be informed of
+// the outcome of a tuple before the Emit call that emitted it returns.
+// A tuple whose tree has not completed within the message timeout of
+// the acker is failed by a timer, so Failed may then be called
+// concurrently with the other functions of the spout. If the acker's
+// clock has been replaced, trees that have timed out are also failed
+// whenever one of the acking collectors is used.
+func NewAckingSpoutOutputCollector(spout gostorm.Spout, acker *local.Acker, bolt gostorm.Bolt) gostorm.SpoutOutputCollector {
+	return &ackingSpoutOutputCollectorImpl{
+		spout: spout,
+		acker: acker,
+		bolt:  bolt,
+	}
+}
+
+type ackingSpoutOutputCollectorImpl struct {
+	spout gostorm.Spout
+	acker *local.Acker
+	bolt  gostorm.Bolt
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Log(msg string) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Debug(msg string) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Info(msg string) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Warn(msg string) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Error(msg string) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) ReportError(err error) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Metric(name string, value interface{}) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) RegisterMetric(name string, metric core.Metric) {
+}
+
+func (this *ackingSpoutOutputCollectorImpl) Emit(id string, stream string, contents ...interface{}) (taskIds []int32) {
+	this.EmitDirect(id, stream, 0, contents...)
+	if this.bolt == nil {
+		return nil
+	}
+	return []int32{1}
+}
+
+func (this *ackingSpoutOutputCollectorImpl) EmitWithTaskIds(id string, stream string, contents ...interface{}) (taskIds []int32) {
+	return this.Emit(id, stream, contents...)
+}
+
+func (this *ackingSpoutOutputCollectorImpl) EmitDirect(id string, stream string, directTask int64, contents ...interface{}) {
+	this.acker.Expire()
+	ids := this.acker.SpoutEmit(this.spout, id, deliveries(this.bolt))
+	if this.bolt == nil {
+		return
+	}
+	if timeout := this.acker.Timeout(); len(id) > 0 && timeout > 0 {
+		time.AfterFunc(timeout, func() { this.acker.Expire() })
+	}
+	meta := stormmsg.BoltMsgMeta{
+		Id:     ids[0],
+		Stream: stream,
+	}
+	this.bolt.Execute(meta, contents...)
+}
+
+// NewAckingOutputCollector returns an output collector that delivers
+// emitted tuples to the given bolt and that tracks anchored emissions,
+// acks and fails using the given acker. The acker should be shared with
+// the acking spout output collector the tuple trees originate from.
+// The bolt may be nil, in which case emitted tuples have no receivers.
+func NewAckingOutputCollector(acker *local.Acker, bolt gostorm.Bolt) gostorm.OutputCollector {
+	return &ackingOutputCollectorImpl{
+		acker: acker,
+		bolt:  bolt,
+	}
+}
+
+type ackingOutputCollectorImpl struct {
+	acker *local.Acker
+	bolt  gostorm.Bolt
+}
+
+func (this *ackingOutputCollectorImpl) Log(msg string) {
+}
+
+func (this *ackingOutputCollectorImpl) Debug(msg string) {
+}
+
+func (this *ackingOutputCollectorImpl) Info(msg string) {
+}
+
+func (this *ackingOutputCollectorImpl) Warn(msg string) {
+}
+
+func (this *ackingOutputCollectorImpl) Error(msg string) {
+}
+
+func (this *ackingOutputCollectorImpl) ReportError(err error) {
+}
+
+func (this *ackingOutputCollectorImpl) Metric(name string, value interface{}) {
+}
+
+func (this *ackingOutputCollectorImpl) RegisterMetric(name string, metric core.Metric) {
+}
+
+func (this *ackingOutputCollectorImpl) SendAck(id string) {
+	this.acker.Expire()
+	this.acker.Ack(id)
+}
+
+func (this *ackingOutputCollectorImpl) SendFail(id string) {
+	this.acker.Expire()
+	this.acker.Fail(id)
+}
+
+func (this *ackingOutputCollectorImpl) Emit(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	this.EmitDirect(anchors, stream, 0, contents...)
+	if this.bolt == nil {
+		return nil
+	}
+	return []int32{1}
+}
+
+func (this *ackingOutputCollectorImpl) EmitWithTaskIds(anchors []string, stream string, contents ...interface{}) (taskIds []int32) {
+	return this.Emit(anchors, stream, contents...)
+}
+
+func (this *ackingOutputCollectorImpl) EmitDirect(anchors []string, stream string, directTask int64, contents ...interface{}) {
+	this.acker.Expire()
+	ids := this.acker.BoltEmit(anchors, deliveries(this.bolt))
+	if this.bolt == nil {
+		return
+	}
+	meta := stormmsg.BoltMsgMeta{
+		Id:     ids[0],
+		Stream: stream,
+	}
+	this.bolt.Execute(meta, contents...)
+}
+
+func deliveries(bolt gostorm.Bolt) int {
+	if bolt == nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,169 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsgilmore/gostorm"
+	"github.com/jsgilmore/gostorm/local"
+	stormmsg "github.com/jsgilmore/gostorm/messages"
+)
+
+type replaySpout struct {
+	collector gostorm.SpoutOutputCollector
+	acked     []string
+	failed    []string
+}
+
+func (this *replaySpout) NextTuple() {}
+
+func (this *replaySpout) Acked(id string) {
+	this.acked = append(this.acked, id)
+}
+
+func (this *replaySpout) Failed(id string) {
+	this.failed = append(this.failed, id)
+}
+
+func (this *replaySpout) Exit() {}
+
+func (this *replaySpout) Open(context *stormmsg.Context, collector gostorm.SpoutOutputCollector) {
+	this.collector = collector
+}
+
+type splitter struct {
+	collector gostorm.OutputCollector
+}
+
+func (this *splitter) Execute(meta stormmsg.BoltMsgMeta, fields ...interface{}) {
+	sentence := fields[0].(string)
+	for _, word := range strings.Split(sentence, " ") {
+		this.collector.Emit([]string{meta.Id}, "", word)
+	}
+	if strings.Contains(sentence, "fail") {
+		this.collector.SendFail(meta.Id)
+	} else {
+		this.collector.SendAck(meta.Id)
+	}
+}
+
+func (this *splitter) Prepare(context *stormmsg.Context, collector gostorm.OutputCollector) {}
+
+func (this *splitter) Cleanup() {}
+
+func (this *splitter) Fields() []interface{} {
+	return nil
+}
+
+type wordAcker struct {
+	collector gostorm.OutputCollector
+	ids       []string
+}
+
+func (this *wordAcker) Execute(meta stormmsg.BoltMsgMeta, fields ...interface{}) {
+	this.ids = append(this.ids, meta.Id)
+}
+
+func (this *wordAcker) Prepare(context *stormmsg.Context, collector gostorm.OutputCollector) {}
+
+func (this *wordAcker) Cleanup() {}
+
+func (this *wordAcker) Fields() []interface{} {
+	return nil
+}
+
+func TestAckingCollectors(t *testing.T) {
+	acker := local.NewAcker()
+	spout := &replaySpout{}
+	words := &wordAcker{}
+	words.collector = NewAckingOutputCollector(acker, nil)
+	split := &splitter{collector: NewAckingOutputCollector(acker, words)}
+	spout.Open(nil, NewAckingSpoutOutputCollector(spout, acker, split))
+
+	spout.collector.Emit("1", "", "snow white and the seven dwarfs")
+	if len(spout.acked) != 0 || len(words.ids) != 6 {
+		t.Fatalf("Tuple acked before its words were acked (acked: %v, words: %d)", spout.acked, len(words.ids))
+	}
+	for _, id := range words.ids {
+		words.collector.SendAck(id)
+	}
+	if len(spout.acked) != 1 || spout.acked[0] != "1" {
+		t.Fatalf("Tuple not acked after its tree completed: %v", spout.acked)
+	}
+
+	spout.collector.Emit("2", "", "fail this sentence")
+	if len(spout.failed) != 1 || spout.failed[0] != "2" {
+		t.Fatalf("Failed tuple not reported to spout: %v", spout.failed)
+	}
+}
+
+func TestAckingCollectorTimeout(t *testing.T) {
+	acker := local.NewAcker()
+	acker.SetTimeout(time.Second)
+	now := time.Unix(0, 0)
+	acker.SetClock(func() time.Time { return now })
+
+	spout := &replaySpout{}
+	words := &wordAcker{}
+	words.collector = NewAckingOutputCollector(acker, nil)
+	split := &splitter{collector: NewAckingOutputCollector(acker, words)}
+	spout.Open(nil, NewAckingSpoutOutputCollector(spout, acker, split))
+
+	spout.collector.Emit("1", "", "never acked")
+	now = now.Add(time.Second)
+	words.collector.SendAck(words.ids[0])
+	if len(spout.failed) != 1 || spout.failed[0] != "1" || len(spout.acked) != 0 {
+		t.Fatalf("Expected tuple to time out (acked: %v, failed: %v)", spout.acked, spout.failed)
+	}
+}
+
+// timedSpout reports failed tuples on a channel, since they are failed
+// by the timer of the acking collector
+type timedSpout struct {
+	replaySpout
+	failed chan string
+}
+
+func (this *timedSpout) Failed(id string) {
+	this.failed <- id
+}
+
+func TestAckingCollectorTimer(t *testing.T) {
+	acker := local.NewAcker()
+	acker.SetTimeout(10 * time.Millisecond)
+
+	spout := &timedSpout{failed: make(chan string, 1)}
+	words := &wordAcker{}
+	words.collector = NewAckingOutputCollector(acker, nil)
+	split := &splitter{collector: NewAckingOutputCollector(acker, words)}
+	spout.Open(nil, NewAckingSpoutOutputCollector(spout, acker, split))
+
+	// The tuple times out without any collector being used again
+	spout.collector.Emit("1", "", "never acked")
+	select {
+	case id := <-spout.failed:
+		if id != "1" {
+			t.Fatalf("Expected tuple 1 to time out, failed: %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tuple did not time out")
+	}
+	if acker.Pending() != 0 {
+		t.Fatalf("Expected no pending tuples, %d pending", acker.Pending())
+	}
+}
@@ -0,0 +1,371 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// DefaultEncoding is the encoding used by RunBolt and RunSpout if no
+// encoding is given, which is compatible with the Storm shell components
+const DefaultEncoding = "jsonObject"
+
+// Options configure how RunBolt and RunSpout run a component. The
+// zero value runs a component with the default encoding on stdin and
+// stdout.
+type Options struct {
+	// Encoding is the name of the encoding used to communicate with Storm
+	Encoding string
+	// NeedTaskIds makes Emit return the ids of the tasks that received
+	// every emitted tuple, which requires waiting for Storm to send
+	// them. Without it, EmitWithTaskIds can be used for the emissions
+	// that need task ids.
+	NeedTaskIds bool
+	// Reader and Writer replace stdin and stdout for communicating with Storm
+	Reader io.Reader
+	Writer io.Writer
+	// HandleSignals stops reading from Storm when the process receives
+	// SIGTERM or an interrupt. The component then finishes the tuples
+	// it is executing and is cleaned up, after which RunBolt or
+	// RunSpout returns.
+	HandleSignals bool
+	// RecoverPanics reports the panics of the component to Storm, with
+	// their stack traces, before the panic continues. This includes
+	// panics on the workers of a concurrent bolt.
+	RecoverPanics bool
+	// RedirectStdout points os.Stdout at stderr or at the Storm log
+	// while the component runs, so that stray writes to stdout cannot
+	// corrupt the messages sent to Storm
+	RedirectStdout StdoutRedirect
+	// RedirectStdoutFd also redirects file descriptor 1, after
+	// duplicating it for the messages sent to Storm, which catches
+	// writes to stdout that bypass os.Stdout, e.g. by C code or child
+	// processes. It is not supported on all platforms.
+	RedirectStdoutFd bool
+	// BatchSize batches the messages sent to Storm, which are written
+	// once BatchSize messages are waiting, once the oldest message has
+	// waited for BatchLatency or before blocking on reading from Storm.
+	// A zero BatchSize disables batching and a zero BatchLatency
+	// disables the latency timer.
+	BatchSize    int
+	BatchLatency time.Duration
+	// Workers executes the tuples of a bolt on the given number of
+	// goroutines, with at most MaxInFlight tuples read ahead. See
+	// ShellBolt.SetConcurrency. Spouts ignore this option.
+	Workers     int
+	MaxInFlight int
+	// DecodeErrorPolicy determines what a bolt does with tuples that
+	// cannot be decoded. See ShellBolt.SetDecodeErrorPolicy. Spouts
+	// ignore this option.
+	DecodeErrorPolicy DecodeErrorPolicy
+}
+
+// Option sets an option of RunBolt or RunSpout
+type Option func(options *Options)
+
+// WithEncoding sets the name of the encoding used to communicate with Storm
+func WithEncoding(encoding string) Option {
+	return func(options *Options) {
+		options.Encoding = encoding
+	}
+}
+
+// WithNeedTaskIds sets whether Emit returns the ids of the tasks that
+// received every emitted tuple
+func WithNeedTaskIds(needTaskIds bool) Option {
+	return func(options *Options) {
+		options.NeedTaskIds = needTaskIds
+	}
+}
+
+// WithIO communicates with Storm using the given reader and writer
+// instead of stdin and stdout
+func WithIO(reader io.Reader, writer io.Writer) Option {
+	return func(options *Options) {
+		options.Reader = reader
+		options.Writer = writer
+	}
+}
+
+// WithSignalHandling stops the component, cleans it up and returns when
+// the process receives SIGTERM or an interrupt
+func WithSignalHandling() Option {
+	return func(options *Options) {
+		options.HandleSignals = true
+	}
+}
+
+// WithPanicRecovery reports the panics of the component to Storm before
+// the panic continues, so that they are shown in the Storm UI
+func WithPanicRecovery() Option {
+	return func(options *Options) {
+		options.RecoverPanics = true
+	}
+}
+
+// WithStdoutRedirect points os.Stdout at stderr while the component runs
+func WithStdoutRedirect() Option {
+	return func(options *Options) {
+		options.RedirectStdout = StdoutToStderr
+	}
+}
+
+// WithStdoutLog sends every line written to os.Stdout to Storm as a log
+// message while the component runs
+func WithStdoutLog() Option {
+	return func(options *Options) {
+		options.RedirectStdout = StdoutToLog
+	}
+}
+
+// WithStdoutFd also redirects file descriptor 1 when stdout is
+// redirected, which catches writes to stdout that bypass os.Stdout
+func WithStdoutFd() Option {
+	return func(options *Options) {
+		options.RedirectStdoutFd = true
+	}
+}
+
+// WithBatching batches the messages sent to Storm, reducing the number
+// of writes. See core.NewBatchOutput.
+func WithBatching(maxBatch int, maxLatency time.Duration) Option {
+	return func(options *Options) {
+		options.BatchSize = maxBatch
+		options.BatchLatency = maxLatency
+	}
+}
+
+// WithConcurrency executes the tuples of a bolt on the given number of
+// goroutines. See ShellBolt.SetConcurrency.
+func WithConcurrency(workers, maxInFlight int) Option {
+	return func(options *Options) {
+		options.Workers = workers
+		options.MaxInFlight = maxInFlight
+	}
+}
+
+// WithDecodeErrorPolicy sets what a bolt does with tuples that cannot
+// be decoded. See ShellBolt.SetDecodeErrorPolicy.
+func WithDecodeErrorPolicy(policy DecodeErrorPolicy) Option {
+	return func(options *Options) {
+		options.DecodeErrorPolicy = policy
+	}
+}
+
+func newOptions(opts []Option) Options {
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+func (this Options) encoding() string {
+	if len(this.Encoding) == 0 {
+		return DefaultEncoding
+	}
+	return this.Encoding
+}
+
+// streams returns the input and output used to communicate with Storm
+// and redirects stdout if required, until the guard is restored. The
+// input ends once stop is closed.
+func (this Options) streams(stop <-chan struct{}) (input core.Input, output core.Output, guard *stdoutGuard) {
+	guard, stdout, err := guardStdout(this.RedirectStdout, this.RedirectStdoutFd)
+	if err != nil {
+		panic(fmt.Sprintf("GoStorm: Could not redirect stdout: %v", err))
+	}
+	var reader io.Reader = os.Stdin
+	if this.Reader != nil {
+		reader = this.Reader
+	}
+	var writer io.Writer = stdout
+	if this.Writer != nil {
+		writer = this.Writer
+	}
+	if stop != nil {
+		reader = newStoppableReader(reader, stop)
+	}
+	input = core.LookupInput(this.encoding(), reader)
+	output = core.LookupOutput(this.encoding(), writer)
+	if this.BatchSize > 0 {
+		output = core.NewBatchOutput(output, this.BatchSize, this.BatchLatency)
+	}
+	return input, output, guard
+}
+
+// RunBolt runs a bolt until Storm closes its input. Without options, the
+// bolt communicates with Storm over stdin and stdout using the default
+// encoding and does not need task ids. An error that stops the bolt
+// before Storm closes its input, such as a failure to read from Storm,
+// is returned.
+func RunBolt(bolt Bolt, opts ...Option) error {
+	return RunBoltWithOptions(bolt, newOptions(opts))
+}
+
+// RunBoltWithOptions runs a bolt with the given options until Storm closes its input
+func RunBoltWithOptions(bolt Bolt, options Options) error {
+	var stop <-chan struct{}
+	if options.HandleSignals {
+		var stopSignals func()
+		stop, stopSignals = handleSignals()
+		defer stopSignals()
+	}
+	input, output, guard := options.streams(stop)
+	defer guard.restore()
+	boltConn := core.NewBoltConn(input, output, options.NeedTaskIds)
+	if options.RecoverPanics {
+		defer reportPanic(boltConn)
+	}
+	shellBolt := newShellBolt(bolt)
+	shellBolt.recoverPanics = options.RecoverPanics
+	if options.Workers > 0 {
+		shellBolt.SetConcurrency(options.Workers, options.MaxInFlight)
+	}
+	shellBolt.SetDecodeErrorPolicy(options.DecodeErrorPolicy)
+	shellBolt.Initialise(boltConn)
+	guard.capture(boltConn.Log)
+	err := shellBolt.Go()
+	shellBolt.Exit()
+	return err
+}
+
+// RunSpout runs a spout until Storm closes its input. Without options,
+// the spout communicates with Storm over stdin and stdout using the
+// default encoding and does not need task ids. An error that stops the
+// spout before Storm closes its input is returned.
+func RunSpout(spout Spout, opts ...Option) error {
+	return RunSpoutWithOptions(spout, newOptions(opts))
+}
+
+// RunSpoutWithOptions runs a spout with the given options until Storm closes its input
+func RunSpoutWithOptions(spout Spout, options Options) error {
+	var stop <-chan struct{}
+	if options.HandleSignals {
+		var stopSignals func()
+		stop, stopSignals = handleSignals()
+		defer stopSignals()
+	}
+	input, output, guard := options.streams(stop)
+	defer guard.restore()
+	spoutConn := core.NewSpoutConn(input, output, options.NeedTaskIds)
+	if options.RecoverPanics {
+		defer reportPanic(spoutConn)
+	}
+	shellSpout := NewShellSpout(spout)
+	shellSpout.Initialise(spoutConn)
+	guard.capture(spoutConn.Log)
+	err := shellSpout.Go()
+	shellSpout.Exit()
+	return err
+}
+
+// reportPanic reports a panic to Storm and continues panicking. It has
+// to be deferred. Reporting may itself fail, e.g. if the panic was
+// caused by an I/O error, in which case only the original panic continues.
+func reportPanic(reporter interface{ ReportError(err error) }) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	func() {
+		defer func() {
+			recover()
+		}()
+		reporter.ReportError(fmt.Errorf("panic: %v\n%s", recovered, debug.Stack()))
+	}()
+	panic(recovered)
+}
+
+// handleSignals closes the returned channel when the process receives
+// SIGTERM or an interrupt, until stopSignals is called
+func handleSignals() (stop <-chan struct{}, stopSignals func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("GoStorm: Received %s signal, stopping", sig)
+			close(stopped)
+		case <-done:
+		}
+	}()
+	return stopped, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// stoppableReader reads from a reader until stop is closed, after which
+// it returns io.EOF, even if a read is blocked. Since a blocked read
+// cannot be interrupted, reads are done on another goroutine, which is
+// abandoned when the reader is stopped.
+type stoppableReader struct {
+	reader  io.Reader
+	stop    <-chan struct{}
+	buf     []byte
+	results chan readResult
+	pending bool
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func newStoppableReader(reader io.Reader, stop <-chan struct{}) *stoppableReader {
+	return &stoppableReader{
+		reader:  reader,
+		stop:    stop,
+		results: make(chan readResult, 1),
+	}
+}
+
+func (this *stoppableReader) Read(p []byte) (n int, err error) {
+	select {
+	case <-this.stop:
+		return 0, io.EOF
+	default:
+	}
+	// Only one read of the underlying reader is pending at a time
+	if !this.pending {
+		if len(this.buf) < len(p) {
+			this.buf = make([]byte, len(p))
+		}
+		buf := this.buf[:len(p)]
+		this.pending = true
+		go func() {
+			n, err := this.reader.Read(buf)
+			this.results <- readResult{n: n, err: err}
+		}()
+	}
+	select {
+	case result := <-this.results:
+		this.pending = false
+		return copy(p, this.buf[:result.n]), result.err
+	case <-this.stop:
+		return 0, io.EOF
+	}
+}
@@ -0,0 +1,266 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// OutputDeclarer is implemented by bolts and spouts that declare the
+// streams they emit on and the types of the fields of each stream, like
+// declareOutputFields in Java. The emissions of such components are
+// validated against their declarations (see CheckedOutputCollector).
+type OutputDeclarer interface {
+	DeclareOutputs(schema *OutputSchema)
+}
+
+// OutputSchema contains the fields declared for each output stream of a component
+type OutputSchema struct {
+	streams map[string][]reflect.Type
+}
+
+// NewOutputSchema returns a schema without any declared streams
+func NewOutputSchema() *OutputSchema {
+	return &OutputSchema{
+		streams: make(map[string][]reflect.Type),
+	}
+}
+
+// DeclareOutputSchema returns the schema declared by a component, or
+// nil if the component is not an OutputDeclarer
+func DeclareOutputSchema(component interface{}) *OutputSchema {
+	declarer, ok := As[OutputDeclarer](component)
+	if !ok {
+		return nil
+	}
+	schema := NewOutputSchema()
+	declarer.DeclareOutputs(schema)
+	return schema
+}
+
+// Declare declares a stream of which the fields have the types of the
+// given prototypes, e.g. Declare("default", "", 0) declares a string
+// and an int field. A nil prototype declares a field of any type.
+// An empty stream name declares the default stream.
+func (this *OutputSchema) Declare(stream string, prototypes ...interface{}) {
+	stream = normaliseStream(stream)
+	if _, ok := this.streams[stream]; ok {
+		panic(fmt.Sprintf("OutputSchema: Stream already declared: %s", stream))
+	}
+	types := make([]reflect.Type, len(prototypes))
+	for i, prototype := range prototypes {
+		types[i] = reflect.TypeOf(prototype)
+	}
+	this.streams[stream] = types
+}
+
+// Streams returns the names of the declared streams in sorted order
+func (this *OutputSchema) Streams() (streams []string) {
+	for stream := range this.streams {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	return streams
+}
+
+// Validate checks that the given fields may be emitted on a stream.
+// A *SchemaError is returned if the stream was not declared or if the
+// number or types of the fields differ from its declaration.
+func (this *OutputSchema) Validate(stream string, fields []interface{}) error {
+	stream = normaliseStream(stream)
+	types, ok := this.streams[stream]
+	if !ok {
+		return &SchemaError{Stream: stream, Field: -1, Reason: "stream was not declared"}
+	}
+	if len(fields) != len(types) {
+		return &SchemaError{Stream: stream, Field: -1, Reason: fmt.Sprintf("emitted %d fields, declared %d", len(fields), len(types))}
+	}
+	for i, field := range fields {
+		if types[i] == nil {
+			continue
+		}
+		if field == nil {
+			switch types[i].Kind() {
+			case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+				continue
+			}
+		} else if reflect.TypeOf(field) == types[i] {
+			continue
+		}
+		return &SchemaError{Stream: stream, Field: i, Reason: fmt.Sprintf("emitted %T, declared %v", field, types[i])}
+	}
+	return nil
+}
+
+// SchemaError describes an emission that does not match the output
+// schema of a component
+type SchemaError struct {
+	// Stream is the stream that was emitted on
+	Stream string
+	// Field is the index of the offending field, or -1 if the error is
+	// not specific to a field
+	Field int
+	// Reason describes the mismatch
+	Reason string
+}
+
+func (this *SchemaError) Error() string {
+	if this.Field >= 0 {
+		return fmt.Sprintf("gostorm: stream %s field %d: %s", this.Stream, this.Field, this.Reason)
+	}
+	return fmt.Sprintf("gostorm: stream %s: %s", this.Stream, this.Reason)
+}
+
+// CheckedOutputCollector is the output collector of bolts that declare
+// their outputs. CheckedEmit and CheckedEmitDirect return a *SchemaError
+// without emitting if the fields do not match the output schema, while
+// Emit and EmitDirect report the error and emit the fields anyway, so
+// that declaring the outputs of a component never stops it from running.
+type CheckedOutputCollector interface {
+	OutputCollector
+	CheckedEmit(anchors []string, stream string, fields ...interface{}) (taskIds []int32, err error)
+	CheckedEmitWithTaskIds(anchors []string, stream string, fields ...interface{}) (taskIds []int32, err error)
+	CheckedEmitDirect(anchors []string, stream string, directTask int64, fields ...interface{}) (err error)
+}
+
+// CheckedSpoutOutputCollector is the output collector of spouts that
+// declare their outputs, which validates emissions in the same way as
+// CheckedOutputCollector.
+type CheckedSpoutOutputCollector interface {
+	SpoutOutputCollector
+	CheckedEmit(id string, stream string, fields ...interface{}) (taskIds []int32, err error)
+	CheckedEmitWithTaskIds(id string, stream string, fields ...interface{}) (taskIds []int32, err error)
+	CheckedEmitDirect(id string, stream string, directTask int64, fields ...interface{}) (err error)
+}
+
+// NewCheckedOutputCollector returns a collector that validates the
+// emissions of a bolt against its output schema before passing them
+// on to the given collector
+func NewCheckedOutputCollector(collector OutputCollector, schema *OutputSchema) CheckedOutputCollector {
+	return &checkedOutputCollector{
+		OutputCollector: collector,
+		schema:          schema,
+	}
+}
+
+type checkedOutputCollector struct {
+	OutputCollector
+	schema *OutputSchema
+}
+
+func (this *checkedOutputCollector) CheckedEmit(anchors []string, stream string, fields ...interface{}) (taskIds []int32, err error) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		return nil, err
+	}
+	return this.OutputCollector.Emit(anchors, stream, fields...), nil
+}
+
+func (this *checkedOutputCollector) CheckedEmitWithTaskIds(anchors []string, stream string, fields ...interface{}) (taskIds []int32, err error) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		return nil, err
+	}
+	return this.OutputCollector.EmitWithTaskIds(anchors, stream, fields...), nil
+}
+
+func (this *checkedOutputCollector) CheckedEmitDirect(anchors []string, stream string, directTask int64, fields ...interface{}) (err error) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		return err
+	}
+	this.OutputCollector.EmitDirect(anchors, stream, directTask, fields...)
+	return nil
+}
+
+// check reports an error if the fields do not match the output schema
+func (this *checkedOutputCollector) check(stream string, fields []interface{}) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		this.ReportError(err)
+	}
+}
+
+func (this *checkedOutputCollector) Emit(anchors []string, stream string, fields ...interface{}) (taskIds []int32) {
+	this.check(stream, fields)
+	return this.OutputCollector.Emit(anchors, stream, fields...)
+}
+
+func (this *checkedOutputCollector) EmitWithTaskIds(anchors []string, stream string, fields ...interface{}) (taskIds []int32) {
+	this.check(stream, fields)
+	return this.OutputCollector.EmitWithTaskIds(anchors, stream, fields...)
+}
+
+func (this *checkedOutputCollector) EmitDirect(anchors []string, stream string, directTask int64, fields ...interface{}) {
+	this.check(stream, fields)
+	this.OutputCollector.EmitDirect(anchors, stream, directTask, fields...)
+}
+
+// NewCheckedSpoutOutputCollector returns a collector that validates the
+// emissions of a spout against its output schema before passing them
+// on to the given collector
+func NewCheckedSpoutOutputCollector(collector SpoutOutputCollector, schema *OutputSchema) CheckedSpoutOutputCollector {
+	return &checkedSpoutOutputCollector{
+		SpoutOutputCollector: collector,
+		schema:               schema,
+	}
+}
+
+type checkedSpoutOutputCollector struct {
+	SpoutOutputCollector
+	schema *OutputSchema
+}
+
+func (this *checkedSpoutOutputCollector) CheckedEmit(id string, stream string, fields ...interface{}) (taskIds []int32, err error) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		return nil, err
+	}
+	return this.SpoutOutputCollector.Emit(id, stream, fields...), nil
+}
+
+func (this *checkedSpoutOutputCollector) CheckedEmitWithTaskIds(id string, stream string, fields ...interface{}) (taskIds []int32, err error) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		return nil, err
+	}
+	return this.SpoutOutputCollector.EmitWithTaskIds(id, stream, fields...), nil
+}
+
+func (this *checkedSpoutOutputCollector) CheckedEmitDirect(id string, stream string, directTask int64, fields ...interface{}) (err error) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		return err
+	}
+	this.SpoutOutputCollector.EmitDirect(id, stream, directTask, fields...)
+	return nil
+}
+
+// check reports an error if the fields do not match the output schema
+func (this *checkedSpoutOutputCollector) check(stream string, fields []interface{}) {
+	if err := this.schema.Validate(stream, fields); err != nil {
+		this.ReportError(err)
+	}
+}
+
+func (this *checkedSpoutOutputCollector) Emit(id string, stream string, fields ...interface{}) (taskIds []int32) {
+	this.check(stream, fields)
+	return this.SpoutOutputCollector.Emit(id, stream, fields...)
+}
+
+func (this *checkedSpoutOutputCollector) EmitWithTaskIds(id string, stream string, fields ...interface{}) (taskIds []int32) {
+	this.check(stream, fields)
+	return this.SpoutOutputCollector.EmitWithTaskIds(id, stream, fields...)
+}
+
+func (this *checkedSpoutOutputCollector) EmitDirect(id string, stream string, directTask int64, fields ...interface{}) {
+	this.check(stream, fields)
+	this.SpoutOutputCollector.EmitDirect(id, stream, directTask, fields...)
+}
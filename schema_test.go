@@ -0,0 +1,66 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOutputSchema(t *testing.T) {
+	schema := NewOutputSchema()
+	schema.Declare("", "", 0)
+	schema.Declare("counts", map[string]int(nil), nil)
+
+	valid := []struct {
+		stream string
+		fields []interface{}
+	}{
+		{"", []interface{}{"word", 1}},
+		{"default", []interface{}{"word", 1}},
+		{"counts", []interface{}{map[string]int{"word": 1}, 3.5}},
+		{"counts", []interface{}{nil, "anything"}},
+	}
+	for _, emission := range valid {
+		if err := schema.Validate(emission.stream, emission.fields); err != nil {
+			t.Fatalf("Expected %v on stream %q to be valid: %v", emission.fields, emission.stream, err)
+		}
+	}
+
+	invalid := []struct {
+		stream string
+		fields []interface{}
+		field  int
+	}{
+		{"words", []interface{}{"word", 1}, -1},
+		{"", []interface{}{"word"}, -1},
+		{"", []interface{}{"word", int64(1)}, 1},
+		{"", []interface{}{nil, 1}, 0},
+	}
+	for _, emission := range invalid {
+		err := schema.Validate(emission.stream, emission.fields)
+		var schemaErr *SchemaError
+		if !errors.As(err, &schemaErr) {
+			t.Fatalf("Expected a schema error for %v on stream %q, received: %v", emission.fields, emission.stream, err)
+		}
+		if schemaErr.Field != emission.field {
+			t.Fatalf("Expected an error for field %d, received: %v", emission.field, err)
+		}
+	}
+
+	if streams := schema.Streams(); len(streams) != 2 || streams[0] != "counts" || streams[1] != "default" {
+		t.Fatalf("Unexpected declared streams: %v", streams)
+	}
+}
@@ -15,71 +15,288 @@
 package gostorm
 
 import (
+	"errors"
+	"fmt"
 	"github.com/jsgilmore/gostorm/core"
 	"github.com/jsgilmore/gostorm/messages"
 	"io"
 	"sync"
 )
 
+// DecodeErrorPolicy determines what a ShellBolt does with a tuple of
+// which the fields cannot be decoded into the structs returned by the
+// Fields function of its bolt.
+type DecodeErrorPolicy int
+
+const (
+	// FailOnDecodeError logs the decode error and fails the tuple
+	FailOnDecodeError DecodeErrorPolicy = iota
+	// DeadLetterOnDecodeError logs the decode error and emits the
+	// raw fields of the tuple, anchored to the tuple, on the
+	// DeadLetterStream, after which the tuple is acked.
+	DeadLetterOnDecodeError
+	// HookOnDecodeError passes the tuple to the OnDecodeError function
+	// of the bolt, which has to implement DecodeErrorHandler. The
+	// handler is responsible for acking or failing the tuple.
+	HookOnDecodeError
+)
+
+// DeadLetterStream is the stream on which tuples that could not be
+// decoded are emitted when the DeadLetterOnDecodeError policy is used.
+// The fields of dead letter tuples are emitted exactly as they were
+// received, so a bolt subscribed to the stream can decode them with
+// the same encoding.
+const DeadLetterStream = "deadletter"
+
+// DecodeErrorHandler is implemented by bolts that handle tuples that
+// could not be decoded themselves, using the HookOnDecodeError policy.
+// The raw fields are the fields of the tuple as they were encoded.
+type DecodeErrorHandler interface {
+	OnDecodeError(meta messages.BoltMsgMeta, rawFields [][]byte, err error)
+}
+
 type ShellBolt interface {
-	Go()
+	Go() error
 	Exit()
 	Initialise(boltConn core.BoltConn)
+	SetDecodeErrorPolicy(policy DecodeErrorPolicy)
+	SetConcurrency(workers, maxInFlight int)
 }
 
 type shellBoltImpl struct {
 	sync.Mutex
-	boltConn core.BoltConn
-	bolt     Bolt
-	meta     *messages.BoltMsgMeta
-	cleaned  bool
-	sent     int
+	boltConn     core.BoltConn
+	bolt         Bolt
+	meta         *messages.BoltMsgMeta
+	cleaned      bool
+	sent         int
+	decodePolicy DecodeErrorPolicy
+	watchdog     *watchdog
+	fields       []interface{}
+	workers      int
+	maxInFlight  int
+	serial       *serialBoltConn
+	// lastFields are the fields of the last tuple read by a concurrent
+	// bolt, which the fields of the next tuple may not reuse
+	lastFields []interface{}
+	// recoverPanics reports the panics of workers to Storm before the
+	// panic continues, as RunBolt does for the reading goroutine
+	recoverPanics bool
 }
 
 func NewShellBolt(bolt Bolt) ShellBolt {
+	return newShellBolt(bolt)
+}
+
+func newShellBolt(bolt Bolt) *shellBoltImpl {
 	return &shellBoltImpl{
 		bolt: bolt,
 		meta: &messages.BoltMsgMeta{},
 	}
 }
 
+// SetDecodeErrorPolicy sets what is done with tuples that cannot be
+// decoded. The default policy is FailOnDecodeError.
+func (this *shellBoltImpl) SetDecodeErrorPolicy(policy DecodeErrorPolicy) {
+	switch policy {
+	case FailOnDecodeError, DeadLetterOnDecodeError:
+	case HookOnDecodeError:
+		if _, ok := As[DecodeErrorHandler](this.bolt); !ok {
+			panic(fmt.Sprintf("ShellBolt: %T does not implement DecodeErrorHandler", this.bolt))
+		}
+	default:
+		panic(fmt.Sprintf("ShellBolt: Unknown decode error policy: %d", policy))
+	}
+	this.decodePolicy = policy
+}
+
+// SetConcurrency executes tuples on the given number of goroutines,
+// which is meant for bolts that spend most of their time waiting, e.g.
+// for HTTP lookups or database writes. The bolt's Execute and Tick
+// functions then have to be safe for concurrent use, while its output
+// collector can be used concurrently. Tuples are decoded while earlier
+// tuples are being executed, so the bolt's Fields function has to
+// return new values on every call; ShellBolt panics if it returns the
+// same values for consecutive tuples. At most maxInFlight tuples are
+// read from Storm before they have been executed; tuples that arrive
+// while all the workers are busy are queued, and no further tuples are
+// read until one of the tuples in flight returns. A maxInFlight smaller
+// than the number of workers is raised to the number of workers.
+// Tuples are acked, failed and emitted in the order in which this
+// happens, so the output of tuples may be interleaved.
+// SetConcurrency has to be called before Initialise and cannot be used
+// with a connection that needs task ids.
+func (this *shellBoltImpl) SetConcurrency(workers, maxInFlight int) {
+	if this.boltConn != nil {
+		panic("ShellBolt: SetConcurrency called after Initialise")
+	}
+	if workers < 1 {
+		panic(fmt.Sprintf("ShellBolt: Invalid number of workers: %d", workers))
+	}
+	if maxInFlight < workers {
+		maxInFlight = workers
+	}
+	this.workers = workers
+	this.maxInFlight = maxInFlight
+}
+
 func (this *shellBoltImpl) Initialise(boltConn core.BoltConn) {
 	this.boltConn = boltConn
 	this.boltConn.Connect()
-	this.bolt.Prepare(this.boltConn.Context(), this.boltConn)
+	if this.workers > 0 {
+		if boltConn.NeedTaskIds() {
+			panic("ShellBolt: Concurrent execution requires a connection that does not need task ids")
+		}
+		this.serial = newSerialBoltConn(boltConn)
+		this.boltConn = this.serial
+	}
+	var collector OutputCollector = this.boltConn
+	if schema := DeclareOutputSchema(this.bolt); schema != nil {
+		collector = NewCheckedOutputCollector(collector, schema)
+	}
+	this.bolt.Prepare(this.boltConn.Context(), collector)
+	this.watchdog = newWatchdog(subprocessTimeout(this.boltConn.Context()), this.boltConn.Warn)
+	this.watchdog.start()
 }
 
-func (this *shellBoltImpl) Go() {
+// IsTick reports whether a tuple is a tick tuple, which Storm sends at
+// the frequency set by topology.tick.tuple.freq.secs
+func IsTick(meta *messages.BoltMsgMeta) bool {
+	return meta.GetComp() == "__system" && meta.GetStream() == "__tick"
+}
+
+// isHeartbeat reports whether a tuple is a heartbeat sent by Storm
+func isHeartbeat(meta *messages.BoltMsgMeta) bool {
+	return meta.GetStream() == "__heartbeat"
+}
+
+// tupleFields returns the structs into which the fields of a tuple are
+// decoded, which depend on the input of the tuple if the bolt is an
+// InputFieldsFactory. The fields of heartbeat and tick tuples are not decoded.
+func (this *shellBoltImpl) tupleFields(meta *messages.BoltMsgMeta) []interface{} {
+	if isHeartbeat(meta) || IsTick(meta) {
+		this.fields = nil
+	} else {
+		this.fields = BoltFields(this.bolt, meta.GetComp(), meta.GetStream())
+		if this.workers > 0 {
+			checkNewFields(this.bolt, this.lastFields, this.fields)
+			this.lastFields = this.fields
+		}
+	}
+	return this.fields
+}
+
+// Go executes the tuples received from Storm until Storm closes the
+// input, after which the bolt is cleaned up. Messages that cannot be
+// read, as opposed to tuples of which the fields cannot be decoded, also
+// stop the bolt, and the error is returned.
+func (this *shellBoltImpl) Go() error {
+	if this.workers > 0 {
+		return this.goConcurrent()
+	}
+	var err error
 	for {
-		fields := this.bolt.Fields()
-		err := this.boltConn.ReadBoltMsg(this.meta, fields...)
-		if err == io.EOF {
-			this.Exit()
-			return
+		if err = this.readTuple(); err != nil {
+			break
+		}
+		this.execute(this.meta, this.fields)
+		this.sent++
+	}
+	this.Exit()
+	return closedInput(err)
+}
+
+// closedInput returns nil for the io.EOF returned once Storm has closed
+// the input, which is how a component stops normally
+func closedInput(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// readTuple reads messages from Storm until a tuple has been read that
+// has to be executed, which is left in meta and fields. Heartbeats are
+// answered and tuples of which the fields cannot be decoded are
+// rejected. Any other error, such as a malformed message or a failure
+// to read from Storm, is returned, and io.EOF is returned once the
+// input has been closed.
+func (this *shellBoltImpl) readTuple() error {
+	for {
+		err := this.boltConn.ReadBoltMsgFunc(this.meta, this.tupleFields)
+		var encodingErr *core.Error
+		if errors.As(err, &encodingErr) && encodingErr.Field >= 0 && len(encodingErr.Id) > 0 {
+			this.rejectTuple(encodingErr)
+			continue
 		}
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		if this.cleaned {
 			panic("ShellBolt: Cleaned up bolt expected to execute")
 		}
 
-		if this.meta.GetStream() == "__heartbeat" {
+		if isHeartbeat(this.meta) {
 			this.boltConn.SendSync()
 			continue
 		}
+		return nil
+	}
+}
 
-		this.bolt.Execute(*this.meta, fields...)
-		this.sent++
+// execute passes a tuple to the bolt, or to its Tick function if it is
+// a tick tuple, while the watchdog keeps track of how long it runs
+func (this *shellBoltImpl) execute(meta *messages.BoltMsgMeta, fields []interface{}) {
+	callback := "Execute"
+	if IsTick(meta) {
+		callback = "Tick"
+	}
+	call := this.watchdog.enter(callback)
+	defer this.watchdog.exit(call)
+	if IsTick(meta) {
+		if ticker, ok := As[Ticker](this.bolt); ok {
+			ticker.Tick(*meta)
+		}
+		// Storm keeps every tuple sent to a shell bolt until it is acked
+		this.boltConn.SendAck(meta.Id)
+		return
+	}
+	this.bolt.Execute(*meta, fields...)
+}
+
+// rejectTuple handles a tuple of which a field could not be decoded
+// according to the decode error policy, instead of crashing the worker.
+// The metadata of the tuple has been read completely.
+func (this *shellBoltImpl) rejectTuple(err *core.Error) {
+	if this.decodePolicy == HookOnDecodeError {
+		handler, _ := As[DecodeErrorHandler](this.bolt)
+		handler.OnDecodeError(*this.meta, err.Raw, err)
+		return
+	}
+
+	this.boltConn.Log(fmt.Sprintf("ShellBolt: Tuple could not be decoded: %v", err))
+	if this.decodePolicy == DeadLetterOnDecodeError {
+		fields := make([]interface{}, len(err.Raw))
+		for i, raw := range err.Raw {
+			fields[i] = core.RawField(raw)
+		}
+		this.boltConn.Emit([]string{err.Id}, DeadLetterStream, fields...)
+		this.boltConn.SendAck(err.Id)
+		return
 	}
+	this.boltConn.SendFail(err.Id)
 }
 
 func (this *shellBoltImpl) Exit() {
 	this.Lock()
 	defer this.Unlock()
 	if !this.cleaned {
+		this.watchdog.stop()
 		this.bolt.Cleanup()
 		this.cleaned = true
+		if this.serial != nil {
+			this.serial.close()
+		}
 	}
 }
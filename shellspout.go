@@ -17,12 +17,21 @@ package gostorm
 import (
 	"fmt"
 	"github.com/jsgilmore/gostorm/core"
-	"io"
 	"sync"
 )
 
+// spoutCallbacks maps the commands received from Storm to the spout
+// functions they call, for reporting by the watchdog
+var spoutCallbacks = map[string]string{
+	"next":       "NextTuple",
+	"ack":        "Acked",
+	"fail":       "Failed",
+	"activate":   "Activate",
+	"deactivate": "Deactivate",
+}
+
 type ShellSpout interface {
-	Go()
+	Go() error
 	Exit()
 	Initialise(spoutConn core.SpoutConn)
 }
@@ -32,6 +41,7 @@ type shellSpoutImpl struct {
 	spoutConn core.SpoutConn
 	spout     Spout
 	cleaned   bool
+	watchdog  *watchdog
 }
 
 func NewShellSpout(spout Spout) ShellSpout {
@@ -43,28 +53,35 @@ func NewShellSpout(spout Spout) ShellSpout {
 func (this *shellSpoutImpl) Initialise(spoutConn core.SpoutConn) {
 	this.spoutConn = spoutConn
 	this.spoutConn.Connect()
-	this.spout.Open(this.spoutConn.Context(), this.spoutConn)
+	var collector SpoutOutputCollector = this.spoutConn
+	if schema := DeclareOutputSchema(this.spout); schema != nil {
+		collector = NewCheckedSpoutOutputCollector(collector, schema)
+	}
+	this.spout.Open(this.spoutConn.Context(), collector)
+	this.watchdog = newWatchdog(subprocessTimeout(this.spoutConn.Context()), this.spoutConn.Warn)
+	this.watchdog.start()
 }
 
-func (this *shellSpoutImpl) Go() {
+// Go passes the messages received from Storm to the spout until Storm
+// closes the input, after which the spout is cleaned up. An error
+// reading from Storm also stops the spout and is returned.
+func (this *shellSpoutImpl) Go() error {
 	for {
 		// This lock prevents the spout exit function being called
 		// concurrently with another function. The lock is above the
 		// ReadSpoutMsg to ensure that any messages read from the spout
 		// in channel will make it to the spout before exit is called.
 		command, id, err := this.spoutConn.ReadSpoutMsg()
-		if err == io.EOF {
-			this.Exit()
-			return
-		}
 		if err != nil {
-			panic(err)
+			this.Exit()
+			return closedInput(err)
 		}
 		this.Lock()
 		if this.cleaned && command != "next" {
 			panic(fmt.Sprintf("ShellSpout: %s message sent to cleaned up spout", command))
 		}
 
+		call := this.watchdog.enter(spoutCallbacks[command])
 		switch command {
 		case "next":
 			this.spout.NextTuple()
@@ -72,9 +89,18 @@ func (this *shellSpoutImpl) Go() {
 			this.spout.Acked(id)
 		case "fail":
 			this.spout.Failed(id)
+		case "activate":
+			if activator, ok := As[Activator](this.spout); ok {
+				activator.Activate()
+			}
+		case "deactivate":
+			if deactivator, ok := As[Deactivator](this.spout); ok {
+				deactivator.Deactivate()
+			}
 		default:
 			panic(fmt.Sprintf("ShellSpout: Unknown command received from Storm: %s", command))
 		}
+		this.watchdog.exit(call)
 		this.spoutConn.SendSync()
 		this.Unlock()
 	}
@@ -84,6 +110,7 @@ func (this *shellSpoutImpl) Exit() {
 	this.Lock()
 	defer this.Unlock()
 	if !this.cleaned {
+		this.watchdog.stop()
 		this.spout.Exit()
 		this.cleaned = true
 	}
@@ -0,0 +1,111 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// NewLogHandler returns a slog.Handler that logs records in the Storm
+// worker log using the given logger, which is typically the collector
+// of a bolt or spout. Records are formatted as by slog.TextHandler,
+// without the time and level, since Storm adds both. Slog levels below
+// info are logged at the debug level.
+//
+// Storm does not allow messages to be sent concurrently with other bolt
+// or spout functions, so the handler should only be used from within
+// those functions.
+func NewLogHandler(logger LevelLogger, opts *slog.HandlerOptions) slog.Handler {
+	handlerOpts := slog.HandlerOptions{}
+	if opts != nil {
+		handlerOpts = *opts
+	}
+	replace := handlerOpts.ReplaceAttr
+	handlerOpts.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+		if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		if replace != nil {
+			return replace(groups, attr)
+		}
+		return attr
+	}
+
+	buffer := &logBuffer{}
+	return &logHandler{
+		logger: logger,
+		buffer: buffer,
+		text:   slog.NewTextHandler(buffer, &handlerOpts),
+	}
+}
+
+// logBuffer is the buffer into which records are formatted. It is
+// shared by all the handlers derived from the same handler.
+type logBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+type logHandler struct {
+	logger LevelLogger
+	buffer *logBuffer
+	text   slog.Handler
+}
+
+func (this *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return this.text.Enabled(ctx, level)
+}
+
+func (this *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	this.buffer.Lock()
+	defer this.buffer.Unlock()
+	this.buffer.Reset()
+	err := this.text.Handle(ctx, record)
+	if err != nil {
+		return err
+	}
+	msg := string(bytes.TrimRight(this.buffer.Bytes(), "\n"))
+
+	switch {
+	case record.Level < slog.LevelInfo:
+		this.logger.Debug(msg)
+	case record.Level < slog.LevelWarn:
+		this.logger.Info(msg)
+	case record.Level < slog.LevelError:
+		this.logger.Warn(msg)
+	default:
+		this.logger.Error(msg)
+	}
+	return nil
+}
+
+func (this *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{
+		logger: this.logger,
+		buffer: this.buffer,
+		text:   this.text.WithAttrs(attrs),
+	}
+}
+
+func (this *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{
+		logger: this.logger,
+		buffer: this.buffer,
+		text:   this.text.WithGroup(name),
+	}
+}
@@ -0,0 +1,177 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// StdoutRedirect determines where writes to stdout go while a component runs
+type StdoutRedirect int
+
+const (
+	// StdoutUnchanged leaves stdout alone, so that anything written to
+	// stdout is mixed with the messages sent to Storm
+	StdoutUnchanged StdoutRedirect = iota
+	// StdoutToStderr writes anything written to stdout to stderr
+	StdoutToStderr
+	// StdoutToLog sends every line written to stdout to Storm as a log message
+	StdoutToLog
+)
+
+// stdoutGuard keeps writes to stdout from reaching Storm while a
+// component runs. os.Stdout is pointed at stderr or at a pipe that is
+// read by a goroutine that logs every line. The pipe is read from the
+// moment it is created, so that writes never block on a full pipe, and
+// lines are kept until they can be logged. If file descriptor 1 is
+// redirected as well, it is first duplicated for the messages sent to
+// Storm, which also keeps the output of C code and child processes
+// from reaching Storm.
+type stdoutGuard struct {
+	stdout   *os.File
+	protocol *os.File
+	pipe     *os.File
+	lines    *os.File
+	captured chan struct{}
+
+	sync.Mutex
+	logger  func(msg string)
+	pending []string
+}
+
+// guardStdout redirects stdout and returns the guard and the file to
+// which the messages for Storm have to be written
+func guardStdout(redirect StdoutRedirect, redirectFd bool) (guard *stdoutGuard, protocol *os.File, err error) {
+	guard = &stdoutGuard{
+		stdout: os.Stdout,
+	}
+	if redirect == StdoutUnchanged {
+		return guard, os.Stdout, nil
+	}
+
+	target := os.Stderr
+	if redirect == StdoutToLog {
+		guard.lines, guard.pipe, err = os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		target = guard.pipe
+	}
+
+	protocol = os.Stdout
+	if redirectFd {
+		fd, err := dup(int(os.Stdout.Fd()))
+		if err != nil {
+			guard.closePipe()
+			return nil, nil, err
+		}
+		guard.protocol = os.NewFile(uintptr(fd), "stdout")
+		if err := dupFd(int(target.Fd()), int(os.Stdout.Fd())); err != nil {
+			guard.protocol.Close()
+			guard.closePipe()
+			return nil, nil, err
+		}
+		protocol = guard.protocol
+	}
+	os.Stdout = target
+	if guard.lines != nil {
+		guard.captured = make(chan struct{})
+		go guard.drain()
+	}
+	return guard, protocol, nil
+}
+
+// drain reads the lines written to stdout until the pipe is closed.
+// Lines are logged once capture has been called and kept until then.
+// Lines that were never logged are written to stderr.
+func (this *stdoutGuard) drain() {
+	defer close(this.captured)
+	scanner := bufio.NewScanner(this.lines)
+	for scanner.Scan() {
+		this.Lock()
+		if this.logger == nil {
+			this.pending = append(this.pending, scanner.Text())
+		} else {
+			logLine(this.logger, scanner.Text())
+		}
+		this.Unlock()
+	}
+	// Drain the pipe if a line is too long to scan
+	io.Copy(os.Stderr, this.lines)
+
+	this.Lock()
+	defer this.Unlock()
+	for _, line := range this.pending {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	this.pending = nil
+}
+
+// capture starts logging the lines written to stdout if they are sent
+// to Storm, starting with the lines that were written before. Logging
+// has to start after the component has connected to Storm. Lines that
+// cannot be logged are written to stderr.
+func (this *stdoutGuard) capture(logger func(msg string)) {
+	if this.lines == nil {
+		return
+	}
+	this.Lock()
+	defer this.Unlock()
+	this.logger = logger
+	for _, line := range this.pending {
+		logLine(logger, line)
+	}
+	this.pending = nil
+}
+
+func logLine(logger func(msg string), line string) {
+	defer func() {
+		if recover() != nil {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}()
+	logger(line)
+}
+
+// restore points stdout back at the original stdout, after waiting for
+// all the captured lines to be logged
+func (this *stdoutGuard) restore() {
+	if this.protocol != nil {
+		if err := dupFd(int(this.protocol.Fd()), int(this.stdout.Fd())); err != nil {
+			fmt.Fprintf(os.Stderr, "GoStorm: Could not restore stdout: %v\n", err)
+		}
+	}
+	os.Stdout = this.stdout
+	if this.pipe != nil {
+		this.pipe.Close()
+		this.pipe = nil
+		<-this.captured
+		this.lines.Close()
+	}
+	if this.protocol != nil {
+		this.protocol.Close()
+	}
+}
+
+func (this *stdoutGuard) closePipe() {
+	if this.pipe != nil {
+		this.pipe.Close()
+		this.lines.Close()
+	}
+}
@@ -19,7 +19,6 @@ import (
 	"github.com/jsgilmore/gostorm/core"
 	_ "github.com/jsgilmore/gostorm/encodings"
 	stormmsg "github.com/jsgilmore/gostorm/messages"
-	"os"
 )
 
 type Bolt interface {
@@ -37,36 +36,77 @@ type Spout interface {
 	Open(context *stormmsg.Context, collector SpoutOutputCollector)
 }
 
+// Ticker is implemented by bolts that want to receive tick tuples,
+// which Storm sends at the frequency set by topology.tick.tuple.freq.secs.
+// Tick tuples are acked after Tick returns, so Tick should not ack them.
+// Bolts that do not implement Ticker never receive tick tuples.
+type Ticker interface {
+	Tick(meta stormmsg.BoltMsgMeta)
+}
+
+// Activator is implemented by spouts that want to be informed when
+// their topology is activated. Storm activates a spout after it has
+// been opened and after the topology was deactivated.
+type Activator interface {
+	Activate()
+}
+
+// Deactivator is implemented by spouts that want to be informed when
+// their topology is deactivated. NextTuple is not called while a
+// topology is deactivated, but tuples may still be acked or failed.
+type Deactivator interface {
+	Deactivate()
+}
+
 type SpoutOutputCollector interface {
+	LevelLogger
+	MetricsCollector
 	Log(msg string)
+	ReportError(err error)
 	Emit(id string, stream string, fields ...interface{}) (taskIds []int32)
+	EmitWithTaskIds(id string, stream string, fields ...interface{}) (taskIds []int32)
 	EmitDirect(id string, stream string, directTask int64, fields ...interface{})
 }
 
 type OutputCollector interface {
+	LevelLogger
+	MetricsCollector
 	Log(msg string)
+	ReportError(err error)
 	SendAck(id string)
 	SendFail(id string)
 	Emit(anchors []string, stream string, fields ...interface{}) (taskIds []int32)
+	EmitWithTaskIds(anchors []string, stream string, fields ...interface{}) (taskIds []int32)
 	EmitDirect(anchors []string, stream string, directTask int64, fields ...interface{})
 }
 
-type FieldsFactory interface {
-	Fields() []interface{}
+// LevelLogger logs messages in the Storm worker log at the given level.
+// Storm versions before 0.10 log all messages at the info level.
+type LevelLogger interface {
+	Debug(msg string)
+	Info(msg string)
+	Warn(msg string)
+	Error(msg string)
+}
+
+// MetricsCollector sends values to metrics registered in the topology.
+// Registered metrics are sent every time a sync is sent to Storm, which
+// is after every spout function and every bolt heartbeat.
+type MetricsCollector interface {
+	Metric(name string, value interface{})
+	RegisterMetric(name string, metric core.Metric)
 }
 
-func RunBolt(bolt Bolt, encoding string) {
-	boltConn := core.LookupBoltConn(encoding, os.Stdin, os.Stdout)
-	shellBolt := NewShellBolt(bolt)
-	shellBolt.Initialise(boltConn)
-	shellBolt.Go()
-	shellBolt.Exit()
+type FieldsFactory interface {
+	Fields() []interface{}
 }
 
-func RunSpout(spout Spout, encoding string) {
-	spoutConn := core.LookupSpoutConn(encoding, os.Stdin, os.Stdout)
-	shellSpout := NewShellSpout(spout)
-	shellSpout.Initialise(spoutConn)
-	shellSpout.Go()
-	shellSpout.Exit()
+// InputFieldsFactory is implemented by bolts that receive tuples with
+// different fields from different components or streams. ShellBolt
+// peeks at the component and stream of a tuple before decoding its
+// fields into the structs returned by InputFields. The fields returned
+// by Fields are used for inputs for which InputFields returns nil.
+// FieldsMux implements InputFieldsFactory.
+type InputFieldsFactory interface {
+	InputFields(comp, stream string) []interface{}
 }
@@ -15,16 +15,30 @@
 package test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jsgilmore/gostorm"
 	stormcore "github.com/jsgilmore/gostorm/core"
 	stormenc "github.com/jsgilmore/gostorm/encodings/json"
+	stormmsgpack "github.com/jsgilmore/gostorm/encodings/msgpack"
+	_ "github.com/jsgilmore/gostorm/encodings/protobuf"
 	"github.com/jsgilmore/gostorm/messages"
 	"io"
+	"log/slog"
 	"math/rand"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -116,8 +130,43 @@ func checkErr(err error, t *testing.T) {
 	}
 }
 
+// pidDir is the directory in which the components write their pid files
+var pidDir string
+
+// TestMain points the pid directory of the context sent to components
+// at a temporary directory, so that tests do not leave pid files behind
+// pidDirEnv passes the pid directory to tests that run in a subprocess
+const pidDirEnv = "GOSTORM_TEST_PID_DIR"
+
+func TestMain(m *testing.M) {
+	var err error
+	pidDir = os.Getenv(pidDirEnv)
+	if len(pidDir) > 0 {
+		// The pid directory is removed by the parent test process
+		setPidDir()
+		os.Exit(m.Run())
+	}
+	pidDir, err = os.MkdirTemp("", "gostorm-test")
+	if err != nil {
+		panic(err)
+	}
+	setPidDir()
+	code := m.Run()
+	os.RemoveAll(pidDir)
+	os.Exit(code)
+}
+
+// setPidDir makes Storm send the pid directory in the configuration
+func setPidDir() {
+	dir, err := json.Marshal(pidDir)
+	if err != nil {
+		panic(err)
+	}
+	conf = bytes.Replace(conf, []byte(`"pidDir":""`), []byte(`"pidDir":`+string(dir)), 1)
+}
+
 func checkPidFile(t *testing.T) {
-	pidFilename := fmt.Sprintf("%d", os.Getpid())
+	pidFilename := filepath.Join(pidDir, fmt.Sprintf("%d", os.Getpid()))
 	err := os.Remove(pidFilename)
 	checkErr(err, t)
 }
@@ -432,10 +481,10 @@ func TestReadMsg(t *testing.T) {
 		command, id, err := spoutConn.ReadSpoutMsg()
 		checkErr(err, t)
 		if command != spoutMsgs[i].Command {
-			t.Fatalf(fmt.Sprintf("Incorrect command received: expected: %s, received: %s", spoutMsgs[i].Command, command))
+			t.Fatalf("Incorrect command received: expected: %s, received: %s", spoutMsgs[i].Command, command)
 		}
 		if id != spoutMsgs[i].Id {
-			t.Fatalf(fmt.Sprintf("Incorrect id received: expected: %s, received: %s", spoutMsgs[i].Id, id))
+			t.Fatalf("Incorrect id received: expected: %s, received: %s", spoutMsgs[i].Id, id)
 		}
 	}
 
@@ -462,3 +511,1824 @@ func TestSendSync(t *testing.T) {
 
 	checkPidFile(t)
 }
+
+func TestReadTupleDecodeError(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	feedConf(buffer, t)
+	writeMsg(genBoltMsg(ids[0], 42), buffer, t)
+	writeMsg(testBoltMsg(1), buffer, t)
+
+	input := stormenc.NewJsonObjectInput(buffer)
+	output := stormenc.NewJsonObjectOutput(os.Stdout)
+	boltConn := stormcore.NewCheckedBoltConn(input, output, true)
+	checkErr(boltConn.Connect(), t)
+
+	var msg string
+	meta := &messages.BoltMsgMeta{}
+	err := boltConn.ReadBoltMsg(meta, &msg)
+	encodingErr, ok := err.(*stormcore.Error)
+	if !ok {
+		t.Fatalf("Expected an encoding error, received: %v", err)
+	}
+	if encodingErr.Encoding != "jsonObject" || encodingErr.Msg != "BoltMsg" || encodingErr.Id != ids[0] || encodingErr.Field != 0 {
+		t.Fatalf("Unexpected encoding error: %+v", encodingErr)
+	}
+	if len(encodingErr.Raw) != 1 || string(encodingErr.Raw[0]) != "42" {
+		t.Fatalf("Unexpected raw fields: %q", encodingErr.Raw)
+	}
+
+	// A bad tuple should not prevent the next tuple from being read
+	err = boltConn.ReadBoltMsg(meta, &msg)
+	checkErr(err, t)
+	msgCheck(msg, contents[1], t)
+	metaTest(meta, 1, t)
+
+	checkPidFile(t)
+}
+
+func TestSpoutNotReady(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	spoutConn := stormcore.NewCheckedSpoutConn(input, output, false)
+	checkErr(spoutConn.Connect(), t)
+
+	_, err := spoutConn.Emit("1", "", contents[0])
+	if err != stormcore.ErrSpoutNotReady {
+		t.Fatalf("Expected emission before a spout message to fail, received: %v", err)
+	}
+
+	checkPidFile(t)
+}
+
+func TestTruncatedInput(t *testing.T) {
+	inBuffer := bytes.NewBufferString(`{"pidDir":""`)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(bytes.NewBuffer(nil))
+	boltConn := stormcore.NewCheckedBoltConn(input, output, true)
+	if err := boltConn.Connect(); err == nil {
+		t.Fatal("Expected connecting with a truncated context to fail")
+	}
+}
+
+type recordBolt struct {
+	collector gostorm.OutputCollector
+	received  []string
+	rejected  [][]byte
+	ticks     int
+}
+
+func (this *recordBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *recordBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.received = append(this.received, *fields[0].(*string))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *recordBolt) OnDecodeError(meta messages.BoltMsgMeta, rawFields [][]byte, err error) {
+	this.rejected = append(this.rejected, rawFields...)
+	this.collector.SendFail(meta.Id)
+}
+
+func (this *recordBolt) Tick(meta messages.BoltMsgMeta) {
+	this.ticks++
+}
+
+func (this *recordBolt) Cleanup() {}
+
+func (this *recordBolt) Fields() []interface{} {
+	var msg string
+	return []interface{}{&msg}
+}
+
+// minimalInput only implements the methods required of a core.Input
+type minimalInput struct {
+	input stormcore.Input
+}
+
+func (this *minimalInput) ReadMsg(msg interface{}) error {
+	return this.input.ReadMsg(msg)
+}
+
+func (this *minimalInput) ReadTaskIds() ([]int32, error) {
+	return this.input.ReadTaskIds()
+}
+
+func (this *minimalInput) ReadBoltMsg(meta *messages.BoltMsgMeta, contentStructs ...interface{}) error {
+	return this.input.ReadBoltMsg(meta, contentStructs...)
+}
+
+func TestMinimalInput(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+	writeMsg(newJsonBoltMsg("", "", "__heartbeat", -1), inBuffer, t)
+	writeMsg(testBoltMsg(1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := &minimalInput{stormenc.NewJsonObjectInput(inBuffer)}
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &recordBolt{}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	checkErr(shellBolt.Go(), t)
+
+	expectPid(outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(`{"command":"sync"}`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+	if fmt.Sprint(bolt.received) != fmt.Sprint(contents[:2]) {
+		t.Fatalf("Expected %v, received: %v", contents[:2], bolt.received)
+	}
+
+	checkPidFile(t)
+}
+
+func expectPrefix(prefix string, buffer *bytes.Buffer, t *testing.T) {
+	recv, err := buffer.ReadString('\n')
+	checkErr(err, t)
+	if !strings.HasPrefix(recv, prefix) {
+		t.Fatalf("Expected: %s..., received: %s", prefix, recv)
+	}
+}
+
+func runDecodeErrorPolicy(policy gostorm.DecodeErrorPolicy, t *testing.T) (*recordBolt, *bytes.Buffer) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(genBoltMsg(ids[0], 42), inBuffer, t)
+	writeMsg(testBoltMsg(1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &recordBolt{}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.SetDecodeErrorPolicy(policy)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	checkPidFile(t)
+	if len(bolt.received) != 1 || bolt.received[0] != contents[1] {
+		t.Fatalf("Expected only the valid tuple to be executed, executed: %v", bolt.received)
+	}
+	return bolt, outBuffer
+}
+
+func TestFailOnDecodeError(t *testing.T) {
+	_, outBuffer := runDecodeErrorPolicy(gostorm.FailOnDecodeError, t)
+
+	expectPrefix(`{"command":"log"`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"fail","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+}
+
+func TestDeadLetterOnDecodeError(t *testing.T) {
+	_, outBuffer := runDecodeErrorPolicy(gostorm.DeadLetterOnDecodeError, t)
+
+	expectPrefix(`{"command":"log"`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"stream":"%s","tuple":[42]}`, ids[0], gostorm.DeadLetterStream), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+}
+
+func TestHookOnDecodeError(t *testing.T) {
+	bolt, outBuffer := runDecodeErrorPolicy(gostorm.HookOnDecodeError, t)
+
+	if len(bolt.rejected) != 1 || string(bolt.rejected[0]) != "42" {
+		t.Fatalf("Expected the raw field to be passed to the hook, received: %q", bolt.rejected)
+	}
+	expect(fmt.Sprintf(`{"command":"fail","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+}
+
+func TestTooManyFields(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	tooMany := testBoltMsg(0)
+	tooMany.BoltMsgJson.Contents = append(tooMany.BoltMsgJson.Contents, contents[1])
+	writeMsg(tooMany, inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	bolt := &recordBolt{}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.SetDecodeErrorPolicy(gostorm.HookOnDecodeError)
+	shellBolt.Initialise(stormcore.NewBoltConn(stormenc.NewJsonObjectInput(inBuffer), stormenc.NewJsonObjectOutput(outBuffer), false))
+	checkErr(shellBolt.Go(), t)
+
+	// The tuple was read completely, so it is rejected like a field
+	// that could not be decoded
+	if len(bolt.received) != 0 || len(bolt.rejected) != 2 {
+		t.Fatalf("Expected the tuple to be rejected, executed: %v, rejected: %q", bolt.received, bolt.rejected)
+	}
+	expectPid(outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"fail","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+func TestMalformedMsg(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+	inBuffer.WriteString("{\"id\":\"" + ids[1] + "\",\"tuple\":\nend\n")
+	writeMsg(testBoltMsg(2), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	bolt := &recordBolt{}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.SetDecodeErrorPolicy(gostorm.HookOnDecodeError)
+	shellBolt.Initialise(stormcore.NewBoltConn(stormenc.NewJsonObjectInput(inBuffer), stormenc.NewJsonObjectOutput(outBuffer), false))
+
+	// A message that cannot be read is not rejected as a tuple, but
+	// stops the bolt
+	err := shellBolt.Go()
+	var encodingErr *stormcore.Error
+	if !errors.As(err, &encodingErr) || encodingErr.Field >= 0 {
+		t.Fatalf("Expected the malformed message to be returned, received: %v", err)
+	}
+	if !reflect.DeepEqual(bolt.received, []string{contents[0]}) || len(bolt.rejected) != 0 {
+		t.Fatalf("Expected only the first tuple to be executed, executed: %v, rejected: %q", bolt.received, bolt.rejected)
+	}
+	expectPid(outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	if outBuffer.Len() > 0 {
+		t.Fatalf("Unexpected output: %s", outBuffer.String())
+	}
+
+	checkPidFile(t)
+}
+
+func TestReportError(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	boltConn := stormcore.NewBoltConn(input, output, true)
+	boltConn.Connect()
+
+	expectPid(outBuffer, t)
+
+	boltConn.ReportError(fmt.Errorf("Tuple %s could not be processed", ids[0]))
+	expect(fmt.Sprintf(`{"command":"error","msg":"Tuple %s could not be processed"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	// Nil errors should not be reported
+	boltConn.ReportError(nil)
+	if outBuffer.Len() != 0 {
+		t.Fatalf("Unexpected output after reporting nil error: %s", outBuffer.String())
+	}
+
+	checkPidFile(t)
+}
+
+func TestLogLevels(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	boltConn := stormcore.NewBoltConn(input, output, true)
+	boltConn.Connect()
+
+	expectPid(outBuffer, t)
+
+	logFuncs := []func(string){boltConn.Debug, boltConn.Info, boltConn.Warn, boltConn.Error}
+	for i, logFunc := range logFuncs {
+		msg := fmt.Sprintf("%d", rand.Int63())
+		logFunc(msg)
+		expect(fmt.Sprintf(`{"command":"log","level":%d,"msg":"%s"}`, i+1, msg), outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+
+	logger := slog.New(gostorm.NewLogHandler(boltConn, nil)).With("task", 3)
+	logger.Warn("Slow tuple", "ms", 120)
+	expect(`{"command":"log","level":3,"msg":"msg=\"Slow tuple\" task=3 ms=120"}`, outBuffer, t)
+	expect("end", outBuffer, t)
+
+	// Records below the handler level should not be sent
+	logger.Debug("Not logged")
+	if outBuffer.Len() != 0 {
+		t.Fatalf("Unexpected output after logging below the handler level: %s", outBuffer.String())
+	}
+
+	checkPidFile(t)
+}
+
+func TestMetrics(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	boltConn := stormcore.NewBoltConn(input, output, true)
+	boltConn.Connect()
+
+	expectPid(outBuffer, t)
+
+	count := &stormcore.CountMetric{}
+	latency := &stormcore.MeanMetric{}
+	boltConn.RegisterMetric("count", count)
+	boltConn.RegisterMetric("latency", latency)
+	count.Incr()
+	count.IncrBy(2)
+	latency.Update(1)
+	latency.Update(2)
+
+	boltConn.Metric("queue", map[string]int{"size": 5})
+	boltConn.SendSync()
+	expect(`{"command":"metrics","name":"queue","params":{"size":5}}`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(`{"command":"metrics","name":"count","params":3}`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(`{"command":"metrics","name":"latency","params":1.5}`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(`{"command":"sync"}`, outBuffer, t)
+	expect("end", outBuffer, t)
+
+	// Metrics without new values should not be sent
+	boltConn.SendSync()
+	expect(`{"command":"sync"}`, outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+type lifecycleSpout struct {
+	calls []string
+}
+
+func (this *lifecycleSpout) Open(context *messages.Context, collector gostorm.SpoutOutputCollector) {}
+
+func (this *lifecycleSpout) NextTuple() {
+	this.calls = append(this.calls, "next")
+}
+
+func (this *lifecycleSpout) Acked(id string) {}
+
+func (this *lifecycleSpout) Failed(id string) {}
+
+func (this *lifecycleSpout) Exit() {}
+
+func (this *lifecycleSpout) Activate() {
+	this.calls = append(this.calls, "activate")
+}
+
+func (this *lifecycleSpout) Deactivate() {
+	this.calls = append(this.calls, "deactivate")
+}
+
+func TestSpoutActivation(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	commands := []string{"activate", "next", "deactivate", "activate"}
+	for _, command := range commands {
+		writeMsg(newSpoutMsg(command, ""), inBuffer, t)
+	}
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	spout := &lifecycleSpout{}
+	shellSpout := gostorm.NewShellSpout(spout)
+	shellSpout.Initialise(stormcore.NewSpoutConn(input, output, false))
+	shellSpout.Go()
+
+	expectPid(outBuffer, t)
+	if strings.Join(spout.calls, ",") != strings.Join(commands, ",") {
+		t.Fatalf("Expected calls %v, received: %v", commands, spout.calls)
+	}
+	for range commands {
+		expect(`{"command":"sync"}`, outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+
+	checkPidFile(t)
+}
+
+func TestTick(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	tick := newJsonBoltMsg(ids[0], "__system", "__tick", -1)
+	tick.BoltMsgJson.Contents = append(tick.BoltMsgJson.Contents, 5)
+	writeMsg(tick, inBuffer, t)
+	writeMsg(testBoltMsg(1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &recordBolt{}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	if bolt.ticks != 1 || len(bolt.received) != 1 {
+		t.Fatalf("Expected a single tick and tuple, ticks: %d, tuples: %v", bolt.ticks, bolt.received)
+	}
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+type joinBolt struct {
+	gostorm.FieldsMux
+	collector gostorm.OutputCollector
+	received  []string
+}
+
+func newJoinBolt() *joinBolt {
+	bolt := &joinBolt{}
+	bolt.Handle("users", "", func() []interface{} {
+		var name string
+		var age int
+		return []interface{}{&name, &age}
+	})
+	bolt.Handle("orders", "*", func() []interface{} {
+		var amount int
+		return []interface{}{&amount}
+	})
+	return bolt
+}
+
+func (this *joinBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *joinBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	var received string
+	switch meta.Comp {
+	case "users":
+		received = fmt.Sprintf("%s:%d", *fields[0].(*string), *fields[1].(*int))
+	case "orders":
+		received = fmt.Sprintf("%s:%d", meta.Stream, *fields[0].(*int))
+	default:
+		received = *fields[0].(*string)
+	}
+	this.received = append(this.received, received)
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *joinBolt) Cleanup() {}
+
+func (this *joinBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+func TestInputFields(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	user := newJsonBoltMsg(ids[0], "users", "default", 1)
+	user.BoltMsgJson.Contents = append(user.BoltMsgJson.Contents, "alice", 42)
+	writeMsg(user, inBuffer, t)
+	order := newJsonBoltMsg(ids[1], "orders", "created", 2)
+	order.BoltMsgJson.Contents = append(order.BoltMsgJson.Contents, 100)
+	writeMsg(order, inBuffer, t)
+	writeMsg(testBoltMsg(2), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := newJoinBolt()
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	expected := []string{"alice:42", "created:100", contents[2]}
+	if strings.Join(bolt.received, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, received: %v", expected, bolt.received)
+	}
+	for i := range expected {
+		expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[i]), outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+
+	checkPidFile(t)
+}
+
+// basicJoinBolt is a basic bolt with different fields for each input
+type basicJoinBolt struct {
+	gostorm.FieldsMux
+	received []string
+}
+
+func newBasicJoinBolt() *basicJoinBolt {
+	bolt := &basicJoinBolt{}
+	bolt.Handle("users", "", func() []interface{} {
+		var name string
+		var age int
+		return []interface{}{&name, &age}
+	})
+	return bolt
+}
+
+func (this *basicJoinBolt) Prepare(context *messages.Context, collector gostorm.BasicOutputCollector) {
+}
+
+func (this *basicJoinBolt) Execute(meta messages.BoltMsgMeta, collector gostorm.BasicOutputCollector, fields ...interface{}) error {
+	if meta.Comp == "users" {
+		this.received = append(this.received, fmt.Sprintf("%s:%d", *fields[0].(*string), *fields[1].(*int)))
+	} else {
+		this.received = append(this.received, *fields[0].(*string))
+	}
+	return nil
+}
+
+func (this *basicJoinBolt) Cleanup() {}
+
+func (this *basicJoinBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+func TestWrappedInputFields(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	user := newJsonBoltMsg(ids[0], "users", "default", 1)
+	user.BoltMsgJson.Contents = append(user.BoltMsgJson.Contents, "alice", 42)
+	writeMsg(user, inBuffer, t)
+	writeMsg(testBoltMsg(1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := newBasicJoinBolt()
+	shellBolt := gostorm.NewShellBolt(gostorm.NewBasicBolt(bolt))
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	expected := []string{"alice:42", contents[1]}
+	if strings.Join(bolt.received, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, received: %v", expected, bolt.received)
+	}
+	for i := range expected {
+		expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[i]), outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+
+	checkPidFile(t)
+}
+
+type typedLengthBolt struct {
+	collector gostorm.OutputCollector
+	lengths   *gostorm.Emitter[int]
+	ticks     int
+}
+
+func (this *typedLengthBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	this.lengths = gostorm.NewEmitter[int](collector, "lengths")
+}
+
+func (this *typedLengthBolt) Execute(meta messages.BoltMsgMeta, in string) {
+	this.lengths.Emit([]string{meta.Id}, len(in))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *typedLengthBolt) Cleanup() {}
+
+func (this *typedLengthBolt) Tick(meta messages.BoltMsgMeta) {
+	this.ticks++
+}
+
+func (this *typedLengthBolt) DeclareOutputs(schema *gostorm.OutputSchema) {
+	gostorm.DeclareStream[int](schema, "lengths")
+}
+
+func TestTypedBolt(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+	writeMsg(newJsonBoltMsg(ids[1], "__system", "__tick", -1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &typedLengthBolt{}
+	shellBolt := gostorm.NewShellBolt(gostorm.NewTypedBolt[string](bolt))
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"stream":"lengths","tuple":[%d]}`, ids[0], len(contents[0])), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+	if bolt.ticks != 1 {
+		t.Fatalf("Expected the tick to reach the typed bolt, ticks: %d", bolt.ticks)
+	}
+
+	checkPidFile(t)
+}
+
+// mismatchBolt emits the length of every tuple on a stream that was
+// declared with a string field
+type mismatchBolt struct {
+	collector gostorm.OutputCollector
+}
+
+func (this *mismatchBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *mismatchBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.collector.Emit([]string{meta.Id}, "", len(*fields[0].(*string)))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *mismatchBolt) Cleanup() {}
+
+func (this *mismatchBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+func (this *mismatchBolt) DeclareOutputs(schema *gostorm.OutputSchema) {
+	schema.Declare("", "")
+}
+
+func TestEmitSchemaMismatch(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	shellBolt := gostorm.NewShellBolt(&mismatchBolt{})
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	// The mismatch is reported and the fields are emitted anyway
+	expectPid(outBuffer, t)
+	expect(`{"command":"error","msg":"gostorm: stream default field 0: emitted int, declared string"}`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"tuple":[%d]}`, ids[0], len(contents[0])), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+type wordCount struct {
+	Count  int    `storm:"1"`
+	Word   string `storm:"0"`
+	Source string `storm:"-"`
+}
+
+type wordCountBolt struct {
+	collector gostorm.OutputCollector
+	received  []wordCount
+}
+
+func (this *wordCountBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *wordCountBolt) Execute(meta messages.BoltMsgMeta, in wordCount) {
+	this.received = append(this.received, in)
+	this.collector.Emit([]string{meta.Id}, "", &wordCount{Word: in.Word, Count: in.Count + 1, Source: meta.Comp})
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *wordCountBolt) Cleanup() {}
+
+func TestTupleStruct(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	msg := newJsonBoltMsg(ids[0], "split", "default", 1)
+	msg.BoltMsgJson.Contents = append(msg.BoltMsgJson.Contents, "storm", 41)
+	writeMsg(msg, inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &wordCountBolt{}
+	shellBolt := gostorm.NewShellBolt(gostorm.NewTypedBolt[wordCount](bolt))
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	if len(bolt.received) != 1 || bolt.received[0] != (wordCount{Word: "storm", Count: 41}) {
+		t.Fatalf("Unexpected tuples: %+v", bolt.received)
+	}
+	expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"tuple":["storm",42]}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+func TestRegisterTupleStruct(t *testing.T) {
+	checkErr(stormcore.RegisterTupleStruct(&wordCount{}), t)
+	checkErr(stormcore.RegisterTupleStruct("not a struct"), t)
+
+	invalid := []interface{}{
+		struct {
+			Word string `storm:"word"`
+		}{},
+		struct {
+			Word  string `storm:"0"`
+			Count int    `storm:"0"`
+		}{},
+		struct {
+			Count int `storm:"1"`
+		}{},
+		struct {
+			word string `storm:"0"`
+		}{},
+	}
+	for _, prototype := range invalid {
+		if err := stormcore.RegisterTupleStruct(prototype); err == nil {
+			t.Fatalf("Expected the tags of %T to be rejected", prototype)
+		}
+	}
+
+	type namedCount struct {
+		Count int `storm:"count"`
+	}
+	schema := gostorm.NewOutputSchema()
+	if err := gostorm.DeclareStream[namedCount](schema, "counts"); err == nil {
+		t.Fatal("Expected a stream of tuple structs with invalid tags to be rejected")
+	}
+	checkErr(gostorm.DeclareStream[wordCount](schema, "counts"), t)
+	if streams := schema.Streams(); len(streams) != 1 || streams[0] != "counts" {
+		t.Fatalf("Expected only the valid stream to be declared, declared: %v", streams)
+	}
+}
+
+// slowBolt takes the given time to execute a tuple
+type slowBolt struct {
+	recordBolt
+	delay time.Duration
+}
+
+func (this *slowBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	time.Sleep(this.delay)
+	this.recordBolt.Execute(meta, fields...)
+}
+
+func TestWatchdogWarning(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	_, err := inBuffer.Write(bytes.Replace(conf, []byte(`"supervisor.worker.timeout.secs":30`), []byte(`"supervisor.worker.timeout.secs":0.2`), 1))
+	checkErr(err, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	shellBolt := gostorm.NewShellBolt(&slowBolt{delay: 200 * time.Millisecond})
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	checkErr(shellBolt.Go(), t)
+
+	// The warning is sent to Storm while Execute is still running
+	expectPid(outBuffer, t)
+	expectPrefix(`{"command":"log","level":3,"msg":"GoStorm: Execute has been running for `, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expectPrefix(`{"command":"log","level":3,"msg":"GoStorm: Execute returned after `, outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+// barrierBolt only returns from Execute once the given number of tuples
+// are being executed at the same time, or after a timeout
+type barrierBolt struct {
+	sync.Mutex
+	collector   gostorm.OutputCollector
+	concurrency int
+	running     int
+	release     chan struct{}
+	overlapped  int
+}
+
+func (this *barrierBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	this.release = make(chan struct{})
+}
+
+func (this *barrierBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.Lock()
+	this.running++
+	if this.running == this.concurrency {
+		close(this.release)
+	}
+	this.Unlock()
+
+	select {
+	case <-this.release:
+		this.Lock()
+		this.overlapped++
+		this.Unlock()
+	case <-time.After(time.Second):
+	}
+	this.collector.Emit([]string{meta.Id}, "", *fields[0].(*string))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *barrierBolt) Cleanup() {}
+
+func (this *barrierBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+func TestConcurrentBolt(t *testing.T) {
+	const workers = 3
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	for i := 0; i < workers; i++ {
+		writeMsg(testBoltMsg(i), inBuffer, t)
+	}
+	writeMsg(newJsonBoltMsg("", "", "__heartbeat", -1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &barrierBolt{concurrency: workers}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.SetConcurrency(workers, workers)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	if bolt.overlapped != workers {
+		t.Fatalf("Expected %d tuples to be executed concurrently, %d were", workers, bolt.overlapped)
+	}
+
+	// Every tuple is emitted before it is acked, but tuples may be interleaved
+	emitted := make(map[string]bool)
+	acked := make(map[string]bool)
+	syncs := 0
+	for outBuffer.Len() > 0 {
+		recv, err := outBuffer.ReadString('\n')
+		checkErr(err, t)
+		expect("end", outBuffer, t)
+		msg := make(map[string]interface{})
+		checkErr(json.Unmarshal([]byte(recv), &msg), t)
+		switch msg["command"] {
+		case "emit":
+			emitted[msg["anchors"].([]interface{})[0].(string)] = true
+		case "ack":
+			id := msg["id"].(string)
+			if !emitted[id] {
+				t.Fatalf("Tuple %s was acked before it was emitted", id)
+			}
+			acked[id] = true
+		case "sync":
+			syncs++
+		default:
+			t.Fatalf("Unexpected message: %s", recv)
+		}
+	}
+	if len(acked) != workers || syncs != 1 {
+		t.Fatalf("Expected %d acks and a sync, acked: %v, syncs: %d", workers, acked, syncs)
+	}
+
+	checkPidFile(t)
+}
+
+// inFlightBolt records the largest number of tuples that were read
+// before they had been executed. Its first tuple only returns once the
+// given number of tuples are in flight, or after a timeout.
+type inFlightBolt struct {
+	sync.Mutex
+	collector   gostorm.OutputCollector
+	limit       int
+	read        int
+	done        int
+	maxInFlight int
+	filled      chan struct{}
+}
+
+func (this *inFlightBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	this.filled = make(chan struct{})
+}
+
+// Fields is called whenever a tuple is read
+func (this *inFlightBolt) Fields() []interface{} {
+	this.Lock()
+	defer this.Unlock()
+	this.read++
+	if inFlight := this.read - this.done; inFlight > this.maxInFlight {
+		this.maxInFlight = inFlight
+		if inFlight == this.limit {
+			close(this.filled)
+		}
+	}
+	var content string
+	return []interface{}{&content}
+}
+
+func (this *inFlightBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	select {
+	case <-this.filled:
+	case <-time.After(time.Second):
+	}
+	this.Lock()
+	this.done++
+	this.Unlock()
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *inFlightBolt) Cleanup() {}
+
+func TestConcurrentBoltInFlight(t *testing.T) {
+	const workers, maxInFlight, tuples = 1, 3, 6
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	for i := 0; i < tuples; i++ {
+		writeMsg(genBoltMsg(ids[i], "tuple"), inBuffer, t)
+	}
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	bolt := &inFlightBolt{limit: maxInFlight}
+	shellBolt := gostorm.NewShellBolt(bolt)
+	shellBolt.SetConcurrency(workers, maxInFlight)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	if bolt.maxInFlight != maxInFlight {
+		t.Fatalf("Expected %d tuples in flight, %d were", maxInFlight, bolt.maxInFlight)
+	}
+	expectPid(outBuffer, t)
+	for i := 0; i < tuples; i++ {
+		expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[i]), outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+
+	checkPidFile(t)
+}
+
+// countingBolt counts the tuples it executes in a registered metric
+type countingBolt struct {
+	collector gostorm.OutputCollector
+	count     *stormcore.CountMetric
+}
+
+func (this *countingBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+	this.count = &stormcore.CountMetric{}
+	collector.RegisterMetric("count", this.count)
+}
+
+func (this *countingBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.count.Incr()
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *countingBolt) Cleanup() {}
+
+func (this *countingBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+func TestConcurrentMetrics(t *testing.T) {
+	const tuples = 100
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	for i := 0; i < tuples; i++ {
+		writeMsg(genBoltMsg(fmt.Sprintf("%d", i), "tuple"), inBuffer, t)
+		// Metrics are sent along with the sync that answers a heartbeat
+		writeMsg(newJsonBoltMsg("", "", "__heartbeat", -1), inBuffer, t)
+	}
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	shellBolt := gostorm.NewShellBolt(&countingBolt{})
+	shellBolt.SetConcurrency(4, 8)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	var counted float64
+	for outBuffer.Len() > 0 {
+		recv, err := outBuffer.ReadString('\n')
+		checkErr(err, t)
+		expect("end", outBuffer, t)
+		msg := make(map[string]interface{})
+		checkErr(json.Unmarshal([]byte(recv), &msg), t)
+		if msg["command"] == "metrics" {
+			counted += msg["params"].(float64)
+		}
+	}
+	// Tuples that were executed after the last heartbeat are not sent
+	if counted > tuples {
+		t.Fatalf("Counted %v tuples, only %d were executed", counted, tuples)
+	}
+
+	checkPidFile(t)
+}
+
+// echoBolt emits the content of every tuple it executes after a short
+// delay, during which the following tuples are decoded
+type echoBolt struct {
+	collector gostorm.OutputCollector
+	shared    *string
+}
+
+func (this *echoBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *echoBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	time.Sleep(time.Millisecond)
+	this.collector.Emit([]string{meta.Id}, "", *fields[0].(*string))
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *echoBolt) Cleanup() {}
+
+// Fields returns the shared content if it is set, and new content otherwise
+func (this *echoBolt) Fields() []interface{} {
+	if this.shared != nil {
+		return []interface{}{this.shared}
+	}
+	var content string
+	return []interface{}{&content}
+}
+
+func TestConcurrentBoltFields(t *testing.T) {
+	const tuples = 50
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	for i := 0; i < tuples; i++ {
+		writeMsg(genBoltMsg(fmt.Sprintf("%d", i), fmt.Sprintf("tuple %d", i)), inBuffer, t)
+	}
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	shellBolt := gostorm.NewShellBolt(&echoBolt{})
+	shellBolt.SetConcurrency(4, 8)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	emitted := 0
+	for outBuffer.Len() > 0 {
+		recv, err := outBuffer.ReadString('\n')
+		checkErr(err, t)
+		expect("end", outBuffer, t)
+		msg := make(map[string]interface{})
+		checkErr(json.Unmarshal([]byte(recv), &msg), t)
+		if msg["command"] != "emit" {
+			continue
+		}
+		// Every tuple has to be executed with its own content
+		id := msg["anchors"].([]interface{})[0].(string)
+		if content := msg["tuple"].([]interface{})[0]; content != "tuple "+id {
+			t.Fatalf("Tuple %s was executed with content %v", id, content)
+		}
+		emitted++
+	}
+	if emitted != tuples {
+		t.Fatalf("Expected %d emits, got %d", tuples, emitted)
+	}
+
+	checkPidFile(t)
+}
+
+func TestConcurrentBoltSharedFields(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+	writeMsg(testBoltMsg(1), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	var shared string
+	shellBolt := gostorm.NewShellBolt(&echoBolt{shared: &shared})
+	shellBolt.SetConcurrency(2, 2)
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+
+	defer func() {
+		msg, ok := recover().(string)
+		if !ok || !strings.Contains(msg, "requires new values for every tuple") {
+			t.Fatalf("Expected reused fields to be rejected, got: %v", msg)
+		}
+		checkPidFile(t)
+	}()
+	shellBolt.Go()
+}
+
+type basicSplitBolt struct{}
+
+func (this *basicSplitBolt) Prepare(context *messages.Context, collector gostorm.BasicOutputCollector) {
+}
+
+func (this *basicSplitBolt) Execute(meta messages.BoltMsgMeta, collector gostorm.BasicOutputCollector, fields ...interface{}) error {
+	sentence := *fields[0].(*string)
+	switch sentence {
+	case "fail":
+		return fmt.Errorf("failed")
+	case "report":
+		return &gostorm.ReportedFailure{Err: fmt.Errorf("reported")}
+	}
+	for _, word := range strings.Split(sentence, " ") {
+		collector.Emit("", word)
+	}
+	return nil
+}
+
+func (this *basicSplitBolt) Cleanup() {}
+
+func (this *basicSplitBolt) Fields() []interface{} {
+	var sentence string
+	return []interface{}{&sentence}
+}
+
+func TestBasicBolt(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(genBoltMsg(ids[0], "Call me"), inBuffer, t)
+	writeMsg(genBoltMsg(ids[1], "fail"), inBuffer, t)
+	writeMsg(genBoltMsg(ids[2], "report"), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	shellBolt := gostorm.NewShellBolt(gostorm.NewBasicBolt(&basicSplitBolt{}))
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	expectPid(outBuffer, t)
+	for _, word := range []string{"Call", "me"} {
+		expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"tuple":["%s"]}`, ids[0], word), outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"fail","id":"%s"}`, ids[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(`{"command":"error","msg":"reported"}`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"fail","id":"%s"}`, ids[2]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+// countingWriter counts the writes made to a buffer
+type countingWriter struct {
+	sync.Mutex
+	bytes.Buffer
+	writes int
+}
+
+func (this *countingWriter) Write(data []byte) (int, error) {
+	this.Lock()
+	defer this.Unlock()
+	this.writes++
+	return this.Buffer.Write(data)
+}
+
+func (this *countingWriter) Writes() int {
+	this.Lock()
+	defer this.Unlock()
+	return this.writes
+}
+
+func TestBatchOutput(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	for i := 0; i < 3; i++ {
+		writeMsg(genBoltMsg(ids[i], "Call me Ishmael"), inBuffer, t)
+	}
+
+	outBuffer := &countingWriter{}
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormcore.NewBatchOutput(stormenc.NewJsonObjectOutput(outBuffer), 100, 0)
+	shellBolt := gostorm.NewShellBolt(gostorm.NewBasicBolt(&basicSplitBolt{}))
+	shellBolt.Initialise(stormcore.NewBoltConn(input, output, false))
+	shellBolt.Go()
+
+	// All tuples were read ahead, so the output is only written before
+	// blocking on the end of the input
+	if outBuffer.Writes() != 1 {
+		t.Fatalf("Expected a single write, received %d writes", outBuffer.Writes())
+	}
+	expectPid(&outBuffer.Buffer, t)
+	for i := 0; i < 3; i++ {
+		for _, word := range []string{"Call", "me", "Ishmael"} {
+			expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"tuple":["%s"]}`, ids[i], word), &outBuffer.Buffer, t)
+			expect("end", &outBuffer.Buffer, t)
+		}
+		expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[i]), &outBuffer.Buffer, t)
+		expect("end", &outBuffer.Buffer, t)
+	}
+
+	checkPidFile(t)
+}
+
+func TestBatchOutputLatency(t *testing.T) {
+	outBuffer := &countingWriter{}
+	output := stormcore.NewBatchOutput(stormenc.NewJsonObjectOutput(outBuffer), 100, 10*time.Millisecond)
+	checkErr(output.EmitGeneric("ack", ids[0], "", "", nil, 0, false), t)
+	checkErr(output.Flush(), t)
+	if outBuffer.Writes() != 0 {
+		t.Fatal("Expected the batch to wait for the latency timer")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if outBuffer.Writes() != 1 {
+		t.Fatalf("Expected the latency timer to write the batch, received %d writes", outBuffer.Writes())
+	}
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), &outBuffer.Buffer, t)
+}
+
+func TestBatchOutputEmptyFlush(t *testing.T) {
+	outBuffer := &countingWriter{}
+	output := stormcore.NewBatchOutput(stormenc.NewJsonObjectOutput(outBuffer), 2, 0)
+	// Flushing without writing a message does not count towards the batch
+	checkErr(output.Flush(), t)
+	checkErr(output.EmitGeneric("ack", ids[0], "", "", nil, 0, false), t)
+	checkErr(output.Flush(), t)
+	if outBuffer.Writes() != 0 {
+		t.Fatalf("Expected the batch to wait for a second message, received %d writes", outBuffer.Writes())
+	}
+	checkErr(output.EmitGeneric("ack", ids[1], "", "", nil, 0, false), t)
+	checkErr(output.Flush(), t)
+	if outBuffer.Writes() != 1 {
+		t.Fatalf("Expected a full batch to be written, received %d writes", outBuffer.Writes())
+	}
+}
+
+func TestRunBoltBatching(t *testing.T) {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer outWriter.Close()
+		// Capturing stdout synchronises the batched output
+		gostorm.RunBolt(&recordBolt{}, gostorm.WithIO(inReader, outWriter), gostorm.WithBatching(100, 0), gostorm.WithStdoutLog())
+	}()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(outReader)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	expectLine := func(expected string) {
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Fatalf("Expected: %s, received: %s", expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %s to be written before blocking on reading from Storm", expected)
+		}
+	}
+
+	// Storm only sends a tuple once it has received the pid
+	go inWriter.Write(conf)
+	expectLine(fmt.Sprintf(`{"pid":%d}`, os.Getpid()))
+	expectLine("end")
+	inBuffer := bytes.NewBuffer(nil)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+	go inBuffer.WriteTo(inWriter)
+	expectLine(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]))
+	expectLine("end")
+
+	inWriter.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunBolt did not return after the input was closed")
+	}
+
+	checkPidFile(t)
+}
+
+func TestEmitWithTaskIds(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	taskIds := genTaskIdsMsg()
+	writeMsg(taskIds, inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	input := stormenc.NewJsonObjectInput(inBuffer)
+	output := stormenc.NewJsonObjectOutput(outBuffer)
+	boltConn := stormcore.NewBoltConn(input, output, false)
+	boltConn.Connect()
+	expectPid(outBuffer, t)
+
+	if received := boltConn.Emit(nil, "", contents[0]); received != nil {
+		t.Fatalf("Expected no task ids without needing them, received: %v", received)
+	}
+	expect(fmt.Sprintf(`{"command":"emit","need_task_ids":false,"tuple":["%s"]}`, contents[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	received := boltConn.EmitWithTaskIds(nil, "", contents[1])
+	if fmt.Sprint(received) != fmt.Sprint(taskIds) {
+		t.Fatalf("Expected task ids %v, received: %v", taskIds, received)
+	}
+	// Storm sends task ids unless need_task_ids is false
+	expect(fmt.Sprintf(`{"command":"emit","tuple":["%s"]}`, contents[1]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+// printingBolt writes to stdout, which must not reach Storm, and panics
+// on the tuple "panic"
+type printingBolt struct {
+	recordBolt
+}
+
+func (this *printingBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	if *fields[0].(*string) == "panic" {
+		panic("boom")
+	}
+	fmt.Println("stray output")
+	this.recordBolt.Execute(meta, fields...)
+}
+
+func TestRunBoltOptions(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	stdout := os.Stdout
+	bolt := &printingBolt{}
+	gostorm.RunBolt(bolt, gostorm.WithEncoding("jsonObject"), gostorm.WithIO(inBuffer, outBuffer), gostorm.WithStdoutRedirect())
+	if os.Stdout != stdout {
+		t.Fatal("Expected stdout to be restored")
+	}
+
+	expectPid(outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	if outBuffer.Len() > 0 {
+		t.Fatalf("Unexpected output: %s", outBuffer.String())
+	}
+
+	checkPidFile(t)
+}
+
+// blockingBolt blocks in Execute until it is released and records
+// the order in which its functions return
+type blockingBolt struct {
+	sync.Mutex
+	collector gostorm.OutputCollector
+	executing chan struct{}
+	release   chan struct{}
+	calls     []string
+}
+
+func (this *blockingBolt) record(call string) {
+	this.Lock()
+	defer this.Unlock()
+	this.calls = append(this.calls, call)
+}
+
+func (this *blockingBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *blockingBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	close(this.executing)
+	<-this.release
+	this.collector.SendAck(meta.Id)
+	this.record("Execute")
+}
+
+func (this *blockingBolt) Cleanup() {
+	this.record("Cleanup")
+}
+
+func (this *blockingBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+// concurrentBolt signals that it is executing a tuple and only returns
+// once it is released, so that it blocks unless tuples are executed
+// concurrently
+type concurrentBolt struct {
+	collector gostorm.OutputCollector
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func (this *concurrentBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	this.collector = collector
+}
+
+func (this *concurrentBolt) Execute(meta messages.BoltMsgMeta, fields ...interface{}) {
+	this.started <- struct{}{}
+	<-this.release
+	this.collector.SendAck(meta.Id)
+}
+
+func (this *concurrentBolt) Cleanup() {}
+
+func (this *concurrentBolt) Fields() []interface{} {
+	var content string
+	return []interface{}{&content}
+}
+
+func TestRunBoltShellBoltOptions(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(genBoltMsg(ids[0], 42), inBuffer, t)
+	writeMsg(testBoltMsg(1), inBuffer, t)
+	writeMsg(testBoltMsg(2), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	bolt := &concurrentBolt{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	done := make(chan error)
+	go func() {
+		done <- gostorm.RunBolt(bolt, gostorm.WithIO(inBuffer, outBuffer), gostorm.WithConcurrency(2, 2), gostorm.WithDecodeErrorPolicy(gostorm.DeadLetterOnDecodeError))
+	}()
+
+	// Both valid tuples are executed at the same time
+	for i := 0; i < 2; i++ {
+		select {
+		case <-bolt.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the tuples to be executed concurrently")
+		}
+	}
+	close(bolt.release)
+	checkErr(<-done, t)
+
+	expectPid(outBuffer, t)
+	expectPrefix(`{"command":"log"`, outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"anchors":["%s"],"command":"emit","need_task_ids":false,"stream":"%s","tuple":[42]}`, ids[0], gostorm.DeadLetterStream), outBuffer, t)
+	expect("end", outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+	acks := strings.Split(strings.TrimSuffix(outBuffer.String(), "\nend\n"), "\nend\n")
+	sort.Strings(acks)
+	expected := []string{
+		fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[1]),
+		fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[2]),
+	}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(acks, expected) {
+		t.Fatalf("Expected: %v, received: %v", expected, acks)
+	}
+
+	checkPidFile(t)
+}
+
+func TestRunBoltSignal(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(testBoltMsg(0), inBuffer, t)
+	// Storm keeps the input open while the bolt is running
+	inReader, inWriter := io.Pipe()
+	defer inWriter.Close()
+	go inBuffer.WriteTo(inWriter)
+
+	outBuffer := bytes.NewBuffer(nil)
+	bolt := &blockingBolt{
+		executing: make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gostorm.RunBolt(bolt, gostorm.WithIO(inReader, outBuffer), gostorm.WithSignalHandling())
+	}()
+
+	<-bolt.executing
+	process, err := os.FindProcess(os.Getpid())
+	checkErr(err, t)
+	checkErr(process.Signal(os.Interrupt), t)
+
+	// The bolt is only cleaned up once the tuple it is executing returns
+	time.Sleep(50 * time.Millisecond)
+	bolt.Lock()
+	if len(bolt.calls) > 0 {
+		t.Errorf("Bolt was cleaned up while executing a tuple: %v", bolt.calls)
+	}
+	bolt.Unlock()
+	close(bolt.release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunBolt did not return after a signal")
+	}
+	if !reflect.DeepEqual(bolt.calls, []string{"Execute", "Cleanup"}) {
+		t.Fatalf("Unexpected calls: %v", bolt.calls)
+	}
+	expectPid(outBuffer, t)
+	expect(fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]), outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+func TestRunBoltStdoutLog(t *testing.T) {
+	options := map[string][]gostorm.Option{
+		"os.Stdout": {gostorm.WithStdoutLog()},
+		"fd":        {gostorm.WithStdoutLog(), gostorm.WithStdoutFd()},
+	}
+	for name, opts := range options {
+		t.Run(name, func(t *testing.T) {
+			if name == "fd" && (runtime.GOOS == "windows" || runtime.GOOS == "plan9") {
+				t.Skip("Redirecting file descriptor 1 is not supported")
+			}
+			inBuffer := bytes.NewBuffer(nil)
+			feedConf(inBuffer, t)
+			writeMsg(testBoltMsg(0), inBuffer, t)
+
+			outBuffer := bytes.NewBuffer(nil)
+			stdout := os.Stdout
+			opts = append(opts, gostorm.WithIO(inBuffer, outBuffer))
+			gostorm.RunBolt(&printingBolt{}, opts...)
+			if os.Stdout != stdout {
+				t.Fatal("Expected stdout to be restored")
+			}
+
+			expectPid(outBuffer, t)
+			// The captured line is logged concurrently with the ack
+			received := strings.Split(strings.TrimSuffix(outBuffer.String(), "\nend\n"), "\nend\n")
+			sort.Strings(received)
+			expected := []string{
+				fmt.Sprintf(`{"command":"ack","id":"%s"}`, ids[0]),
+				`{"command":"log","msg":"stray output"}`,
+			}
+			if strings.Join(received, "\n") != strings.Join(expected, "\n") {
+				t.Fatalf("Expected: %v, received: %v", expected, received)
+			}
+
+			checkPidFile(t)
+		})
+	}
+}
+
+// preparePrintingBolt writes more to stdout in Prepare than a pipe holds
+type preparePrintingBolt struct {
+	recordBolt
+	lines int
+}
+
+func (this *preparePrintingBolt) Prepare(context *messages.Context, collector gostorm.OutputCollector) {
+	for i := 0; i < this.lines; i++ {
+		fmt.Printf("line %d %s\n", i, strings.Repeat("x", 100))
+	}
+	this.recordBolt.Prepare(context, collector)
+}
+
+func TestRunBoltStdoutLogPrepare(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	bolt := &preparePrintingBolt{lines: 2000}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gostorm.RunBolt(bolt, gostorm.WithIO(inBuffer, outBuffer), gostorm.WithStdoutLog())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writing to stdout in Prepare blocked")
+	}
+
+	// Lines written before the bolt connected are logged once it has
+	expectPid(outBuffer, t)
+	for i := 0; i < bolt.lines; i++ {
+		expect(fmt.Sprintf(`{"command":"log","msg":"line %d %s"}`, i, strings.Repeat("x", 100)), outBuffer, t)
+		expect("end", outBuffer, t)
+	}
+
+	checkPidFile(t)
+}
+
+func TestRunBoltPanicRecovery(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	feedConf(inBuffer, t)
+	writeMsg(genBoltMsg(ids[0], "panic"), inBuffer, t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != "boom" {
+				t.Fatalf("Expected the panic to continue, recovered: %v", recovered)
+			}
+		}()
+		gostorm.RunBolt(&printingBolt{}, gostorm.WithIO(inBuffer, outBuffer), gostorm.WithPanicRecovery())
+	}()
+
+	expectPid(outBuffer, t)
+	expectPrefix(`{"command":"error","msg":"panic: boom\n`, outBuffer, t)
+	expect("end", outBuffer, t)
+
+	checkPidFile(t)
+}
+
+func TestRunBoltWorkerPanicRecovery(t *testing.T) {
+	// A panic on a worker goroutine crashes the process, so the bolt
+	// runs in a subprocess that writes to stdout
+	if os.Getenv("GOSTORM_TEST_WORKER_PANIC") == "1" {
+		inBuffer := bytes.NewBuffer(nil)
+		feedConf(inBuffer, t)
+		writeMsg(genBoltMsg(ids[0], "panic"), inBuffer, t)
+		gostorm.RunBolt(&printingBolt{}, gostorm.WithIO(inBuffer, os.Stdout), gostorm.WithConcurrency(2, 2), gostorm.WithPanicRecovery())
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRunBoltWorkerPanicRecovery$")
+	cmd.Env = append(os.Environ(), "GOSTORM_TEST_WORKER_PANIC=1", pidDirEnv+"="+pidDir)
+	out, err := cmd.Output()
+	if err == nil {
+		t.Fatal("Expected the panic to continue")
+	}
+	outBuffer := bytes.NewBuffer(out)
+	expect(fmt.Sprintf(`{"pid":%d}`, cmd.Process.Pid), outBuffer, t)
+	expect("end", outBuffer, t)
+	expectPrefix(`{"command":"error","msg":"panic: boom\n`, outBuffer, t)
+}
+
+func TestMsgpackBolt(t *testing.T) {
+	inBuffer := bytes.NewBuffer(nil)
+	stormOutput := stormmsgpack.NewMsgpackOutput(inBuffer)
+	context := &messages.Context{
+		PidDir: pidDir,
+		Topology: &messages.Topology{
+			TaskId: 3,
+			TaskComponentMappings: []*messages.TaskComponentMapping{
+				{Task: "3", Component: "split"},
+			},
+		},
+	}
+	checkErr(stormOutput.SendMsg(context), t)
+	checkErr(stormOutput.SendMsg(&messages.BoltMsg{
+		BoltMsgJson: &messages.BoltMsgJson{
+			BoltMsgMeta: &messages.BoltMsgMeta{Id: ids[0], Comp: "spout", Stream: "default", Task: 4},
+			Contents:    []interface{}{contents[0]},
+		},
+	}), t)
+	checkErr(stormOutput.Flush(), t)
+
+	outBuffer := bytes.NewBuffer(nil)
+	bolt := &recordBolt{}
+	gostorm.RunBolt(bolt, gostorm.WithEncoding("msgpack"), gostorm.WithIO(inBuffer, outBuffer))
+	if len(bolt.received) != 1 || bolt.received[0] != contents[0] {
+		t.Fatalf("Expected the bolt to receive %s, received: %v", contents[0], bolt.received)
+	}
+
+	stormInput := stormmsgpack.NewMsgpackInput(outBuffer)
+	pid := &messages.Pid{}
+	checkErr(stormInput.ReadMsg(pid), t)
+	if int(pid.Pid) != os.Getpid() {
+		t.Fatalf("Expected pid %d, received: %d", os.Getpid(), pid.Pid)
+	}
+	ack := &messages.ShellMsg{
+		ShellMsgJson: &messages.ShellMsgJson{},
+	}
+	checkErr(stormInput.ReadMsg(ack), t)
+	if meta := ack.ShellMsgJson.ShellMsgMeta; meta.Command != "ack" || meta.GetId() != ids[0] {
+		t.Fatalf("Expected an ack of %s, received: %+v", ids[0], meta)
+	}
+
+	checkPidFile(t)
+}
+
+type payloadStruct struct {
+	Name   string
+	Number int64
+}
+
+// newRawBoltMsg returns a tuple with the given encoded field, which can
+// be sent by every envelope
+func newRawBoltMsg(field []byte) *messages.BoltMsg {
+	meta := &messages.BoltMsgMeta{Id: ids[0], Comp: "spout", Stream: "default", Task: 4}
+	return &messages.BoltMsg{
+		BoltMsgProto: &messages.BoltMsgProto{
+			BoltMsgMeta: meta,
+			Contents:    [][]byte{field},
+		},
+		BoltMsgJson: &messages.BoltMsgJson{
+			BoltMsgMeta: meta,
+			Contents:    []interface{}{field},
+		},
+	}
+}
+
+// readEmittedField reads an emission with a single field and returns the
+// encoded field
+func readEmittedField(envelope string, buffer io.Reader, t *testing.T) []byte {
+	field := new([]byte)
+	shellMsg := &messages.ShellMsg{
+		ShellMsgProto: &messages.ShellMsgProto{},
+		ShellMsgJson: &messages.ShellMsgJson{
+			Contents: []interface{}{field},
+		},
+	}
+	checkErr(stormcore.LookupInput(envelope+"+bytes", buffer).ReadMsg(shellMsg), t)
+	if envelope == "protobuf" || envelope == "protobufTagged" {
+		return shellMsg.ShellMsgProto.Contents[0]
+	}
+	return *field
+}
+
+func TestPayloadCodecs(t *testing.T) {
+	text, data := "string", []byte("bytes")
+	fields := map[string]struct {
+		out interface{}
+		in  func() interface{}
+	}{
+		"json":    {&payloadStruct{"json", 1}, func() interface{} { return &payloadStruct{} }},
+		"gob":     {&payloadStruct{"gob", 2}, func() interface{} { return &payloadStruct{} }},
+		"msgpack": {&payloadStruct{"msgpack", 3}, func() interface{} { return &payloadStruct{} }},
+		"proto":   {&messages.Test{Name: "proto", Number: 4}, func() interface{} { return &messages.Test{} }},
+		"string":  {&text, func() interface{} { return new(string) }},
+		"bytes":   {&data, func() interface{} { return new([]byte) }},
+	}
+	for _, envelope := range []string{"json", "protobuf", "protobufTagged", "msgpack"} {
+		for payload, field := range fields {
+			encoding := envelope + "+" + payload
+			codec := stormcore.LookupPayloadCodec(payload)
+			buffer := bytes.NewBuffer(nil)
+
+			output := stormcore.LookupOutput(encoding, buffer)
+			checkErr(output.EmitGeneric("emit", "", "", "", nil, 0, false, field.out), t)
+			checkErr(output.Flush(), t)
+			in := field.in()
+			checkErr(codec.Unmarshal(readEmittedField(envelope, buffer, t), in), t)
+			if !reflect.DeepEqual(in, field.out) {
+				t.Fatalf("%s: Expected to emit %v, emitted: %v", encoding, field.out, in)
+			}
+
+			encoded, err := codec.Marshal(field.out)
+			checkErr(err, t)
+			stormOutput := stormcore.LookupOutput(envelope+"+bytes", buffer)
+			checkErr(stormOutput.SendMsg(newRawBoltMsg(encoded)), t)
+			checkErr(stormOutput.Flush(), t)
+			in = field.in()
+			meta := &messages.BoltMsgMeta{}
+			checkErr(stormcore.LookupInput(encoding, buffer).ReadBoltMsg(meta, in), t)
+			if meta.Id != ids[0] || !reflect.DeepEqual(in, field.out) {
+				t.Fatalf("%s: Expected to read tuple %s with %v, read: %s with %v", encoding, ids[0], field.out, meta.Id, in)
+			}
+		}
+
+		// Raw fields are sent without being encoded by the payload codec
+		buffer := bytes.NewBuffer(nil)
+		output := stormcore.LookupOutput(envelope+"+json", buffer)
+		checkErr(output.EmitGeneric("emit", "", "", "", nil, 0, false, stormcore.RawField("raw")), t)
+		checkErr(output.Flush(), t)
+		if raw := readEmittedField(envelope, buffer, t); string(raw) != "raw" {
+			t.Fatalf("%s: Expected the raw field to be sent as is, received: %s", envelope, raw)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected an unregistered payload codec to panic")
+		}
+	}()
+	stormcore.LookupInput("json+unknown", bytes.NewBuffer(nil))
+}
@@ -0,0 +1,163 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"fmt"
+	"github.com/jsgilmore/gostorm/core"
+	stormmsg "github.com/jsgilmore/gostorm/messages"
+)
+
+// TypedBolt is a bolt that receives tuples with a single field of type
+// In, which is decoded by GoStorm. Tuples with several fields can be
+// received by using a struct with storm tags (see core.TupleFields)
+// as In, of which the tags can be checked up front using
+// core.RegisterTupleStruct. A TypedBolt is run by adapting it to
+// a Bolt with NewTypedBolt. The optional interfaces of bolts, such as
+// Ticker and OutputDeclarer, may be implemented by a TypedBolt.
+type TypedBolt[In any] interface {
+	Prepare(context *stormmsg.Context, collector OutputCollector)
+	Execute(meta stormmsg.BoltMsgMeta, in In)
+	Cleanup()
+}
+
+// NewTypedBolt returns a Bolt that decodes the field of every tuple
+// into a new In and passes it to the Execute function of the typed bolt
+func NewTypedBolt[In any](bolt TypedBolt[In]) Bolt {
+	return &typedBolt[In]{
+		bolt: bolt,
+	}
+}
+
+type typedBolt[In any] struct {
+	bolt      TypedBolt[In]
+	collector OutputCollector
+}
+
+func (this *typedBolt[In]) Prepare(context *stormmsg.Context, collector OutputCollector) {
+	this.collector = collector
+	this.bolt.Prepare(context, collector)
+}
+
+// Execute passes the decoded field of a tuple to the typed bolt. A tuple
+// of which the fields were not decoded into an In, which can happen in
+// local mode if the tuple has a different number of fields, is reported
+// and failed.
+func (this *typedBolt[In]) Execute(meta stormmsg.BoltMsgMeta, fields ...interface{}) {
+	var in *In
+	if len(fields) == 1 {
+		in, _ = fields[0].(*In)
+	}
+	if in == nil {
+		this.collector.ReportError(fmt.Errorf("TypedBolt: Fields of tuple %s were not decoded into %T: %v", meta.Id, in, fields))
+		this.collector.SendFail(meta.Id)
+		return
+	}
+	this.bolt.Execute(meta, *in)
+}
+
+func (this *typedBolt[In]) Cleanup() {
+	this.bolt.Cleanup()
+}
+
+func (this *typedBolt[In]) Fields() []interface{} {
+	return []interface{}{new(In)}
+}
+
+// Unwrap returns the typed bolt, so that its optional interfaces are found
+func (this *typedBolt[In]) Unwrap() interface{} {
+	return this.bolt
+}
+
+// Emitter emits tuples with a single field of type Out on a stream of a bolt
+type Emitter[Out any] struct {
+	collector OutputCollector
+	stream    string
+}
+
+// NewEmitter returns an emitter for the given stream of a bolt's output collector
+func NewEmitter[Out any](collector OutputCollector, stream string) *Emitter[Out] {
+	return &Emitter[Out]{
+		collector: collector,
+		stream:    stream,
+	}
+}
+
+// Emit emits a tuple anchored to the given tuple ids
+func (this *Emitter[Out]) Emit(anchors []string, out Out) (taskIds []int32) {
+	return this.collector.Emit(anchors, this.stream, out)
+}
+
+// EmitDirect emits a tuple anchored to the given tuple ids to the given task
+func (this *Emitter[Out]) EmitDirect(anchors []string, directTask int64, out Out) {
+	this.collector.EmitDirect(anchors, this.stream, directTask, out)
+}
+
+// SpoutEmitter emits tuples with a single field of type Out on a stream of a spout
+type SpoutEmitter[Out any] struct {
+	collector SpoutOutputCollector
+	stream    string
+}
+
+// NewSpoutEmitter returns an emitter for the given stream of a spout's output collector
+func NewSpoutEmitter[Out any](collector SpoutOutputCollector, stream string) *SpoutEmitter[Out] {
+	return &SpoutEmitter[Out]{
+		collector: collector,
+		stream:    stream,
+	}
+}
+
+// Emit emits a tuple with the given message id. An empty id emits the
+// tuple unreliably.
+func (this *SpoutEmitter[Out]) Emit(id string, out Out) (taskIds []int32) {
+	return this.collector.Emit(id, this.stream, out)
+}
+
+// EmitDirect emits a tuple with the given message id to the given task
+func (this *SpoutEmitter[Out]) EmitDirect(id string, directTask int64, out Out) {
+	this.collector.EmitDirect(id, this.stream, directTask, out)
+}
+
+// DeclareStream declares a stream with a single field of type Out. If Out
+// is a tuple struct, its storm tags are checked using
+// core.RegisterTupleStruct and the stream is not declared if they are
+// invalid.
+func DeclareStream[Out any](schema *OutputSchema, stream string) error {
+	var prototype Out
+	if err := core.RegisterTupleStruct(prototype); err != nil {
+		return err
+	}
+	schema.Declare(stream, prototype)
+	return nil
+}
+
+// As finds the first component in the chain formed by the given
+// component and the components it wraps that implements T, which is
+// typically an optional interface such as Ticker. Adapters such as the
+// one returned by NewTypedBolt wrap components by implementing
+// Unwrap() interface{}.
+func As[T any](component interface{}) (found T, ok bool) {
+	for component != nil {
+		if found, ok = component.(T); ok {
+			return found, true
+		}
+		wrapper, isWrapper := component.(interface{ Unwrap() interface{} })
+		if !isWrapper {
+			break
+		}
+		component = wrapper.Unwrap()
+	}
+	return found, false
+}
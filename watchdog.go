@@ -0,0 +1,157 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jsgilmore/gostorm/messages"
+)
+
+// subprocessTimeout returns the time after which Storm kills a shell
+// component that has not responded to a heartbeat, or zero if the
+// timeout is not configured.
+func subprocessTimeout(context *messages.Context) time.Duration {
+	for _, key := range []string{"topology.subprocess.timeout.secs", "supervisor.worker.timeout.secs"} {
+		value, ok := context.Conf(key)
+		if !ok {
+			continue
+		}
+		secs, err := strconv.ParseFloat(value, 64)
+		if err != nil || secs <= 0 {
+			continue
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	return 0
+}
+
+// watchdog logs when a bolt or spout function has been running for
+// long enough to risk Storm killing the process, because it cannot
+// respond to heartbeats. Warnings are sent to Storm as log messages
+// from the watchdog's goroutine, so the connection has to be safe for
+// concurrent use; warnings that cannot be sent are written to stderr.
+// Several functions may be running at once when a bolt executes tuples
+// concurrently.
+type watchdog struct {
+	sync.Mutex
+	threshold time.Duration
+	calls     map[*watchdogCall]struct{}
+	now       func() time.Time
+	warn      func(msg string)
+	done      chan struct{}
+}
+
+// watchdogCall is a running function
+type watchdogCall struct {
+	callback string
+	started  time.Time
+	warned   bool
+}
+
+// newWatchdog returns a watchdog that warns about functions that run
+// for longer than half of the given subprocess timeout, using the given
+// logger. No watchdog is returned if the timeout is zero.
+func newWatchdog(timeout time.Duration, warn func(msg string)) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	return &watchdog{
+		threshold: timeout / 2,
+		calls:     make(map[*watchdogCall]struct{}),
+		now:       time.Now,
+		warn:      warn,
+	}
+}
+
+// start checks the running functions periodically until stop is called
+func (this *watchdog) start() {
+	if this == nil {
+		return
+	}
+	done := make(chan struct{})
+	this.done = done
+	interval := this.threshold / 4
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				this.check()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func (this *watchdog) stop() {
+	if this == nil || this.done == nil {
+		return
+	}
+	close(this.done)
+	this.done = nil
+}
+
+// enter records that the named function has started running. The
+// returned call has to be passed to exit once the function returns.
+func (this *watchdog) enter(callback string) *watchdogCall {
+	if this == nil {
+		return nil
+	}
+	this.Lock()
+	defer this.Unlock()
+	call := &watchdogCall{
+		callback: callback,
+		started:  this.now(),
+	}
+	this.calls[call] = struct{}{}
+	return call
+}
+
+// exit records that a running function has returned
+func (this *watchdog) exit(call *watchdogCall) {
+	if this == nil {
+		return
+	}
+	this.Lock()
+	defer this.Unlock()
+	if call.warned {
+		logLine(this.warn, fmt.Sprintf("GoStorm: %s returned after %v", call.callback, this.now().Sub(call.started)))
+	}
+	delete(this.calls, call)
+}
+
+// check logs a warning once for every function that has been running
+// for longer than the threshold
+func (this *watchdog) check() {
+	this.Lock()
+	defer this.Unlock()
+	now := this.now()
+	for call := range this.calls {
+		if call.warned {
+			continue
+		}
+		running := now.Sub(call.started)
+		if running >= this.threshold {
+			call.warned = true
+			logLine(this.warn, fmt.Sprintf("GoStorm: %s has been running for %v, Storm kills components that do not respond to heartbeats within %v", call.callback, running, 2*this.threshold))
+		}
+	}
+}
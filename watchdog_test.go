@@ -0,0 +1,99 @@
+//   Copyright 2013 Vastech SA (PTY) LTD
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package gostorm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jsgilmore/gostorm/messages"
+)
+
+func TestSubprocessTimeout(t *testing.T) {
+	context := &messages.Context{
+		Confs: []*messages.Conf{
+			{Key: "supervisor.worker.timeout.secs", Value: "30"},
+			{Key: "topology.subprocess.timeout.secs", Value: "<nil>"},
+		},
+	}
+	if timeout := subprocessTimeout(context); timeout != 30*time.Second {
+		t.Fatalf("Expected the worker timeout to be used, received: %v", timeout)
+	}
+
+	context.Confs[1].Value = "10"
+	if timeout := subprocessTimeout(context); timeout != 10*time.Second {
+		t.Fatalf("Expected the subprocess timeout to be used, received: %v", timeout)
+	}
+
+	if timeout := subprocessTimeout(&messages.Context{}); timeout != 0 {
+		t.Fatalf("Expected no timeout, received: %v", timeout)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	now := time.Unix(0, 0)
+	var logged []string
+	dog := newWatchdog(10*time.Second, func(msg string) {
+		logged = append(logged, msg)
+	})
+	dog.now = func() time.Time { return now }
+
+	call := dog.enter("Execute")
+	now = now.Add(4 * time.Second)
+	dog.check()
+	if len(logged) != 0 {
+		t.Fatalf("Unexpected warning: %v", logged)
+	}
+
+	now = now.Add(time.Second)
+	dog.check()
+	dog.check()
+	if len(logged) != 1 {
+		t.Fatalf("Expected a single warning, logged: %v", logged)
+	}
+
+	dog.exit(call)
+	if len(logged) != 2 {
+		t.Fatalf("Expected the return of a slow function to be logged, logged: %v", logged)
+	}
+
+	// No checks are performed while no function is running
+	now = now.Add(time.Minute)
+	dog.check()
+	if len(logged) != 2 {
+		t.Fatalf("Unexpected warning while idle: %v", logged)
+	}
+
+	// Concurrent functions are warned about separately
+	first := dog.enter("Execute")
+	now = now.Add(3 * time.Second)
+	second := dog.enter("Tick")
+	now = now.Add(2 * time.Second)
+	dog.check()
+	if len(logged) != 3 {
+		t.Fatalf("Expected a warning for the first function only, logged: %v", logged)
+	}
+	dog.exit(first)
+	now = now.Add(3 * time.Second)
+	dog.check()
+	dog.exit(second)
+	if len(logged) != 6 {
+		t.Fatalf("Expected a warning and return for both functions, logged: %v", logged)
+	}
+
+	if newWatchdog(0, nil) != nil {
+		t.Fatal("Expected no watchdog without a timeout")
+	}
+}